@@ -3,8 +3,8 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -12,24 +12,54 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/spf13/cobra"
+	"github.com/thxssio/CamOpen/emulator"
 	"github.com/thxssio/CamOpen/libipcamera"
 	"github.com/thxssio/CamOpen/rtsp"
-	"github.com/spf13/cobra"
 )
 
-func connectAndLogin(ip net.IP, port int, username, password string, verbose bool) *libipcamera.Camera {
+func connectAndLogin(ip net.IP, port int, username, password string, verbose, experimental bool, iface string, tracer libipcamera.Tracer) *libipcamera.Camera {
 	camera, err := libipcamera.CreateCamera(ip, port, username, password)
 	if err != nil {
 		log.Printf("ERRO ao instanciar câmera: %s\n", err)
 		os.Exit(1)
 	}
 	camera.SetVerbose(verbose)
-	camera.Connect()
-	camera.Login()
+	camera.EnableExperimentalCommands(experimental)
+	if iface != "" {
+		dialer, err := libipcamera.InterfaceDialer(iface)
+		if err != nil {
+			log.Printf("ERRO ao usar a interface %s: %s\n", iface, err)
+			os.Exit(1)
+		}
+		camera.SetDialer(dialer)
+	}
+	if tracer != nil {
+		camera.SetTracer(tracer)
+	}
+	err = camera.Connect()
+	if err != nil {
+		log.Printf("ERRO ao conectar à câmera: %s\n", err)
+		os.Exit(1)
+	}
+	err = camera.Login()
+	if err != nil {
+		log.Printf("ERRO ao fazer login na câmera: %s\n", err)
+		return camera
+	}
+	// Selects the model profile, so unsupported commands fail right away.
+	if _, err := camera.GetFirmware(); err != nil && verbose {
+		log.Printf("Não foi possível identificar o modelo da câmera: %s\n", err)
+	}
 
 	return camera
 }
@@ -39,10 +69,19 @@ func main() {
 	var password string
 	var port int16
 	var verbose bool
+	var experimental bool
 	var cpuprofile string
 	var memoryprofile string
+	var reconnect bool
+	var iface string
+	var traceFileName string
+	var profilesFileName string
+	var discovery libipcamera.DiscoveryConfig
+	var discoverySubnets, discoveryAddresses []string
 
 	var cpuprofileFile *os.File
+	var traceFile *os.File
+	var tracer libipcamera.Tracer
 
 	var camera *libipcamera.Camera
 
@@ -54,15 +93,33 @@ func main() {
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			defer camera.Disconnect()
-			relay := libipcamera.CreateRTPRelay(applicationContext, net.ParseIP("127.0.0.1"), 5220)
+			relay, err := libipcamera.CreateRTPRelayOnPort(applicationContext, camera.StreamPort(), net.ParseIP("127.0.0.1"), 5220)
+			if err != nil {
+				log.Printf("ERRO ao criar o relay RTP: %s\n", err)
+				return
+			}
 			defer relay.Stop()
+			if tracer != nil {
+				relay.SetTracer(tracer)
+			}
 
 			camera.StartPreviewStream()
 
-			bufio.NewReader(os.Stdin).ReadBytes('\n')
+			enter := make(chan struct{})
+			go func() {
+				bufio.NewReader(os.Stdin).ReadBytes('\n')
+				close(enter)
+			}()
+			select {
+			case <-enter:
+			case <-relay.Done():
+			}
+			if err := relay.Err(); err != nil {
+				log.Printf("ERRO no relay RTP: %s\n", err)
+			}
 		},
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			signalChannel := make(chan os.Signal)
+			signalChannel := make(chan os.Signal, 1)
 			signal.Notify(signalChannel, os.Interrupt)
 			var cancel context.CancelFunc
 			applicationContext, cancel = context.WithCancel(context.Background())
@@ -75,6 +132,50 @@ func main() {
 				}
 			}(cancel)
 
+			if profilesFileName != "" {
+				file, err := os.Open(profilesFileName)
+				if err != nil {
+					log.Printf("ERRO ao abrir o arquivo de perfis: %s\n", err)
+					os.Exit(1)
+				}
+				_, err = libipcamera.LoadModelProfiles(file)
+				file.Close()
+				if err != nil {
+					log.Printf("ERRO: %s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if iface != "" {
+				discovery.Interfaces = []string{iface}
+			}
+			for _, cidr := range discoverySubnets {
+				_, subnet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					log.Printf("ERRO: sub-rede inválida %q: %s\n", cidr, err)
+					os.Exit(1)
+				}
+				discovery.Subnets = append(discovery.Subnets, subnet)
+			}
+			for _, address := range discoveryAddresses {
+				ip := net.ParseIP(address)
+				if ip == nil {
+					log.Printf("ERRO: endereço inválido %q\n", address)
+					os.Exit(1)
+				}
+				discovery.Addresses = append(discovery.Addresses, ip)
+			}
+
+			if traceFileName != "" {
+				var err error
+				traceFile, err = os.OpenFile(traceFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					log.Printf("ERRO ao criar o arquivo de rastreamento: %s\n", err)
+					os.Exit(1)
+				}
+				tracer = libipcamera.NewTraceWriter(traceFile)
+			}
+
 			if cpuprofile != "" {
 				cpuprofileFile, err := os.Create(cpuprofile)
 				if err != nil {
@@ -89,9 +190,9 @@ func main() {
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
@@ -100,6 +201,7 @@ func main() {
 		PersistentPostRun: func(cmd *cobra.Command, args []string) {
 			pprof.StopCPUProfile()
 			cpuprofileFile.Close()
+			traceFile.Close()
 
 			runtime.GC()
 			if memoryprofile != "" {
@@ -121,6 +223,17 @@ func main() {
 	rootCmd.PersistentFlags().StringVarP(&username, "nome de usuário", "u", "admin", "Especifique o nome de usuário da câmera")
 	rootCmd.PersistentFlags().StringVarP(&password, "senha", "p", "12345", "Especifique a senha da câmera")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "detalhe", "d", false, "Imprimir saída detalhada")
+	rootCmd.PersistentFlags().BoolVar(&experimental, "experimental", false, "Permitir comandos cujos IDs ainda não foram verificados em uma câmera real")
+	rootCmd.PersistentFlags().StringVarP(&iface, "interface", "i", "", "Conectar à câmera e descobri-la através da interface de rede especificada")
+	rootCmd.PersistentFlags().StringSliceVar(&discoverySubnets, "sub-rede", nil, "Sub-redes IPv4 em que a descoberta transmite, ex. 192.168.1.0/24")
+	rootCmd.PersistentFlags().StringSliceVar(&discoveryAddresses, "endereco", nil, "Endereços sondados diretamente pela descoberta, ex. 192.168.100.1")
+	rootCmd.PersistentFlags().IntSliceVar(&discovery.Ports, "portas-descoberta", nil, "Portas UDP de descoberta das câmeras (padrão: as dos perfis de modelo)")
+	rootCmd.PersistentFlags().IntVar(&discovery.ListenPort, "porta-local", libipcamera.DefaultDiscoveryListenPort, "Porta UDP local em que as respostas da descoberta são recebidas")
+	rootCmd.PersistentFlags().BoolVar(&discovery.EphemeralPort, "porta-efemera", false, "Receber as respostas da descoberta em uma porta escolhida pelo sistema")
+	rootCmd.PersistentFlags().IntVar(&discovery.Retries, "tentativas", libipcamera.DefaultDiscoveryRetries, "Quantas vezes as requisições de descoberta são enviadas")
+	rootCmd.PersistentFlags().DurationVar(&discovery.Interval, "intervalo", libipcamera.DefaultDiscoveryInterval, "Intervalo entre as requisições de descoberta")
+	rootCmd.PersistentFlags().StringVar(&traceFileName, "rastro", "", "Gravar cada mensagem trocada com a câmera neste arquivo JSONL")
+	rootCmd.PersistentFlags().StringVar(&profilesFileName, "perfis", "", "Arquivo JSON com perfis de modelos adicionais")
 	rootCmd.PersistentFlags().StringVarP(&cpuprofile, "cpuprofile", "c", "", "Uso da CPU do perfil")
 	rootCmd.PersistentFlags().StringVarP(&memoryprofile, "memoryprofile", "m", "", "Uso de memória do perfil")
 
@@ -144,9 +257,9 @@ func main() {
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
@@ -154,108 +267,250 @@ func main() {
 		},
 	}
 
+	var discoveryJSON bool
+	var discoveryWindow time.Duration
 	var discover = &cobra.Command{
 		Use:   "discover",
-		Short: "Tente descobrir uma câmera enviando transmissões UDP",
+		Short: "Descubra todas as câmeras da rede enviando transmissões UDP",
 		Args:  cobra.MaximumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-			cameraIP, err := libipcamera.AutodiscoverCamera(verbose)
+			minLevel := libipcamera.LevelInfo
+			if verbose {
+				minLevel = libipcamera.LevelDebug
+			}
+			config := discovery
+			config.Window = discoveryWindow
+			config.Logger = libipcamera.NewStdLogger(nil, minLevel)
+			cameras, err := libipcamera.Discover(applicationContext, config)
 			if err != nil {
-				log.Printf("ERRO ao descobrir a câmera: %s\n", err)
+				log.Printf("ERRO ao descobrir câmeras: %s\n", err)
+				os.Exit(1)
 			}
-
-			log.Printf("Câmera encontrada: %+v\n", cameraIP)
+			if discoveryJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				encoder.Encode(cameras)
+				return
+			}
+			if len(cameras) == 0 {
+				log.Printf("Nenhuma câmera encontrada\n")
+				return
+			}
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "ENDEREÇO\tDESCOBERTA\tRESPOSTA")
+			for _, camera := range cameras {
+				ports := make([]string, len(camera.DiscoveryPorts))
+				for i, port := range camera.DiscoveryPorts {
+					ports[i] = strconv.Itoa(port)
+				}
+				payload := hex.EncodeToString(camera.Payload)
+				if len(payload) > 32 {
+					payload = payload[:32] + "..."
+				}
+				fmt.Fprintf(table, "%s\t%s\t%d bytes %s\n", camera.Address, strings.Join(ports, ","), len(camera.Payload), payload)
+			}
+			table.Flush()
+			fmt.Println("O formato da resposta é desconhecido: nome, MAC, modelo e portas da câmera não são extraídos dela. Use --json para ver a resposta completa em hexadecimal.")
 		},
 	}
+	discover.Flags().BoolVar(&discoveryJSON, "json", false, "Mostrar as câmeras encontradas em JSON")
+	discover.Flags().DurationVar(&discoveryWindow, "janela", libipcamera.DefaultDiscoveryWindow, "Tempo de espera por respostas")
 
+	var group, groupsFile string
 	var still = &cobra.Command{
 		Use:   "still [Cameras IP Address]",
 		Short: "Tire uma foto e salve no cartão SD",
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				runGroupAction(applicationContext, groupsFile, group, verbose, tracer, (*libipcamera.CameraGroup).TakePicture)
+				return
+			}
 			camera.TakePicture()
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				return
+			}
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
-			camera.Disconnect()
+			if camera != nil {
+				camera.Disconnect()
+			}
 		},
 	}
+	still.Flags().StringVarP(&group, "group", "g", "", "Executar em todas as câmeras deste grupo ao mesmo tempo")
+	still.Flags().StringVar(&groupsFile, "grupos", defaultGroupsPath(), "Arquivo JSON com os grupos de câmeras")
 
 	var record = &cobra.Command{
 		Use:   "record [Cameras IP Address]",
 		Short: "Comece a gravar o vídeo no cartão SD",
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				runGroupAction(applicationContext, groupsFile, group, verbose, tracer, (*libipcamera.CameraGroup).StartRecording)
+				return
+			}
 			camera.StartRecording()
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				return
+			}
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
-			camera.Disconnect()
+			if camera != nil {
+				camera.Disconnect()
+			}
 		},
 	}
+	record.Flags().StringVarP(&group, "group", "g", "", "Executar em todas as câmeras deste grupo ao mesmo tempo")
+	record.Flags().StringVar(&groupsFile, "grupos", defaultGroupsPath(), "Arquivo JSON com os grupos de câmeras")
 
 	var stop = &cobra.Command{
 		Use:   "stop [Cameras IP Address]",
 		Short: "Pare de gravar vídeo no cartão SD",
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				runGroupAction(applicationContext, groupsFile, group, verbose, tracer, (*libipcamera.CameraGroup).StopRecording)
+				return
+			}
 			camera.StopRecording()
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
+			if group != "" {
+				return
+			}
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
-			camera.Disconnect()
+			if camera != nil {
+				camera.Disconnect()
+			}
 		},
 	}
+	stop.Flags().StringVarP(&group, "group", "g", "", "Executar em todas as câmeras deste grupo ao mesmo tempo")
+	stop.Flags().StringVar(&groupsFile, "grupos", defaultGroupsPath(), "Arquivo JSON com os grupos de câmeras")
 
 	var firmware = &cobra.Command{
 		Use:   "firmware [Cameras IP Address]",
 		Short: "Recupere as informações da versão do firmware da câmera",
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			firmware, err := camera.GetFirmwareInfo()
-			if err != nil {
+			firmware, err := camera.GetFirmware()
+			if firmware.Raw == "" {
 				log.Printf("ERRO ao recuperar informações da versão: %s\n", err)
 				return
 			}
-			log.Printf("Versão do firmware: %s\n", firmware)
+			log.Printf("Versão do firmware: %s\n", firmware.Raw)
+			if err != nil {
+				log.Printf("ERRO ao interpretar a versão, nenhum perfil de modelo selecionado: %s\n", err)
+				return
+			}
+			log.Printf("%s\n", firmware)
+
+			profile, known := libipcamera.LookupModelProfile(firmware.Model)
+			if !known {
+				log.Printf("Nenhum perfil registrado para o modelo %s, usando o perfil genérico\n", firmware.Model)
+			}
+			commands := "todos"
+			if len(profile.Commands) > 0 {
+				names := make([]string, len(profile.Commands))
+				for i, command := range profile.Commands {
+					names[i] = libipcamera.MessageName(uint32(command))
+				}
+				commands = strings.Join(names, ", ")
+			}
+			fmt.Printf("Comandos:           %s\n", commands)
+			fmt.Printf("Codecs:             %s\n", strings.Join(profile.PreviewCodecs, ", "))
+			fmt.Printf("Porta de fluxo:     %d\n", profile.StreamPort)
+			fmt.Printf("Portas de descoberta: %v\n", profile.DiscoveryPorts)
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var upgradeConfirmation string
+	var upgradeMinBattery int
+	var firmwareUpgrade = &cobra.Command{
+		Use:   "upgrade [Firmware file] [Cameras IP Address]",
+		Short: "Verifique uma imagem de firmware; a atualização pela rede não é suportada e é recusada",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				log.Printf("ERRO ao ler a imagem de firmware: %s\n", err)
+				os.Exit(1)
+			}
+			if upgradeConfirmation == "" {
+				fmt.Printf("O firmware da câmera será substituído. Digite %s para confirmar: ", libipcamera.UpgradeConfirmationToken)
+				line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				upgradeConfirmation = strings.TrimSpace(line)
+			}
+			config := libipcamera.UpgradeConfig{Confirmation: upgradeConfirmation, MinBattery: upgradeMinBattery}
+			if err := camera.UpgradeFirmware(applicationContext, data, config); err != nil {
+				log.Printf("ERRO ao atualizar o firmware: %s\n", err)
+				os.Exit(1)
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[1]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			camera.Disconnect()
 		},
 	}
+	firmwareUpgrade.Flags().StringVar(&upgradeConfirmation, "confirmar", "", "Confirmar sem perguntar, deve ser "+libipcamera.UpgradeConfirmationToken)
+	firmwareUpgrade.Flags().IntVar(&upgradeMinBattery, "bateria-minima", libipcamera.DefaultUpgradeMinBattery, "Bateria mínima em porcentagem, exceto com a câmera carregando")
+	firmware.AddCommand(firmwareUpgrade)
 
 	var rtsp = &cobra.Command{
 		Use:   "rtsp [Cameras IP Address]",
 		Short: "Inicie um RTSP-Server para visualização das câmeras.",
 		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			if reconnect {
+				camera.EnableReconnect(libipcamera.ReconnectPolicy{
+					OnEvent: func(event libipcamera.ReconnectEvent) {
+						log.Printf("Câmera: %s\n", event)
+					},
+				})
+				camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
+			}
+
 			rtspServer := rtsp.CreateServer(applicationContext, "127.0.0.1", 8554, camera)
 			defer rtspServer.Stop()
+			rtspServer.SetStreamPort(camera.StreamPort())
+			if tracer != nil {
+				rtspServer.SetTracer(tracer)
+			}
 
 			log.Printf("Servidor RTSP criado\n")
 			err := rtspServer.ListenAndServe()
@@ -266,9 +521,9 @@ func main() {
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
@@ -276,34 +531,40 @@ func main() {
 		},
 	}
 
+	rtsp.Flags().BoolVarP(&reconnect, "reconectar", "r", true, "Reconectar automaticamente quando a conexão com a câmera cair")
+
 	var cmd = &cobra.Command{
-		Use:   "cmd [RAW Command] [Cameras IP Address]",
-		Short: "Envie um comando bruto para a câmera",
-		Args:  cobra.RangeArgs(1, 2),
+		Use:     "cmd [Cameras IP Address]",
+		Aliases: []string{"shell"},
+		Short:   "Abra um console do protocolo para enviar comandos brutos, ou execute um script recebido pela entrada padrão",
+		Args:    cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			command, err := hex.DecodeString(args[0])
-			if err != nil {
-				log.Printf("ERROR: %s\n", err)
-				return
-			}
+			info, err := os.Stdin.Stat()
+			interactive := err == nil && info.Mode()&os.ModeCharDevice != 0
 
-			if len(command) >= 2 {
-				header := libipcamera.CreateCommandHeader(uint32(binary.BigEndian.Uint16(command[:2])))
-				payload := command[2:]
-				packet := libipcamera.CreatePacket(header, payload)
-				log.Printf("Enviando comando: %X\n", packet)
-				camera.SendPacket(packet)
+			console := newShell(camera, os.Stdout, interactive)
+			if interactive {
+				console.loadHistory(shellHistoryPath())
+				fmt.Println("Digite help para ver os comandos")
+			}
+			if err := console.run(applicationContext, os.Stdin); err != nil {
+				log.Printf("ERRO no script: %s\n", err)
+				camera.Disconnect()
+				os.Exit(1)
 			}
-
-			log.Printf("Aguardando dados, pressione ENTER para sair")
-			bufio.NewReader(os.Stdin).ReadBytes('\n')
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
-			if len(args) != 2 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[1]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
+			camera.EnableReconnect(libipcamera.ReconnectPolicy{
+				OnEvent: func(event libipcamera.ReconnectEvent) {
+					log.Printf("Câmera: %s\n", event)
+				},
+			})
+			camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			camera.Disconnect()
@@ -328,15 +589,620 @@ func main() {
 		},
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
-				camera = connectAndLogin(discoverCamera(verbose), int(port), username, password, verbose)
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var settingsTable string
+	var config = &cobra.Command{
+		Use:   "config",
+		Short: "Leia e altere as configurações da câmera (os IDs embutidos requerem --experimental)",
+	}
+	config.PersistentFlags().StringVarP(&settingsTable, "tabela", "t", "", "Arquivo JSON com configurações adicionais ou corrigidas")
+
+	connectForConfig := func(ipArgument []string) {
+		if len(ipArgument) == 0 {
+			camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+		} else {
+			camera = connectAndLogin(net.ParseIP(ipArgument[0]), int(port), username, password, verbose, experimental, iface, tracer)
+		}
+		if settingsTable != "" {
+			table, err := loadSettingsTable(settingsTable)
+			if err != nil {
+				log.Printf("ERRO ao carregar a tabela de configurações: %s\n", err)
+				camera.Disconnect()
+				os.Exit(1)
+			}
+			camera.SetSettingsTable(table)
+		}
+	}
+
+	var configList = &cobra.Command{
+		Use:   "list [Cameras IP Address]",
+		Short: "Liste as configurações, seus valores atuais e os valores permitidos",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, setting := range camera.SettingsTable().Settings {
+				current := "?"
+				value, err := camera.GetSetting(setting.Name)
+				if err == nil {
+					current = value.Name
+				} else {
+					log.Printf("ERRO ao ler %s: %s\n", setting.Name, err)
+				}
+				fmt.Printf("%s\t%s\t[%s]\t%s\n", setting.Name, current, strings.Join(setting.ValueNames(), ", "), setting.Description)
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			connectForConfig(args)
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var configGet = &cobra.Command{
+		Use:   "get [Setting] [Cameras IP Address]",
+		Short: "Leia o valor atual de uma configuração",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			value, err := camera.GetSetting(args[0])
+			if err != nil {
+				log.Printf("ERRO ao ler %s: %s\n", args[0], err)
+				return
+			}
+			fmt.Println(value.Name)
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			connectForConfig(args[1:])
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var configSet = &cobra.Command{
+		Use:   "set [Setting] [Value] [Cameras IP Address]",
+		Short: "Altere uma configuração para um dos valores permitidos",
+		Args:  cobra.RangeArgs(2, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := camera.SetSetting(args[0], args[1])
+			if err != nil {
+				log.Printf("ERRO ao alterar %s: %s\n", args[0], err)
+				return
+			}
+			log.Printf("%s = %s\n", args[0], args[1])
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			connectForConfig(args[2:])
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	config.AddCommand(configList)
+	config.AddCommand(configGet)
+	config.AddCommand(configSet)
+
+	var clock = &cobra.Command{
+		Use:   "time",
+		Short: "Leia e acerte o relógio da câmera (experimental, requer --experimental)",
+	}
+
+	var clockGet = &cobra.Command{
+		Use:   "get [Cameras IP Address]",
+		Short: "Leia a data, a hora e o fuso horário da câmera",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cameraTime, err := camera.GetClock()
+			if err != nil {
+				log.Printf("ERRO ao ler o relógio da câmera: %s\n", err)
+				return
+			}
+			fmt.Println(cameraTime.Format("2006-01-02 15:04:05 -07:00"))
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var clockSync = &cobra.Command{
+		Use:   "sync [Cameras IP Address]",
+		Short: "Acerte o relógio da câmera pelo relógio deste computador",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			result, err := camera.SyncClock()
+			if err != nil {
+				log.Printf("ERRO ao sincronizar o relógio da câmera: %s\n", err)
+				return
+			}
+			log.Printf("Diferença antes da sincronização: %s\n", result.Drift)
+			log.Printf("Relógio da câmera: %s (diferença %s)\n", result.CameraTime.Format("2006-01-02 15:04:05 -07:00"), result.Residual)
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	clock.AddCommand(clockGet)
+	clock.AddCommand(clockSync)
+
+	var wifi = &cobra.Command{
+		Use:   "wifi",
+		Short: "Leia e altere a rede Wi-Fi e as credenciais de login da câmera (experimental, requer --experimental)",
+	}
+
+	var wifiGet = &cobra.Command{
+		Use:   "get [Cameras IP Address]",
+		Short: "Mostre o SSID, a senha e o canal do ponto de acesso da câmera",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := camera.GetWifi()
+			if err != nil {
+				log.Printf("ERRO ao ler a configuração Wi-Fi: %s\n", err)
+				return
+			}
+			fmt.Printf("SSID:  %s\nSenha: %s\nCanal: %d\n", config.SSID, config.Password, config.Channel)
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var wifiChanges libipcamera.WifiConfig
+	var verifyChange bool
+	var verifyTimeout time.Duration
+	var wifiSet = &cobra.Command{
+		Use:   "set [Cameras IP Address]",
+		Short: "Altere o SSID, a senha ou o canal do ponto de acesso da câmera",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if wifiChanges == (libipcamera.WifiConfig{}) {
+				log.Printf("ERRO: informe --ssid, --senha-wifi ou --canal\n")
+				return
+			}
+			err := camera.SetWifi(wifiChanges)
+			if err != nil {
+				log.Printf("ERRO ao alterar a configuração Wi-Fi: %s\n", err)
+				return
+			}
+			log.Printf("Configuração Wi-Fi alterada, a câmera reinicia o ponto de acesso\n")
+			if !verifyChange {
+				return
+			}
+
+			log.Printf("Conecte este computador à rede da câmera com a nova configuração, aguardando até %s\n", verifyTimeout)
+			ctx, cancel := context.WithTimeout(applicationContext, verifyTimeout)
+			defer cancel()
+			if err := camera.VerifyWifi(ctx, wifiChanges); err != nil {
+				log.Printf("ERRO ao verificar a nova configuração: %s\n", err)
+				return
+			}
+			log.Printf("Nova configuração verificada\n")
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+	wifiSet.Flags().StringVar(&wifiChanges.SSID, "ssid", "", "Novo SSID")
+	wifiSet.Flags().StringVar(&wifiChanges.Password, "senha-wifi", "", "Nova senha WPA2, de 8 a 63 caracteres")
+	wifiSet.Flags().IntVar(&wifiChanges.Channel, "canal", 0, "Novo canal, de 1 a 13")
+
+	var newUsername, newPassword string
+	var wifiLogin = &cobra.Command{
+		Use:   "login [Cameras IP Address]",
+		Short: "Altere o nome de usuário e a senha de login da câmera",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if newUsername == "" {
+				newUsername = username
+			}
+			err := camera.SetCredentials(newUsername, newPassword)
+			if err != nil {
+				log.Printf("ERRO ao alterar as credenciais: %s\n", err)
+				return
+			}
+			log.Printf("Credenciais alteradas\n")
+			if !verifyChange {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(applicationContext, verifyTimeout)
+			defer cancel()
+			if err := camera.Relogin(ctx); err != nil {
+				log.Printf("ERRO ao fazer login com as novas credenciais: %s\n", err)
+				return
+			}
+			log.Printf("Login com as novas credenciais verificado\n")
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+	wifiLogin.Flags().StringVar(&newUsername, "novo-usuario", "", "Novo nome de usuário, o atual por padrão")
+	wifiLogin.Flags().StringVar(&newPassword, "nova-senha", "", "Nova senha de login")
+	wifiLogin.MarkFlagRequired("nova-senha")
+
+	wifi.PersistentFlags().BoolVar(&verifyChange, "verificar", true, "Reconectar com a nova configuração para verificá-la")
+	wifi.PersistentFlags().DurationVar(&verifyTimeout, "espera", 2*time.Minute, "Tempo máximo para a verificação")
+	wifi.AddCommand(wifiGet)
+	wifi.AddCommand(wifiSet)
+	wifi.AddCommand(wifiLogin)
+
+	var watchStatus bool
+	var statusInterval time.Duration
+	var minimumBattery int
+	var minimumFreeSpace uint64
+	var status = &cobra.Command{
+		Use:   "status [Cameras IP Address]",
+		Short: "Mostre bateria, cartão SD e estado da gravação da câmera (experimental, requer --experimental)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printStatus := func(status libipcamera.CameraStatus) {
+				log.Printf("%s\n", &status)
+				if status.BatteryPercent <= minimumBattery && !status.Charging {
+					log.Printf("ALERTA: bateria em %d%%\n", status.BatteryPercent)
+				}
+				if !status.CardPresent {
+					log.Printf("ALERTA: nenhum cartão SD inserido\n")
+				} else if status.CardFree < minimumFreeSpace<<20 {
+					log.Printf("ALERTA: apenas %d MiB livres no cartão SD\n", status.CardFree>>20)
+				}
+			}
+
+			if !watchStatus {
+				status, err := camera.GetStatus()
+				if err != nil {
+					log.Printf("ERRO ao consultar o estado da câmera: %s\n", err)
+					return
+				}
+				printStatus(status)
+				return
+			}
+
+			events, unsubscribe := camera.SubscribeStatus()
+			defer unsubscribe()
+			camera.StartStatusPoller(statusInterval)
+			defer camera.StopStatusPoller()
+			for {
+				select {
+				case <-applicationContext.Done():
+					return
+				case event := <-events:
+					if event.Err != nil {
+						log.Printf("ERRO ao consultar o estado da câmera: %s\n", event.Err)
+						continue
+					}
+					printStatus(event.Status)
+				}
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+			if watchStatus {
+				camera.EnableReconnect(libipcamera.ReconnectPolicy{
+					OnEvent: func(event libipcamera.ReconnectEvent) {
+						log.Printf("Câmera: %s\n", event)
+					},
+				})
+				camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+	status.Flags().BoolVarP(&watchStatus, "watch", "w", false, "Continuar consultando e mostrar cada mudança de estado")
+	status.Flags().DurationVar(&statusInterval, "intervalo", 5*time.Second, "Intervalo entre consultas no modo --watch")
+	status.Flags().IntVar(&minimumBattery, "bateria-minima", 20, "Alertar quando a bateria estiver neste nível ou abaixo (%)")
+	status.Flags().Uint64Var(&minimumFreeSpace, "espaco-minimo", 1024, "Alertar quando o espaço livre no cartão SD for menor (MiB)")
+
+	var events = &cobra.Command{
+		Use:   "events [Cameras IP Address]",
+		Short: "Mostre o que é feito na própria câmera: gravação, fotos, cartão SD, bateria e modo",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			events, unsubscribe := camera.SubscribeEvents()
+			defer unsubscribe()
+			log.Printf("Aguardando eventos da câmera, Ctrl+C para sair\n")
+			for {
+				select {
+				case <-applicationContext.Done():
+					return
+				case event := <-events:
+					log.Printf("%s\n", event)
+				}
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+			camera.EnableReconnect(libipcamera.ReconnectPolicy{
+				OnEvent: func(event libipcamera.ReconnectEvent) {
+					log.Printf("Câmera: %s\n", event)
+				},
+			})
+			camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+
+	var dryRun bool
+	var rm = &cobra.Command{
+		Use:   "rm [Patterns...] [Cameras IP Address]",
+		Short: "Apague do cartão SD os arquivos que correspondem aos padrões (ex.: '/DCIM/MOVIE/*.MP4'), requer --experimental",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			patterns, _ := splitAddressArgument(args)
+			files, err := camera.GetFileList()
+			if err != nil {
+				log.Printf("ERRO ao receber a lista de arquivos: %s\n", err)
+				return
+			}
+
+			matches, err := matchFiles(files, patterns)
+			if err != nil {
+				log.Printf("ERRO no padrão: %s\n", err)
+				return
+			}
+			if len(matches) == 0 {
+				log.Printf("Nenhum arquivo corresponde aos padrões\n")
+				return
+			}
+			if dryRun {
+				for _, path := range matches {
+					fmt.Printf("apagaria %s\n", path)
+				}
+				return
+			}
+
+			deleted, err := camera.DeleteFiles(matches)
+			for _, path := range deleted {
+				fmt.Printf("apagado %s\n", path)
+			}
+			if err != nil {
+				log.Printf("ERRO ao apagar arquivos: %s\n", err)
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if !experimental && !dryRun {
+				log.Printf("ERRO: o comando de apagar arquivos não foi verificado em uma câmera real, use --experimental para enviá-lo mesmo assim\n")
+				os.Exit(1)
+			}
+			_, address := splitAddressArgument(args)
+			if address == nil {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(address, int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+	rm.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Apenas listar os arquivos que seriam apagados")
+
+	var confirmation string
+	var format = &cobra.Command{
+		Use:   "format [Cameras IP Address]",
+		Short: "Formate o cartão SD da câmera, apagando todos os arquivos (experimental, requer --experimental)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if confirmation == "" {
+				fmt.Printf("Todos os arquivos do cartão SD serão apagados. Digite %s para confirmar: ", libipcamera.FormatConfirmationToken)
+				line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				confirmation = strings.TrimSpace(line)
+			}
+			err := camera.FormatCard(confirmation)
+			if err != nil {
+				log.Printf("ERRO ao formatar o cartão SD: %s\n", err)
+				return
+			}
+			log.Printf("Cartão SD formatado\n")
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if !experimental {
+				log.Printf("ERRO: o comando de formatar o cartão SD não foi verificado em uma câmera real, use --experimental para enviá-lo mesmo assim\n")
+				os.Exit(1)
+			}
+			if len(args) == 0 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
+			} else {
+				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose, experimental, iface, tracer)
+			}
+		},
+		PostRun: func(cmd *cobra.Command, args []string) {
+			camera.Disconnect()
+		},
+	}
+	format.Flags().StringVar(&confirmation, "confirmar", "", "Confirmar sem perguntar, deve ser "+libipcamera.FormatConfirmationToken)
+
+	var decode = &cobra.Command{
+		Use:   "decode [Trace or capture file]",
+		Short: "Decodifique um arquivo de rastreamento (--rastro) ou uma captura pcap/pcapng",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, err := os.Open(args[0])
+			if err != nil {
+				log.Printf("ERRO ao abrir o arquivo: %s\n", err)
+				return
+			}
+			defer file.Close()
+
+			records, err := libipcamera.ReadTrace(file, int(port))
+			decoder := libipcamera.TraceDecoder{}
+			for _, record := range records {
+				for _, line := range decoder.Decode(record) {
+					fmt.Println(line)
+				}
+			}
+			if err != nil {
+				log.Printf("ERRO ao ler o arquivo: %s\n", err)
+			}
+		},
+	}
+
+	var probePayloads []string
+	var probeSkip []string
+	var probeWait, probeInterval time.Duration
+	var probeReport string
+	var probeConfirmation string
+	var probe = &cobra.Command{
+		Use:   "probe [From] [To] [Cameras IP Address]",
+		Short: "Varra um intervalo de IDs de comando (hex, ex.: A030 A04F) e registre as respostas",
+		Args:  cobra.RangeArgs(2, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := libipcamera.ProbeConfig{Wait: probeWait, Interval: probeInterval, Skip: libipcamera.DefaultProbeSkip()}
+			var err error
+			if config.From, err = parseCommandID(args[0]); err != nil {
+				log.Printf("ERRO no comando inicial: %s\n", err)
+				return
+			}
+			if config.To, err = parseCommandID(args[1]); err != nil {
+				log.Printf("ERRO no comando final: %s\n", err)
+				return
+			}
+			for _, payload := range probePayloads {
+				decoded, err := hex.DecodeString(payload)
+				if err != nil {
+					log.Printf("ERRO na carga útil %s: %s\n", payload, err)
+					return
+				}
+				config.Payloads = append(config.Payloads, decoded)
+			}
+			for _, skip := range probeSkip {
+				command, err := parseCommandID(skip)
+				if err != nil {
+					log.Printf("ERRO no comando a pular: %s\n", err)
+					return
+				}
+				config.Skip[command] = true
+			}
+			config.OnResult = func(result libipcamera.ProbeResult) {
+				if len(result.Replies) == 0 && !verbose {
+					return
+				}
+				fmt.Printf("0x%04X %s [%X]: %d resposta(s)\n", result.Command, libipcamera.MessageName(result.Command), result.Payload, len(result.Replies))
+				for _, reply := range result.Replies {
+					fmt.Printf("    +%s %s\n", reply.After.Round(time.Millisecond), reply.Message())
+				}
+			}
+
+			results, err := camera.Probe(applicationContext, config)
+			if err != nil {
+				log.Printf("ERRO durante a sondagem: %s\n", err)
+			}
+			printProbeConstants(results)
+			if probeReport != "" {
+				if err := writeProbeReport(probeReport, results); err != nil {
+					log.Printf("ERRO ao gravar o relatório: %s\n", err)
+				}
+			}
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if probeConfirmation == "" {
+				fmt.Printf("Os comandos destrutivos da câmera são desconhecidos, qualquer ID de %s a %s pode apagar arquivos ou alterar configurações. Digite %s para confirmar: ", args[0], args[1], probeConfirmationToken)
+				line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				probeConfirmation = strings.TrimSpace(line)
+			}
+			if probeConfirmation != probeConfirmationToken {
+				log.Printf("ERRO: sondagem não confirmada\n")
+				os.Exit(1)
+			}
+			if len(args) != 3 {
+				camera = connectAndLogin(discoverCamera(applicationContext, discovery, verbose), int(port), username, password, verbose, experimental, iface, tracer)
 			} else {
-				camera = connectAndLogin(net.ParseIP(args[0]), int(port), username, password, verbose)
+				camera = connectAndLogin(net.ParseIP(args[2]), int(port), username, password, verbose, experimental, iface, tracer)
 			}
 		},
 		PostRun: func(cmd *cobra.Command, args []string) {
 			camera.Disconnect()
 		},
 	}
+	probe.Flags().StringArrayVar(&probePayloads, "carga", nil, "Carga útil em hex enviada com cada comando, pode ser repetida")
+	probe.Flags().StringSliceVar(&probeSkip, "pular", nil, "Comandos (hex) que não devem ser enviados, além de LOGIN e dos IDs provisórios de DELETE_FILE, FORMAT_CARD e SET_CLOCK")
+	probe.Flags().StringVar(&probeConfirmation, "confirmar", "", "Confirmar sem perguntar, deve ser "+probeConfirmationToken)
+	probe.Flags().DurationVar(&probeWait, "espera", libipcamera.DefaultProbeWait, "Tempo aguardando respostas após cada comando")
+	probe.Flags().DurationVar(&probeInterval, "intervalo", libipcamera.DefaultProbeInterval, "Pausa entre comandos")
+	probe.Flags().StringVar(&probeReport, "relatorio", "", "Gravar o resultado de cada comando neste arquivo JSON")
+
+	var emulatorConfig emulator.Config
+	var emulate = &cobra.Command{
+		Use:   "emulate",
+		Short: "Emule uma câmera SJ4000 para testes sem o dispositivo",
+		Args:  cobra.MaximumNArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			emulatorConfig.Username = username
+			emulatorConfig.Password = password
+			emulatedCamera := emulator.CreateEmulator(emulatorConfig)
+			err := emulatedCamera.Start()
+			if err != nil {
+				log.Printf("ERRO ao iniciar o emulador: %s\n", err)
+				return
+			}
+			defer emulatedCamera.Close()
+
+			log.Printf("Emulador escutando em %s\n", emulatedCamera.ControlAddr())
+			<-applicationContext.Done()
+		},
+	}
+	emulate.Flags().StringVar(&emulatorConfig.ControlAddress, "controle", ":6666", "Endereço do protocolo de controle")
+	emulate.Flags().StringVar(&emulatorConfig.HTTPAddress, "http", ":80", "Endereço do servidor HTTP do cartão SD")
+	emulate.Flags().IntVar(&emulatorConfig.StreamPort, "porta-fluxo", 6669, "Porta UDP do cliente para o fluxo de visualização")
+	emulate.Flags().StringVar(&emulatorConfig.Name, "nome", "SJ4000AIR", "Nome anunciado na descoberta")
+	emulate.Flags().StringVar(&emulatorConfig.Firmware, "firmware", "SJ4000AIR V1.0 20200101", "Versão do firmware informada")
+	emulate.Flags().BoolVar(&emulatorConfig.DisableDiscovery, "sem-descoberta", false, "Não responder à descoberta UDP")
 
 	rootCmd.AddCommand(ls)
 	rootCmd.AddCommand(cmd)
@@ -347,6 +1213,16 @@ func main() {
 	rootCmd.AddCommand(firmware)
 	rootCmd.AddCommand(rtsp)
 	rootCmd.AddCommand(discover)
+	rootCmd.AddCommand(emulate)
+	rootCmd.AddCommand(config)
+	rootCmd.AddCommand(clock)
+	rootCmd.AddCommand(status)
+	rootCmd.AddCommand(events)
+	rootCmd.AddCommand(rm)
+	rootCmd.AddCommand(format)
+	rootCmd.AddCommand(decode)
+	rootCmd.AddCommand(probe)
+	rootCmd.AddCommand(wifi)
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Println(err)
@@ -356,27 +1232,191 @@ func main() {
 
 func downloadFile(filepath string, url string) error {
 
-
 	resp, err := http.Get(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-
 	out, err := os.Create(filepath)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-
 	_, err = io.Copy(out, resp.Body)
 	return err
 }
 
-func discoverCamera(verbose bool) net.IP {
-	cameraIP, err := libipcamera.AutodiscoverCamera(verbose)
+// splitAddressArgument separates a trailing camera IP address from the other arguments.
+func splitAddressArgument(args []string) ([]string, net.IP) {
+	if len(args) > 0 {
+		if address := net.ParseIP(args[len(args)-1]); address != nil {
+			return args[:len(args)-1], address
+		}
+	}
+	return args, nil
+}
+
+// matchFiles returns the paths matching any of the glob patterns. Patterns
+// without a slash are matched against the file name only.
+func matchFiles(files []libipcamera.StoredFile, patterns []string) ([]string, error) {
+	var matches []string
+	for _, file := range files {
+		for _, pattern := range patterns {
+			name := file.Path
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(file.Path)
+			}
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				matches = append(matches, file.Path)
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// loadSettingsTable merges the settings table in path into the default table.
+func loadSettingsTable(path string) (*libipcamera.SettingsTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table, err := libipcamera.LoadSettingsTable(file)
+	if err != nil {
+		return nil, err
+	}
+	return libipcamera.DefaultSettingsTable().Merge(table), nil
+}
+
+// defaultGroupsPath returns the groups file used when --grupos is not given.
+func defaultGroupsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".actioncam_groups.json")
+}
+
+// runGroupAction connects to every camera of the group in groupsFile, runs
+// action on all of them at once and reports the result of each camera.
+func runGroupAction(ctx context.Context, groupsFile, name string, verbose bool, tracer libipcamera.Tracer, action func(*libipcamera.CameraGroup, context.Context) (*libipcamera.GroupResult, error)) {
+	file, err := os.Open(groupsFile)
+	if err != nil {
+		log.Printf("ERRO ao abrir o arquivo de grupos: %s\n", err)
+		os.Exit(1)
+	}
+	groups, err := libipcamera.LoadGroups(file)
+	file.Close()
+	if err != nil {
+		log.Printf("ERRO no arquivo de grupos %s: %s\n", groupsFile, err)
+		os.Exit(1)
+	}
+	configs, ok := groups[name]
+	if !ok {
+		log.Printf("ERRO: grupo %s não encontrado em %s\n", name, groupsFile)
+		os.Exit(1)
+	}
+	cameras, err := libipcamera.CreateCameraGroup(configs)
+	if err != nil {
+		log.Printf("ERRO ao criar o grupo %s: %s\n", name, err)
+		os.Exit(1)
+	}
+	defer cameras.Disconnect()
+	for _, member := range cameras.Members() {
+		member.Camera.SetVerbose(verbose)
+		if tracer != nil {
+			member.Camera.SetTracer(tracer)
+		}
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, libipcamera.DefaultRequestTimeout)
+	defer cancel()
+	if _, err := cameras.Connect(connectCtx); err != nil {
+		log.Printf("ERRO ao conectar ao grupo %s: %s\n", name, err)
+		return
+	}
+	if _, err := cameras.Login(connectCtx); err != nil {
+		log.Printf("ERRO ao fazer login no grupo %s: %s\n", name, err)
+		return
+	}
+
+	actionCtx, cancel := context.WithTimeout(ctx, libipcamera.DefaultRequestTimeout)
+	defer cancel()
+	result, err := action(cameras, actionCtx)
+	fmt.Println(result)
+	if err != nil {
+		log.Printf("ERRO no grupo %s: %s\n", name, err)
+	}
+}
+
+// parseCommandID parses a hexadecimal command ID with or without the 0x prefix.
+func parseCommandID(text string) (uint32, error) {
+	text = strings.TrimPrefix(strings.ToLower(text), "0x")
+	command, err := strconv.ParseUint(text, 16, 32)
+	return uint32(command), err
+}
+
+// probeConfirmationToken must be typed, or given with --confirmar, before
+// probe sends anything, since any ID of the range may be destructive.
+const probeConfirmationToken = "SONDAR"
+
+// printProbeConstants prints the commands that got replies as constant
+// declarations, so they can be added to libipcamera.
+func printProbeConstants(results []libipcamera.ProbeResult) {
+	seen := make(map[uint32]bool)
+	var lines []string
+	for _, result := range results {
+		if len(result.Replies) == 0 {
+			continue
+		}
+		for _, messageType := range append([]uint32{result.Command}, result.Replies[0].Type) {
+			if seen[messageType] {
+				continue
+			}
+			seen[messageType] = true
+			name := libipcamera.MessageName(messageType)
+			if strings.HasPrefix(name, "0x") {
+				name = fmt.Sprintf("UNKNOWN_%04X", messageType)
+			}
+			lines = append(lines, fmt.Sprintf("\t%s = 0x%04X", name, messageType))
+		}
+	}
+	if len(lines) == 0 {
+		log.Printf("Nenhum comando respondido\n")
+		return
+	}
+	fmt.Printf("Comandos respondidos:\nconst (\n%s\n)\n", strings.Join(lines, "\n"))
+}
+
+func writeProbeReport(path string, results []libipcamera.ProbeResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func discoverCamera(ctx context.Context, config libipcamera.DiscoveryConfig, verbose bool) net.IP {
+	minLevel := libipcamera.LevelInfo
+	if verbose {
+		minLevel = libipcamera.LevelDebug
+	}
+	config.Logger = libipcamera.NewStdLogger(nil, minLevel)
+	cameraIP, err := libipcamera.AutodiscoverCameraWithConfig(ctx, config)
 	if err != nil {
 		log.Printf("ERRO durante a descoberta automática: %s\n", err)
 	}
@@ -0,0 +1,403 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// fileListPartSize is the amount of file list data sent per FILE_LIST_CONTENT part.
+const fileListPartSize = 1024
+
+// loginWait is how long a login waits for the logged in client to go away.
+// A client closing its connection before logging in again reaches a real
+// camera in order, the goroutines serving the sessions do not keep it.
+const loginWait = 100 * time.Millisecond
+
+// session is a client connected to the control port.
+type session struct {
+	emulator *Emulator
+	conn     net.Conn
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+	closed     chan struct{}
+	streaming  bool
+}
+
+func (e *Emulator) acceptControl() {
+	defer e.wg.Done()
+	for {
+		conn, err := e.controlListener.Accept()
+		if err != nil {
+			return
+		}
+
+		s := &session{emulator: e, conn: conn, closed: make(chan struct{})}
+		e.mutex.Lock()
+		e.sessions[s] = true
+		e.mutex.Unlock()
+
+		e.wg.Add(2)
+		go s.serve()
+		go s.sendKeepalives()
+	}
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		e := s.emulator
+		e.mutex.Lock()
+		delete(e.sessions, s)
+		if e.loggedIn == s {
+			e.loggedIn = nil
+		}
+		e.mutex.Unlock()
+
+		close(s.closed)
+		s.conn.Close()
+	})
+}
+
+// send writes a message to the client right away.
+func (s *session) send(messageType uint32, payload []byte) {
+	if s.emulator.currentFaults().Unresponsive {
+		return
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.conn.Write(libipcamera.CreatePacket(libipcamera.CreateCommandHeader(messageType), payload))
+}
+
+// reply answers command, applying the injected faults.
+func (s *session) reply(command, messageType uint32, payload []byte) {
+	if s.emulator.dropReply(command) {
+		return
+	}
+	delay := s.emulator.currentFaults().ReplyDelay
+	if delay <= 0 {
+		s.send(messageType, payload)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case <-s.closed:
+		default:
+			s.send(messageType, payload)
+		}
+	})
+}
+
+func (s *session) sendKeepalives() {
+	defer s.emulator.wg.Done()
+	interval := s.emulator.config.AliveInterval
+	if interval < 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.send(libipcamera.ALIVE_REQUEST, nil)
+		}
+	}
+}
+
+func (s *session) serve() {
+	defer s.emulator.wg.Done()
+	defer s.close()
+
+	messages := 0
+	for {
+		header := libipcamera.Header{}
+		if err := binary.Read(s.conn, binary.BigEndian, &header); err != nil {
+			return
+		}
+		if header.Magic != 0xABCD {
+			return
+		}
+		payload := make([]byte, header.Length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			return
+		}
+
+		e := s.emulator
+		e.mutex.Lock()
+		e.received[header.MessageType]++
+		e.mutex.Unlock()
+
+		s.handle(&libipcamera.Message{Header: header, Payload: payload})
+
+		messages++
+		limit := e.currentFaults().DisconnectAfterMessages
+		if limit > 0 && messages >= limit {
+			return
+		}
+	}
+}
+
+func (s *session) handle(message *libipcamera.Message) {
+	e := s.emulator
+	command := message.Header.MessageType
+	payload := message.Payload
+
+	if command != libipcamera.LOGIN && command != libipcamera.ALIVE_RESPONSE && !s.isLoggedIn() {
+		return
+	}
+	if command != libipcamera.LOGIN && command != libipcamera.ALIVE_RESPONSE {
+		if handler := e.handler(command); handler != nil {
+			replyType, reply := handler(payload)
+			s.reply(command, replyType, reply)
+			return
+		}
+	}
+
+	switch command {
+	case libipcamera.LOGIN:
+		if s.login(payload) {
+			s.reply(command, libipcamera.LOGIN_ACCEPT, nil)
+		} else {
+			s.reply(command, libipcamera.LOGIN_REJECTED, nil)
+		}
+	case libipcamera.ALIVE_RESPONSE:
+	case libipcamera.REQUEST_FILE_LIST:
+		for _, part := range e.fileListParts() {
+			s.reply(command, libipcamera.FILE_LIST_CONTENT, part)
+		}
+	case libipcamera.REQUEST_FIRMWARE_INFO:
+		s.reply(command, libipcamera.FIRMWARE_INFORMATION, []byte(e.config.Firmware))
+	case libipcamera.TAKE_PICTURE:
+		e.takePicture()
+		s.reply(command, libipcamera.PICTURE_SAVED, nil)
+		e.checkCardFull()
+	case libipcamera.CONTROL_RECORDING:
+		control := libipcamera.RecordingControl{}
+		control.UnmarshalBinary(payload)
+		e.controlRecording(control.Start)
+		s.reply(command, libipcamera.RECORD_COMMAND_ACCEPT, payload)
+		e.checkCardFull()
+	case libipcamera.START_PREVIEW:
+		s.startPreview()
+	case libipcamera.REQUEST_CLOCK:
+		clock, _ := (&libipcamera.ClockTime{Time: e.Clock()}).MarshalBinary()
+		s.reply(command, libipcamera.CLOCK_INFORMATION, clock)
+	case libipcamera.REQUEST_STATUS:
+		status := e.Status()
+		reply, _ := status.MarshalBinary()
+		s.reply(command, libipcamera.STATUS_INFORMATION, reply)
+	case libipcamera.DELETE_FILE:
+		path := libipcamera.FilePath{}
+		path.UnmarshalBinary(payload)
+		result, _ := (&libipcamera.Uint32Payload{Value: e.deleteFile(path.Path)}).MarshalBinary()
+		s.reply(command, libipcamera.FILE_DELETED, result)
+	case libipcamera.FORMAT_CARD:
+		result, _ := (&libipcamera.Uint32Payload{Value: e.formatCard()}).MarshalBinary()
+		s.reply(command, libipcamera.CARD_FORMATTED, result)
+	case libipcamera.REQUEST_WIFI:
+		wifi := e.Wifi()
+		reply, _ := wifi.MarshalBinary()
+		s.reply(command, libipcamera.WIFI_INFORMATION, reply)
+	case libipcamera.SET_WIFI:
+		wifi := libipcamera.WifiConfig{}
+		wifi.UnmarshalBinary(payload)
+		code := e.setWifi(wifi)
+		result, _ := (&libipcamera.Uint32Payload{Value: code}).MarshalBinary()
+		s.reply(command, libipcamera.WIFI_SET_ACCEPT, result)
+		if code == libipcamera.ResultOK {
+			// The access point restarts with the new configuration.
+			e.DisconnectClients()
+		}
+	case libipcamera.SET_LOGIN:
+		login := libipcamera.LoginRequest{}
+		login.UnmarshalBinary(payload)
+		result, _ := (&libipcamera.Uint32Payload{Value: e.setCredentials(login.Username, login.Password)}).MarshalBinary()
+		s.reply(command, libipcamera.LOGIN_SET_ACCEPT, result)
+	case libipcamera.SET_CLOCK:
+		clock := libipcamera.ClockTime{}
+		if clock.UnmarshalBinary(payload) == nil {
+			e.setClock(clock.Time)
+		}
+		s.reply(command, libipcamera.CLOCK_SET_ACCEPT, nil)
+	default:
+		s.handleSetting(command, payload)
+	}
+}
+
+// handleSetting answers the get and set commands of the settings table.
+// Values outside the enumeration are not applied, the reply carries the
+// value kept.
+func (s *session) handleSetting(command uint32, payload []byte) {
+	e := s.emulator
+	for i := range e.config.Settings.Settings {
+		setting := &e.config.Settings.Settings[i]
+		switch command {
+		case uint32(setting.Get):
+			e.mutex.Lock()
+			value := e.settings[setting.Name]
+			e.mutex.Unlock()
+			reply, _ := (&libipcamera.Uint32Payload{Value: value}).MarshalBinary()
+			s.reply(command, replyType(setting.Get, setting.GetReply), reply)
+			return
+		case uint32(setting.Set):
+			requested := libipcamera.Uint32Payload{}
+			requested.UnmarshalBinary(payload)
+			e.mutex.Lock()
+			if isEnumerated(setting, requested.Value) {
+				e.settings[setting.Name] = requested.Value
+			}
+			value := e.settings[setting.Name]
+			e.mutex.Unlock()
+			reply, _ := (&libipcamera.Uint32Payload{Value: value}).MarshalBinary()
+			s.reply(command, replyType(setting.Set, setting.SetReply), reply)
+			return
+		}
+	}
+}
+
+func replyType(command, reply libipcamera.CommandID) uint32 {
+	if reply != 0 {
+		return uint32(reply)
+	}
+	return uint32(command) + 1
+}
+
+func isEnumerated(setting *libipcamera.Setting, value uint32) bool {
+	for _, enumerated := range setting.Values {
+		if enumerated.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) isLoggedIn() bool {
+	e := s.emulator
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.loggedIn == s
+}
+
+// login checks the credentials. Only one client can be logged in at a time.
+func (s *session) login(payload []byte) bool {
+	e := s.emulator
+	request := libipcamera.LoginRequest{}
+	if request.UnmarshalBinary(payload) != nil || e.currentFaults().RejectLogin {
+		return false
+	}
+
+	e.mutex.Lock()
+	other := e.loggedIn
+	e.mutex.Unlock()
+	if other != nil && other != s {
+		select {
+		case <-other.closed:
+		case <-time.After(loginWait):
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if request.Username != e.username || request.Password != e.password {
+		return false
+	}
+	if e.loggedIn != nil && e.loggedIn != s {
+		return false
+	}
+	e.loggedIn = s
+	return true
+}
+
+// fileListParts encodes the SD card content as FILE_LIST_CONTENT payloads.
+func (e *Emulator) fileListParts() [][]byte {
+	e.mutex.Lock()
+	var list strings.Builder
+	for _, file := range e.files {
+		fmt.Fprintf(&list, "%s:%d;", file.Path, file.Size)
+	}
+	e.mutex.Unlock()
+
+	data := []byte(list.String())
+	numParts := (len(data) + fileListPartSize - 1) / fileListPartSize
+	if numParts == 0 {
+		numParts = 1
+	}
+
+	parts := make([][]byte, numParts)
+	for i := range parts {
+		end := (i + 1) * fileListPartSize
+		if end > len(data) {
+			end = len(data)
+		}
+		part := libipcamera.FileListPart{
+			PartHeader: libipcamera.PartHeader{Parts: uint32(numParts), Part: uint32(i)},
+			Data:       data[i*fileListPartSize : end],
+		}
+		parts[i], _ = part.MarshalBinary()
+	}
+	return parts
+}
+
+// takePicture stores a picture and returns its path.
+func (e *Emulator) takePicture() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.pictureCount++
+	path := fmt.Sprintf("/DCIM/PHOTO/IMG%04d.JPG", e.pictureCount)
+	e.files = append(e.files, File{Path: path, Size: 3 * 1024 * 1024})
+	return path
+}
+
+// controlRecording starts or stops recording and returns the path of the
+// video stored when recording stops.
+func (e *Emulator) controlRecording(start bool) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if start == e.recording {
+		return ""
+	}
+	e.recording = start
+	if start {
+		e.recordStart = time.Now()
+		return ""
+	}
+
+	// Roughly the bitrate of 1080p30 footage.
+	duration := time.Since(e.recordStart)
+	e.videoCount++
+	path := fmt.Sprintf("/DCIM/MOVIE/VID%04d.MP4", e.videoCount)
+	e.files = append(e.files, File{Path: path, Size: uint64(duration.Seconds()*2*1024*1024) + 1})
+	return path
+}
+
+// deleteFile removes path from the SD card and returns the result code.
+func (e *Emulator) deleteFile(path string) uint32 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for i, file := range e.files {
+		if file.Path == path {
+			e.files = append(e.files[:i], e.files[i+1:]...)
+			return libipcamera.ResultOK
+		}
+	}
+	return libipcamera.ResultNotFound
+}
+
+// formatCard erases the SD card, which is refused while recording.
+func (e *Emulator) formatCard() uint32 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.recording {
+		return libipcamera.ResultDenied
+	}
+	e.files = nil
+	return libipcamera.ResultOK
+}
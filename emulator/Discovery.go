@@ -0,0 +1,44 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func (e *Emulator) serveDiscovery(conn net.PacketConn) {
+	defer e.wg.Done()
+	buffer := make([]byte, 512)
+	for {
+		n, remote, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		header := libipcamera.Header{}
+		if binary.Read(bytes.NewReader(buffer[:n]), binary.BigEndian, &header) != nil {
+			continue
+		}
+		if header.Magic != 0xABCD || header.MessageType != libipcamera.DISCOVERY_REQUEST {
+			continue
+		}
+
+		e.mutex.Lock()
+		e.received[header.MessageType]++
+		e.mutex.Unlock()
+
+		conn.WriteTo(libipcamera.CreatePacket(libipcamera.CreateCommandHeader(libipcamera.DISCOVERY_RESPONSE), e.discoveryPayload()), remote)
+	}
+}
+
+// discoveryPayloadSize is the size of the DISCOVERY_RESPONSE payload of the
+// camera. Its layout is unknown; the emulator sends its name zero padded.
+const discoveryPayloadSize = 80
+
+func (e *Emulator) discoveryPayload() []byte {
+	payload := make([]byte, discoveryPayloadSize)
+	copy(payload, e.config.Name)
+	return payload
+}
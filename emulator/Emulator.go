@@ -0,0 +1,492 @@
+// Package emulator implements the camera side of the protocols spoken by
+// libipcamera, so clients can be tested without an SJ4000 on the network.
+package emulator
+
+import (
+	_ "embed"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+//go:embed sample.h264
+var sampleH264 []byte
+
+// Config describes the emulated camera. Zero values are replaced by the
+// defaults of a factory fresh SJ4000.
+type Config struct {
+	// ControlAddress is the TCP address of the 0xABCD control protocol.
+	ControlAddress string
+	// DiscoveryAddresses are the UDP addresses answering DISCOVERY_REQUEST.
+	DiscoveryAddresses []string
+	// HTTPAddress serves the files on the SD card.
+	HTTPAddress string
+	// StreamPort is the UDP port of the client the preview stream is sent to.
+	StreamPort int
+
+	// DisableDiscovery and DisableHTTP turn off the respective servers.
+	DisableDiscovery bool
+	DisableHTTP      bool
+
+	Username string
+	Password string
+	Name     string
+	Firmware string
+
+	// Files is the initial content of the SD card.
+	Files []File
+
+	// AliveInterval is the interval of ALIVE_REQUEST messages, negative disables them.
+	AliveInterval time.Duration
+	// FrameInterval is the interval of preview frames.
+	FrameInterval time.Duration
+	// Sample is the Annex B H.264 stream looped as preview, the bundled sample by default.
+	Sample []byte
+	// Clock is the initial time of the camera clock, 2020-01-01 00:00 UTC as
+	// after a flat battery by default.
+	Clock time.Time
+	// Battery is the initial battery level in percent, 100 by default.
+	Battery int
+	// CardCapacity is the size of the SD card, 32 GiB by default.
+	CardCapacity uint64
+	// Wifi is the access point configuration, the SSID defaults to Name,
+	// the password to 12345678 and the channel to 6.
+	Wifi libipcamera.WifiConfig
+	// Settings are the settings answered by the camera, libipcamera.DefaultSettingsTable by default.
+	// Every setting starts at its first value.
+	Settings *libipcamera.SettingsTable
+}
+
+// File is a file stored on the emulated SD card. Files without Data are
+// served as Size bytes of generated content, otherwise Size is len(Data).
+type File struct {
+	Path string
+	Size uint64
+	Data []byte
+}
+
+// Faults are injected into the control protocol and the preview stream.
+type Faults struct {
+	// DropReplies lists commands that are never answered.
+	DropReplies map[uint32]bool
+	// DropRate is the probability of any reply being dropped.
+	DropRate float64
+	// ReplyDelay delays every reply.
+	ReplyDelay time.Duration
+	// RejectLogin answers every LOGIN with LOGIN_REJECTED.
+	RejectLogin bool
+	// DisconnectAfterMessages closes a control connection after receiving that many messages.
+	DisconnectAfterMessages int
+	// DisconnectAfterFrames closes the control connection after sending that many preview frames.
+	DisconnectAfterFrames int
+	// Unresponsive stops every message to the client, keepalives included,
+	// while the connection stays open, like a camera that lost power.
+	Unresponsive bool
+}
+
+// Handler answers a command with a message of replyType.
+type Handler func(payload []byte) (replyType uint32, reply []byte)
+
+// Emulator is an emulated camera.
+type Emulator struct {
+	config Config
+
+	controlListener    net.Listener
+	discoveryListeners []net.PacketConn
+	httpListener       net.Listener
+	httpServer         *http.Server
+
+	mutex        sync.Mutex
+	faults       Faults
+	handlers     map[uint32]Handler
+	files        []File
+	sessions     map[*session]bool
+	loggedIn     *session
+	recording    bool
+	recordStart  time.Time
+	pictureCount int
+	videoCount   int
+	received     map[uint32]int
+	settings     map[string]uint32
+	clock        time.Time
+	clockSetAt   time.Time
+	battery      int
+	charging     bool
+	username     string
+	password     string
+	wifi         libipcamera.WifiConfig
+	mode         libipcamera.CameraMode
+	cardPresent  bool
+	cardFull     bool
+	random       *rand.Rand
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// CreateEmulator creates an emulated camera, call Start to serve it.
+func CreateEmulator(config Config) *Emulator {
+	if config.ControlAddress == "" {
+		config.ControlAddress = ":6666"
+	}
+	if config.DiscoveryAddresses == nil {
+		config.DiscoveryAddresses = []string{":22600", ":21600"}
+	}
+	if config.HTTPAddress == "" {
+		config.HTTPAddress = ":80"
+	}
+	if config.StreamPort == 0 {
+		config.StreamPort = 6669
+	}
+	if config.Username == "" {
+		config.Username = "admin"
+	}
+	if config.Password == "" {
+		config.Password = "12345"
+	}
+	if config.Name == "" {
+		config.Name = "SJ4000AIR"
+	}
+	if config.Firmware == "" {
+		config.Firmware = "SJ4000AIR V1.0 20200101"
+	}
+	if config.AliveInterval == 0 {
+		config.AliveInterval = time.Second
+	}
+	if config.FrameInterval == 0 {
+		config.FrameInterval = 33 * time.Millisecond
+	}
+	if config.Sample == nil {
+		config.Sample = sampleH264
+	}
+	if config.Battery == 0 {
+		config.Battery = 100
+	}
+	if config.CardCapacity == 0 {
+		config.CardCapacity = 32 << 30
+	}
+	if config.Clock.IsZero() {
+		config.Clock = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if config.Wifi.SSID == "" {
+		config.Wifi.SSID = config.Name
+	}
+	if config.Wifi.Password == "" {
+		config.Wifi.Password = "12345678"
+	}
+	if config.Wifi.Channel == 0 {
+		config.Wifi.Channel = 6
+	}
+	if config.Settings == nil {
+		config.Settings = libipcamera.DefaultSettingsTable()
+	}
+	settings := make(map[string]uint32)
+	for _, setting := range config.Settings.Settings {
+		settings[setting.Name] = setting.Values[0].Value
+	}
+	files := make([]File, len(config.Files))
+	for i, file := range config.Files {
+		if file.Data != nil {
+			file.Size = uint64(len(file.Data))
+		}
+		files[i] = file
+	}
+
+	return &Emulator{
+		config:      config,
+		files:       files,
+		handlers:    make(map[uint32]Handler),
+		sessions:    make(map[*session]bool),
+		received:    make(map[uint32]int),
+		settings:    settings,
+		clock:       config.Clock,
+		clockSetAt:  time.Now(),
+		battery:     config.Battery,
+		username:    config.Username,
+		password:    config.Password,
+		wifi:        config.Wifi,
+		cardPresent: true,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Start opens every listener and starts serving. It fails without serving
+// anything if one of the listeners cannot be opened.
+func (e *Emulator) Start() error {
+	var err error
+	e.controlListener, err = net.Listen("tcp", e.config.ControlAddress)
+	if err != nil {
+		return err
+	}
+
+	if !e.config.DisableDiscovery {
+		for _, address := range e.config.DiscoveryAddresses {
+			conn, err := net.ListenPacket("udp", address)
+			if err != nil {
+				e.Close()
+				return err
+			}
+			e.discoveryListeners = append(e.discoveryListeners, conn)
+		}
+	}
+
+	if !e.config.DisableHTTP {
+		e.httpListener, err = net.Listen("tcp", e.config.HTTPAddress)
+		if err != nil {
+			e.Close()
+			return err
+		}
+		e.httpServer = &http.Server{Handler: http.HandlerFunc(e.serveFile)}
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.httpServer.Serve(e.httpListener)
+		}()
+	}
+
+	e.wg.Add(1)
+	go e.acceptControl()
+	for _, conn := range e.discoveryListeners {
+		e.wg.Add(1)
+		go e.serveDiscovery(conn)
+	}
+	return nil
+}
+
+// Close stops every server and drops all clients.
+func (e *Emulator) Close() error {
+	select {
+	case <-e.closed:
+		return errors.New("O emulador já foi encerrado")
+	default:
+		close(e.closed)
+	}
+
+	if e.controlListener != nil {
+		e.controlListener.Close()
+	}
+	for _, conn := range e.discoveryListeners {
+		conn.Close()
+	}
+	if e.httpServer != nil {
+		e.httpServer.Close()
+	}
+	e.DisconnectClients()
+	e.wg.Wait()
+	return nil
+}
+
+// ControlAddr returns the address of the control protocol listener.
+func (e *Emulator) ControlAddr() *net.TCPAddr {
+	return e.controlListener.Addr().(*net.TCPAddr)
+}
+
+// HTTPAddr returns the address of the SD card HTTP server, nil if disabled.
+func (e *Emulator) HTTPAddr() *net.TCPAddr {
+	if e.httpListener == nil {
+		return nil
+	}
+	return e.httpListener.Addr().(*net.TCPAddr)
+}
+
+// DiscoveryAddrs returns the addresses answering discovery requests.
+func (e *Emulator) DiscoveryAddrs() []*net.UDPAddr {
+	addrs := make([]*net.UDPAddr, len(e.discoveryListeners))
+	for i, conn := range e.discoveryListeners {
+		addrs[i] = conn.LocalAddr().(*net.UDPAddr)
+	}
+	return addrs
+}
+
+// SetFaults replaces the injected faults.
+func (e *Emulator) SetFaults(faults Faults) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.faults = faults
+}
+
+// Handle answers command with handler instead of the built-in behaviour,
+// for emulating commands the emulator does not implement. The replies are
+// subject to the injected faults. LOGIN and ALIVE_RESPONSE keep their
+// built-in handling; a nil handler restores the built-in behaviour.
+func (e *Emulator) Handle(command uint32, handler Handler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if handler == nil {
+		delete(e.handlers, command)
+		return
+	}
+	e.handlers[command] = handler
+}
+
+func (e *Emulator) handler(command uint32) Handler {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.handlers[command]
+}
+
+// Files returns the current content of the SD card.
+func (e *Emulator) Files() []File {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]File(nil), e.files...)
+}
+
+// Recording reports whether the emulated camera is recording.
+func (e *Emulator) Recording() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.recording
+}
+
+// Setting returns the raw value of the setting called name.
+func (e *Emulator) Setting(name string) (uint32, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	value, ok := e.settings[name]
+	return value, ok
+}
+
+// Clock returns the current time of the camera clock.
+func (e *Emulator) Clock() time.Time {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.clock.Add(time.Since(e.clockSetAt))
+}
+
+func (e *Emulator) setClock(t time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.clock = t
+	e.clockSetAt = time.Now()
+}
+
+// SetBattery changes the battery level and charging state. The client is
+// notified when the level drops to lowBatteryLevel without charging.
+func (e *Emulator) SetBattery(percent int, charging bool) {
+	e.mutex.Lock()
+	low := percent <= lowBatteryLevel && !charging && (e.battery > lowBatteryLevel || e.charging)
+	e.battery = percent
+	e.charging = charging
+	e.mutex.Unlock()
+
+	if low {
+		battery, _ := (&libipcamera.Uint32Payload{Value: uint32(percent)}).MarshalBinary()
+		e.Notify(libipcamera.NOTIFY_LOW_BATTERY, battery)
+	}
+}
+
+// Status returns the status reported by REQUEST_STATUS. The free space of the
+// card is its capacity minus the size of the stored files.
+func (e *Emulator) Status() libipcamera.CameraStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var used uint64
+	for _, file := range e.files {
+		used += file.Size
+	}
+	status := libipcamera.CameraStatus{
+		BatteryPercent: e.battery,
+		Charging:       e.charging,
+		CardPresent:    e.cardPresent,
+		Recording:      e.recording,
+	}
+	if e.cardPresent {
+		status.CardCapacity = e.config.CardCapacity
+	}
+	if used < status.CardCapacity {
+		status.CardFree = status.CardCapacity - used
+	}
+	if e.recording {
+		status.RecordingDuration = time.Since(e.recordStart).Truncate(time.Second)
+	}
+	return status
+}
+
+// Wifi returns the current access point configuration.
+func (e *Emulator) Wifi() libipcamera.WifiConfig {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.wifi
+}
+
+// setWifi applies config if it is valid.
+func (e *Emulator) setWifi(config libipcamera.WifiConfig) uint32 {
+	if config.Validate() != nil {
+		return libipcamera.ResultDenied
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.wifi = config
+	return libipcamera.ResultOK
+}
+
+// setCredentials replaces the credentials accepted by LOGIN.
+func (e *Emulator) setCredentials(username, password string) uint32 {
+	if username == "" || password == "" {
+		return libipcamera.ResultDenied
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.username, e.password = username, password
+	return libipcamera.ResultOK
+}
+
+// Received returns how many messages of messageType the emulator received.
+func (e *Emulator) Received(messageType uint32) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.received[messageType]
+}
+
+// Clients returns the number of open control connections.
+func (e *Emulator) Clients() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.sessions)
+}
+
+// DisconnectClients closes every control connection, as a camera going out of range would.
+func (e *Emulator) DisconnectClients() {
+	e.mutex.Lock()
+	sessions := make([]*session, 0, len(e.sessions))
+	for s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	e.mutex.Unlock()
+
+	for _, s := range sessions {
+		s.close()
+	}
+}
+
+// dropReply decides whether the reply to command is lost.
+func (e *Emulator) dropReply(command uint32) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.faults.DropReplies[command] {
+		return true
+	}
+	return e.faults.DropRate > 0 && e.random.Float64() < e.faults.DropRate
+}
+
+func (e *Emulator) currentFaults() Faults {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.faults
+}
+
+func (e *Emulator) isClosed() bool {
+	select {
+	case <-e.closed:
+		return true
+	default:
+		return false
+	}
+}
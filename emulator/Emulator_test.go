@@ -0,0 +1,234 @@
+package emulator_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func startEmulator(t *testing.T, config emulator.Config) *emulator.Emulator {
+	t.Helper()
+	config.ControlAddress = "127.0.0.1:0"
+	config.HTTPAddress = "127.0.0.1:0"
+	if config.DiscoveryAddresses == nil {
+		config.DiscoveryAddresses = []string{"127.0.0.1:0"}
+	}
+	if config.AliveInterval == 0 {
+		config.AliveInterval = 20 * time.Millisecond
+	}
+	camera := emulator.CreateEmulator(config)
+	if err := camera.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	t.Cleanup(func() { camera.Close() })
+	return camera
+}
+
+func connect(t *testing.T, emu *emulator.Emulator) *libipcamera.Camera {
+	t.Helper()
+	camera, err := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera.SetLogger(libipcamera.NopLogger())
+	// The emulator implements the placeholder commands as well.
+	camera.EnableExperimentalCommands(true)
+	if err := camera.Connect(); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	t.Cleanup(camera.Disconnect)
+	if err := camera.Login(); err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	return camera
+}
+
+func TestControlProtocol(t *testing.T) {
+	files := make([]emulator.File, 100)
+	for i := range files {
+		files[i] = emulator.File{Path: fmt.Sprintf("/DCIM/MOVIE/CLIP%04d.MP4", i), Size: uint64(i + 1)}
+	}
+	emu := startEmulator(t, emulator.Config{Files: files, Firmware: "SJ4000AIR TEST"})
+	camera := connect(t, emu)
+
+	firmware, err := camera.GetFirmwareInfo()
+	if err != nil || firmware != "SJ4000AIR TEST" {
+		t.Fatalf("GetFirmwareInfo = %q, %v", firmware, err)
+	}
+
+	if err := camera.TakePicture(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if !emu.Recording() {
+		t.Fatal("emulator not recording after StartRecording")
+	}
+	if err := camera.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := camera.GetFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 102 {
+		t.Fatalf("expected 102 files, got %d", len(stored))
+	}
+	if stored[99].Path != "/DCIM/MOVIE/CLIP0099.MP4" || stored[99].Size != 100 {
+		t.Fatalf("unexpected file %+v", stored[99])
+	}
+	if stored[100].Path != "/DCIM/PHOTO/IMG0001.JPG" || stored[101].Path != "/DCIM/MOVIE/VID0001.MP4" {
+		t.Fatalf("picture or video missing: %+v", stored[100:])
+	}
+}
+
+func TestSecondClientIsRejected(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	connect(t, emu)
+
+	second, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	second.SetLogger(libipcamera.NopLogger())
+	defer second.Disconnect()
+	if err := second.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Login(); !errors.Is(err, libipcamera.ErrCameraBusy) {
+		t.Fatalf("expected ErrCameraBusy, got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connect(t, emu)
+
+	if err := camera.SetVideoResolution(libipcamera.Resolution1080p); err != nil {
+		t.Fatal(err)
+	}
+	if resolution, err := camera.VideoResolution(); err != nil || resolution != libipcamera.Resolution1080p {
+		t.Fatalf("VideoResolution = %q, %v", resolution, err)
+	}
+	if err := camera.SetAudioEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := camera.AudioEnabled(); err != nil || !enabled {
+		t.Fatalf("AudioEnabled = %v, %v", enabled, err)
+	}
+	if value, _ := emu.Setting(libipcamera.SettingVideoResolution); value != 2 {
+		t.Fatalf("emulator stored resolution %d", value)
+	}
+}
+
+func TestFaultDropReply(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connect(t, emu)
+	emu.SetFaults(emulator.Faults{DropReplies: map[uint32]bool{libipcamera.TAKE_PICTURE: true}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := camera.TakePictureContext(ctx); !errors.Is(err, libipcamera.ErrTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestFaultReplyDelay(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connect(t, emu)
+	emu.SetFaults(emulator.Faults{ReplyDelay: 100 * time.Millisecond})
+
+	started := time.Now()
+	if _, err := camera.GetFirmwareInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Fatalf("reply arrived after %s, expected a delay", elapsed)
+	}
+}
+
+func TestPreviewStreamAndMidStreamDisconnect(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	emu := startEmulator(t, emulator.Config{StreamPort: listener.LocalAddr().(*net.UDPAddr).Port})
+	emu.SetFaults(emulator.Faults{DisconnectAfterFrames: 5})
+	camera := connect(t, emu)
+	events, unsubscribe := camera.Subscribe()
+	defer unsubscribe()
+
+	if err := camera.StartPreviewStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("no preview packet received: %s", err)
+	}
+	if n < 8 || buffer[0] != 0xBC || buffer[1] != 0xDE {
+		t.Fatalf("unexpected preview packet % X", buffer[:n])
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.To == libipcamera.StateDisconnected {
+				return
+			}
+		case <-deadline:
+			t.Fatal("camera not disconnected mid-stream")
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteTo(libipcamera.CreateCommandPacket(libipcamera.DISCOVERY_REQUEST), emu.DiscoveryAddrs()[0])
+
+	buffer := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := libipcamera.Header{}
+	binary.Read(bytes.NewReader(buffer[:n]), binary.BigEndian, &header)
+	if header.MessageType != libipcamera.DISCOVERY_RESPONSE || header.Length != 80 || n != 88 {
+		t.Fatalf("expected an 80 byte DISCOVERY_RESPONSE payload, got %+v in %d bytes", header, n)
+	}
+}
+
+func TestHTTPFiles(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Files: []emulator.File{{Path: "/DCIM/MOVIE/A.MP4", Data: []byte("video")}}})
+
+	response, err := http.Get(fmt.Sprintf("http://%s/DCIM/MOVIE/A.MP4", emu.HTTPAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "video" {
+		t.Fatalf("GET returned %d %q", response.StatusCode, body)
+	}
+}
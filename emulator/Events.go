@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// lowBatteryLevel is the battery level in percent at which the camera warns.
+const lowBatteryLevel = 15
+
+// Notify sends an unsolicited message to the logged in client, if any. The
+// emulator raises its own notifications, Notify sends any other.
+func (e *Emulator) Notify(messageType uint32, payload []byte) {
+	e.mutex.Lock()
+	s := e.loggedIn
+	e.mutex.Unlock()
+	if s != nil {
+		s.send(messageType, payload)
+	}
+}
+
+// PressShutter emulates the shutter button: it starts or stops recording in
+// video mode and takes a picture in photo mode, notifying the client.
+func (e *Emulator) PressShutter() {
+	switch e.Mode() {
+	case libipcamera.ModeVideo:
+		if e.Recording() {
+			path, _ := (&libipcamera.FilePath{Path: e.controlRecording(false)}).MarshalBinary()
+			e.Notify(libipcamera.NOTIFY_RECORDING_STOPPED, path)
+		} else {
+			e.controlRecording(true)
+			e.Notify(libipcamera.NOTIFY_RECORDING_STARTED, nil)
+		}
+	case libipcamera.ModePhoto:
+		path, _ := (&libipcamera.FilePath{Path: e.takePicture()}).MarshalBinary()
+		e.Notify(libipcamera.NOTIFY_PICTURE_TAKEN, path)
+	default:
+		return
+	}
+	e.checkCardFull()
+}
+
+// Mode returns the mode selected on the camera.
+func (e *Emulator) Mode() libipcamera.CameraMode {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.mode
+}
+
+// SetMode emulates the mode button, notifying the client of the change.
+func (e *Emulator) SetMode(mode libipcamera.CameraMode) {
+	e.mutex.Lock()
+	changed := e.mode != mode
+	e.mode = mode
+	e.mutex.Unlock()
+
+	if changed {
+		payload, _ := (&libipcamera.Uint32Payload{Value: uint32(mode)}).MarshalBinary()
+		e.Notify(libipcamera.NOTIFY_MODE_CHANGED, payload)
+	}
+}
+
+// RemoveCard emulates pulling the SD card, which stops a recording in
+// progress. The files reappear when the card is inserted again.
+func (e *Emulator) RemoveCard() {
+	e.mutex.Lock()
+	removed := e.cardPresent
+	e.cardPresent = false
+	e.recording = false
+	e.mutex.Unlock()
+
+	if removed {
+		e.Notify(libipcamera.NOTIFY_CARD_REMOVED, nil)
+	}
+}
+
+// InsertCard emulates inserting the SD card again.
+func (e *Emulator) InsertCard() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.cardPresent = true
+}
+
+// checkCardFull notifies the client once when the stored files fill the card.
+func (e *Emulator) checkCardFull() {
+	e.mutex.Lock()
+	var used uint64
+	for _, file := range e.files {
+		used += file.Size
+	}
+	full := used >= e.config.CardCapacity
+	notify := full && !e.cardFull
+	e.cardFull = full
+	e.mutex.Unlock()
+
+	if notify {
+		e.Notify(libipcamera.NOTIFY_CARD_FULL, nil)
+	}
+}
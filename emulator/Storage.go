@@ -0,0 +1,49 @@
+package emulator
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// serveFile serves the SD card content at the path the camera uses in the file list.
+func (e *Emulator) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var file *File
+	for _, stored := range e.Files() {
+		if stored.Path == r.URL.Path {
+			stored := stored
+			file = &stored
+			break
+		}
+	}
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatUint(file.Size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if file.Data != nil {
+		w.Write(file.Data)
+		return
+	}
+	io.CopyN(w, patternReader{}, int64(file.Size))
+}
+
+// patternReader produces the generated content of files without data.
+type patternReader struct{}
+
+func (patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+const (
+	streamMagic     = 0xBCDE
+	streamChunkSize = 1400
+)
+
+// splitFrames splits an Annex B H.264 stream into access units, each ending
+// with a slice NAL unit. Start codes are kept.
+func splitFrames(sample []byte) [][]byte {
+	startCode := []byte{0x00, 0x00, 0x01}
+
+	var starts []int
+	for i := 0; i+3 <= len(sample); {
+		index := bytes.Index(sample[i:], startCode)
+		if index < 0 {
+			break
+		}
+		start := i + index
+		if start > 0 && sample[start-1] == 0x00 {
+			start--
+		}
+		starts = append(starts, start)
+		i += index + 3
+	}
+
+	var frames [][]byte
+	frameStart := 0
+	for n, start := range starts {
+		end := len(sample)
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		header := bytes.Index(sample[start:end], startCode) + 3
+		if start+header >= end {
+			continue
+		}
+		nalType := sample[start+header] & 0x1F
+		if nalType == 1 || nalType == 5 {
+			frames = append(frames, sample[frameStart:end])
+			frameStart = end
+		}
+	}
+	return frames
+}
+
+func (s *session) startPreview() {
+	e := s.emulator
+	e.mutex.Lock()
+	if s.streaming {
+		e.mutex.Unlock()
+		return
+	}
+	s.streaming = true
+	e.mutex.Unlock()
+
+	remote, ok := s.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	target := &net.UDPAddr{IP: remote.IP, Port: e.config.StreamPort}
+	conn, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		return
+	}
+
+	e.wg.Add(1)
+	go s.stream(conn)
+}
+
+// stream loops the sample as 0xBCDE messages: frame data chunks followed by
+// a frame end message carrying the elapsed milliseconds at offset 12.
+func (s *session) stream(conn *net.UDPConn) {
+	e := s.emulator
+	defer e.wg.Done()
+	defer conn.Close()
+
+	frames := splitFrames(e.config.Sample)
+	if len(frames) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.config.FrameInterval)
+	defer ticker.Stop()
+
+	var sequenceNumber uint16
+	send := func(messageType uint16, payload []byte) {
+		packet := &bytes.Buffer{}
+		binary.Write(packet, binary.BigEndian, uint16(streamMagic))
+		binary.Write(packet, binary.BigEndian, uint16(len(payload)))
+		binary.Write(packet, binary.BigEndian, sequenceNumber)
+		binary.Write(packet, binary.BigEndian, messageType)
+		packet.Write(payload)
+		conn.Write(packet.Bytes())
+		sequenceNumber++
+	}
+
+	started := time.Now()
+	for sent := 0; ; sent++ {
+		select {
+		case <-s.closed:
+			return
+		case <-e.closed:
+			return
+		case <-ticker.C:
+		}
+
+		frame := frames[sent%len(frames)]
+		for offset := 0; offset < len(frame); offset += streamChunkSize {
+			end := offset + streamChunkSize
+			if end > len(frame) {
+				end = len(frame)
+			}
+			send(libipcamera.STREAM_FRAME_DATA, frame[offset:end])
+		}
+		info, _ := (&libipcamera.FrameEnd{Elapsed: uint32(time.Since(started) / time.Millisecond)}).MarshalBinary()
+		send(libipcamera.STREAM_FRAME_END, info)
+
+		limit := e.currentFaults().DisconnectAfterFrames
+		if limit > 0 && sent+1 >= limit {
+			s.close()
+			return
+		}
+	}
+}
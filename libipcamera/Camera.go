@@ -1,37 +1,107 @@
 package libipcamera
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-
+// Camera is a connection to a single camera. It is safe for concurrent use.
 type Camera struct {
-	ipAddress       net.IP
-	port            int
-	username        string
-	password        string
-	connected       bool
-	disconnect      bool
-	verbose         bool
-	connection      net.Conn
-	isLoggedIn      bool
-	messageHandlers map[uint32][]MessageHandler
+	ipAddress net.IP
+	port      int
+	username  string
+	password  string
+	dialer    Dialer
+
+	// stateMutex guards the connection state below.
+	stateMutex sync.RWMutex
+	connected  bool
+	disconnect bool
+	verbose    bool
+	logger     Logger
+	connection net.Conn
+	isLoggedIn bool
+	// closeCause replaces the read error reported when the connection was
+	// closed by dropConnection.
+	closeCause error
+
+	// Session state restored by the reconnect supervisor.
+	wasLoggedIn     bool
+	previewActive   bool
+	reconnectPolicy *ReconnectPolicy
+	reconnectCancel context.CancelFunc
+	// reconnectRun identifies the supervisor owning reconnectCancel.
+	reconnectRun uint64
+	// reconnectDone is closed when the reconnect of reconnectRun ends.
+	reconnectDone chan struct{}
+
+	// profile is the model profile used for capability checks, nil allows every command.
+	profile *ModelProfile
+	// settings is the settings table, nil selects DefaultSettingsTable.
+	settings *SettingsTable
+	// experimental allows sending unverified placeholder commands.
+	experimental bool
+	// clockSyncOnLogin makes Login set the camera clock from the host clock.
+	clockSyncOnLogin bool
+	statusCancel     context.CancelFunc
+	tracer           Tracer
+
+	// handlerMutex guards the handler table. Handlers are never called while it is held.
+	handlerMutex    sync.Mutex
+	messageHandlers map[uint32][]handlerEntry
+	nextHandlerID   uint64
+	aliveHandlerID  uint64
+	observers       map[uint64]func(message *Message)
+
+	// healthMutex guards the keepalive metrics and the monitor.
+	healthMutex  sync.Mutex
+	health       HealthStats
+	healthCancel context.CancelFunc
+
+	sendMutex    sync.Mutex
+	pendingMutex sync.Mutex
+	pending      []*pendingRequest
+
+	// eventMutex guards the lifecycle state, the last polled status, their
+	// subscribers and the subscribers of the camera notifications.
+	eventMutex        sync.Mutex
+	state             ConnectionState
+	subscribers       map[uint64]chan StateEvent
+	nextSubscriberID  uint64
+	status            *CameraStatus
+	statusErr         error
+	statusSubscribers map[uint64]chan StatusEvent
+	eventSubscribers  map[uint64]chan CameraEvent
 }
 
-
+// MessageHandler handles a message received from camera. Returning
+// RemoveHandler unregisters it.
 type MessageHandler func(camera *Camera, message *Message) (bool, error)
 
+type handlerEntry struct {
+	id      uint64
+	handler MessageHandler
+}
+
+// Timeouts used by the methods without a context argument.
+const (
+	DefaultRequestTimeout  = 5 * time.Second
+	DefaultFileListTimeout = 10 * time.Second
+	DefaultFormatTimeout   = 60 * time.Second
+)
+
 const (
 	LOGIN                 = 0x0110
 	LOGIN_ACCEPT          = 0x0111
+	LOGIN_REJECTED        = 0x1234
 	ALIVE_REQUEST         = 0x0112
 	ALIVE_RESPONSE        = 0x0113
 	DISCOVERY_REQUEST     = 0x0114
@@ -45,16 +115,53 @@ const (
 	PICTURE_SAVED         = 0xA039
 	CONTROL_RECORDING     = 0xA03A
 	RECORD_COMMAND_ACCEPT = 0xA03B
+
+	// Placeholders: the clock commands and their ClockTime payload were not
+	// observed on a real camera, see IsVerifiedCommand.
+	REQUEST_CLOCK     = 0xA070
+	CLOCK_INFORMATION = 0xA071
+	SET_CLOCK         = 0xA072
+	CLOCK_SET_ACCEPT  = 0xA073
+
+	// Placeholders: the status commands and their CameraStatus payload were
+	// not observed on a real camera, see IsVerifiedCommand.
+	REQUEST_STATUS     = 0xA074
+	STATUS_INFORMATION = 0xA075
+
+	// Placeholders: no capture or vendor documentation of deleting files or
+	// formatting the card is available, see IsVerifiedCommand.
+	DELETE_FILE    = 0xA076
+	FILE_DELETED   = 0xA077
+	FORMAT_CARD    = 0xA078
+	CARD_FORMATTED = 0xA079
+
+	// Placeholders: the Wi-Fi and credential commands and the WifiConfig
+	// payload were not observed on a real camera, see IsVerifiedCommand.
+	REQUEST_WIFI     = 0xA07A
+	WIFI_INFORMATION = 0xA07B
+	SET_WIFI         = 0xA07C
+	WIFI_SET_ACCEPT  = 0xA07D
+	SET_LOGIN        = 0xA07E
+	LOGIN_SET_ACCEPT = 0xA07F
+
+	// Placeholders: unsolicited notifications, see SubscribeEvents. These IDs
+	// and payloads were not observed on a real camera; RegisterNotification
+	// maps the IDs observed on a model to their events.
+	NOTIFY_RECORDING_STARTED = 0xA090
+	NOTIFY_RECORDING_STOPPED = 0xA091
+	NOTIFY_PICTURE_TAKEN     = 0xA092
+	NOTIFY_CARD_REMOVED      = 0xA093
+	NOTIFY_CARD_FULL         = 0xA094
+	NOTIFY_LOW_BATTERY       = 0xA095
+	NOTIFY_MODE_CHANGED      = 0xA096
 )
 
 const (
-
 	RemoveHandler = true
 
 	KeepHandler = false
 )
 
-
 type StoredFile struct {
 	Path string
 	Size uint64
@@ -69,86 +176,184 @@ func CreateCamera(ipAddress net.IP, port int, username, password string) (*Camer
 		port:            port,
 		username:        username,
 		password:        password,
-		messageHandlers: make(map[uint32][]MessageHandler, 0),
+		dialer:          &net.Dialer{},
+		messageHandlers: make(map[uint32][]handlerEntry, 0),
 		verbose:         true,
+		logger:          defaultLogger(),
 	}
 	return camera, nil
 }
 
+// CreateCameraWithConn creates a camera on top of an already established
+// connection, such as a tunnel or one end of net.Pipe. Connect uses conn
+// instead of dialing; as conn cannot be dialed again, a camera created this
+// way can only reconnect after SetDialer was called.
+func CreateCameraWithConn(conn net.Conn, username, password string) (*Camera, error) {
+	if conn == nil {
+		return nil, errors.New("Não é possível criar uma câmera sem uma conexão")
+	}
+	camera := &Camera{
+		username:        username,
+		password:        password,
+		dialer:          &connDialer{conn: conn},
+		messageHandlers: make(map[uint32][]handlerEntry, 0),
+		verbose:         true,
+		logger:          defaultLogger(),
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		camera.ipAddress = addr.IP
+		camera.port = addr.Port
+	}
+	return camera, nil
+}
 
-func (c *Camera) Connect() {
-	if c.verbose {
-		log.Printf("Conectando à %s:%d usando nome de usuário =%s, senha=%s\n", c.ipAddress, c.port, c.username, c.password)
+// SetDialer replaces the dialer used by Connect and by the reconnect supervisor.
+func (c *Camera) SetDialer(dialer Dialer) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
 	}
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", c.ipAddress, c.port))
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.dialer = dialer
+}
+
+// address returns the host:port the dialer connects to.
+func (c *Camera) address() string {
+	return net.JoinHostPort(c.ipAddress.String(), strconv.Itoa(c.port))
+}
+
+// Connect opens the control connection to the camera.
+func (c *Camera) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext opens the control connection to the camera, aborting the dial when ctx is done.
+func (c *Camera) ConnectContext(ctx context.Context) error {
+	username, password := c.credentials()
+	c.log(LevelDebug, "Conectando à câmera", F("address", c.ipAddress), F("port", c.port), F("username", username), F("password", password))
+	c.setState(StateConnecting, nil)
+	c.stateMutex.RLock()
+	dialer := c.dialer
+	c.stateMutex.RUnlock()
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.address())
 	if err != nil {
-		log.Printf("ERROR: %s\n", err)
-		return
+		c.setState(StateDisconnected, err)
+		return err
 	}
+	c.stateMutex.Lock()
+	previous := c.connection
 	c.connection = conn
+	c.connected = true
+	c.disconnect = false
+	c.stateMutex.Unlock()
+	if previous != nil {
+		// Ends the read loop of a connection that is still open, failing the
+		// requests sent on it.
+		previous.Close()
+	}
 
-	c.HandleFirst(ALIVE_REQUEST, aliveRequestHandler)
+	c.installConnectionHandlers()
+	c.resetHealth()
+	c.setState(StateConnected, nil)
 
-	go c.handleConnection()
+	go c.handleConnection(conn)
+	return nil
 }
 
-
 func (c *Camera) Login() error {
-	loginAccept := make(chan bool, 0)
-
-	c.Handle(LOGIN_ACCEPT, func(c *Camera, m *Message) (bool, error) {
-		_, err := loginResultHandler(c, m)
-		if err != nil {
-			return RemoveHandler, err
-		}
-		loginAccept <- true
-		return RemoveHandler, nil
-	})
-
-	c.SendPacket(CreateLoginPacket(c.username, c.password))
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.LoginContext(ctx)
+}
 
-	select {
-	case <-loginAccept:
-		return nil
-	case <-time.After(5 * time.Second):
-		return errors.New("A solicitação de login expirou")
+// LoginContext authenticates with the camera using the configured credentials.
+func (c *Camera) LoginContext(ctx context.Context) error {
+	username, password := c.credentials()
+	login, _ := (&LoginRequest{Username: username, Password: password}).MarshalBinary()
+	replies, err := c.request(ctx, LOGIN, login, []uint32{LOGIN_ACCEPT, LOGIN_REJECTED}, false)
+	if err == nil {
+		_, err = loginResultHandler(c, replies[0])
 	}
+	if err != nil && c.IsConnected() {
+		c.setState(StateConnected, err)
+	}
+	if err == nil {
+		c.syncClockAfterLogin(ctx)
+	}
+	return err
 }
 
 func (c *Camera) IsConnected() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
 	return c.connected
 }
 
-func (c *Camera) handleConnection() {
+// IsLoggedIn reports whether the camera accepted our login on the current connection.
+func (c *Camera) IsLoggedIn() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.isLoggedIn
+}
+
+// acceptLogin marks the camera logged in unless the connection closed after
+// the login reply arrived.
+func (c *Camera) acceptLogin() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if !c.connected {
+		return false
+	}
+	c.isLoggedIn = true
+	c.wasLoggedIn = true
+	return true
+}
+
+func (c *Camera) isDisconnecting() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.disconnect
+}
+
+func (c *Camera) isVerbose() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.verbose
+}
+
+func (c *Camera) handleConnection(conn net.Conn) {
 	header := Header{}
 	var payload []byte
+	var cause error
 
 	for {
-		if c.disconnect {
+		if c.isDisconnecting() {
 			break
 		}
 
-
-		err := binary.Read(c.connection, binary.BigEndian, &header)
+		err := binary.Read(conn, binary.BigEndian, &header)
 		if err != nil {
-			if !c.disconnect {
-				log.Printf("ERRO ao ler da câmera: %s\n", err)
+			if !c.isDisconnecting() {
+				c.log(LevelError, "ERRO ao ler da câmera", F("error", err))
 			}
+			cause = err
 			break
 		}
 
-	
 		if header.Magic != 0xABCD {
-			log.Printf("Mensagem recebida como inválida (%x)\n", header.Magic)
+			c.trace(TraceReceived, encodeHeader(header))
+			c.log(LevelError, "Mensagem recebida como inválida", F("magic", fmt.Sprintf("0x%X", header.Magic)))
+			cause = &ProtocolError{Header: header, Reason: "magic inválido"}
 			break
 		}
 
-
 		if header.Length > 0 {
 			payload = make([]byte, header.Length)
-			bytesRead, err := io.ReadFull(c.connection, payload)
+			bytesRead, err := io.ReadFull(conn, payload)
 			if err != nil || (uint16(bytesRead) != header.Length) {
-				log.Printf("ERRO ao ler a carga útil da câmera: %s, expected %d Bytes, got %d\n", err, header.Length, bytesRead)
+				c.log(LevelError, "ERRO ao ler a carga útil da câmera", F("error", err), F("expected", header.Length), F("read", bytesRead))
+				cause = &ProtocolError{Header: header, Reason: fmt.Sprintf("carga útil incompleta (%d de %d bytes)", bytesRead, header.Length)}
 				break
 			}
 		} else {
@@ -159,92 +364,224 @@ func (c *Camera) handleConnection() {
 			Header:  header,
 			Payload: payload,
 		}
+		c.trace(TraceReceived, CreatePacket(header, payload))
+
+		c.recordHealth(message)
+		observed := c.notifyObservers(message)
+		notification := c.publishEvent(message)
+		consumed := c.deliverPending(message)
+		if !c.dispatch(message) && !consumed && !observed && !notification {
+			c.log(LevelWarn, "Mensagem desconhecida recebida (nenhum manipulador registrado)", F("message", message))
+		}
+	}
+	c.log(LevelDebug, "Desconectado", F("address", c.ipAddress), F("cause", cause))
+
+	c.stateMutex.Lock()
+	current := c.connection == conn
+	if current {
+		c.connected = false
+		c.isLoggedIn = false
+		if c.closeCause != nil {
+			cause, c.closeCause = c.closeCause, nil
+		}
+	}
+	c.stateMutex.Unlock()
 
-		if len(c.messageHandlers[header.MessageType]) == 0 {
-			log.Printf("Mensagem desconhecida recebida (nenhum manipulador registrado):\n%s\n", message)
-			continue
+	if current {
+		c.failPending(ErrDisconnected)
+		if cause == nil {
+			cause = ErrDisconnected
 		}
+		if !c.isDisconnecting() {
+			c.setState(StateDisconnected, cause)
+		}
+		c.connectionLost(cause)
+	}
+}
 
+// dispatch runs the handlers registered for the message type and reports whether there were any.
+func (c *Camera) dispatch(message *Message) bool {
+	messageType := message.Header.MessageType
 
-		remainingMessageHandlers := make([]MessageHandler, 0)
-		for _, handler := range c.messageHandlers[header.MessageType] {
-			remove, err := handler(c, message)
-			if remove == KeepHandler {
-				remainingMessageHandlers = append(remainingMessageHandlers, handler)
-			}
+	c.handlerMutex.Lock()
+	handlers := append([]handlerEntry(nil), c.messageHandlers[messageType]...)
+	c.handlerMutex.Unlock()
 
-			if err != nil {
-				log.Printf("ERRO ao executar o manipulador de mensagens (%v): %s\n", handler, err)
-				break
+	if len(handlers) == 0 {
+		return false
+	}
+
+	removed := make(map[uint64]bool)
+	for _, entry := range handlers {
+		remove, err := entry.handler(c, message)
+		if remove == RemoveHandler {
+			removed[entry.id] = true
+		}
+
+		if err != nil {
+			c.log(LevelError, "ERRO ao executar o manipulador de mensagens", F("handler", entry.id), F("type", fmt.Sprintf("0x%04X", messageType)), F("error", err))
+			break
+		}
+	}
+
+	if len(removed) > 0 {
+		c.handlerMutex.Lock()
+		remainingMessageHandlers := make([]handlerEntry, 0)
+		for _, entry := range c.messageHandlers[messageType] {
+			if !removed[entry.id] {
+				remainingMessageHandlers = append(remainingMessageHandlers, entry)
 			}
 		}
-	
-		c.messageHandlers[header.MessageType] = remainingMessageHandlers
+		c.messageHandlers[messageType] = remainingMessageHandlers
+		c.handlerMutex.Unlock()
 	}
-	c.Log("Desconectado")
-	c.connected = false
+	return true
 }
 
-
 func (c *Camera) Handle(messageType uint32, handleFunc MessageHandler) {
 	c.addHandler(messageType, handleFunc, false)
 }
 
-
 func (c *Camera) HandleFirst(messageType uint32, handleFunc MessageHandler) {
 	c.addHandler(messageType, handleFunc, true)
 }
 
-func (c *Camera) addHandler(messageType uint32, handleFunc MessageHandler, prepend bool) {
+func (c *Camera) addHandler(messageType uint32, handleFunc MessageHandler, prepend bool) uint64 {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+
 	if c.messageHandlers[messageType] == nil {
-		c.messageHandlers[messageType] = make([]MessageHandler, 0)
+		c.messageHandlers[messageType] = make([]handlerEntry, 0)
 	}
 
+	c.nextHandlerID++
+	entry := handlerEntry{id: c.nextHandlerID, handler: handleFunc}
 	if prepend {
-		c.messageHandlers[messageType] = append([]MessageHandler{handleFunc}, c.messageHandlers[messageType]...)
+		c.messageHandlers[messageType] = append([]handlerEntry{entry}, c.messageHandlers[messageType]...)
 	} else {
-		c.messageHandlers[messageType] = append(c.messageHandlers[messageType], handleFunc)
+		c.messageHandlers[messageType] = append(c.messageHandlers[messageType], entry)
 	}
+	return entry.id
 }
 
+// Observe calls observer with every message received from the camera, before
+// pending requests and handlers see it, until the returned function is
+// called. Messages without handler are not logged as unknown while an
+// observer is registered.
+func (c *Camera) Observe(observer func(message *Message)) func() {
+	c.handlerMutex.Lock()
+	c.nextHandlerID++
+	id := c.nextHandlerID
+	if c.observers == nil {
+		c.observers = make(map[uint64]func(message *Message))
+	}
+	c.observers[id] = observer
+	c.handlerMutex.Unlock()
 
-func (c *Camera) Log(format string, data ...interface{}) {
-	if c.verbose {
-		if data != nil {
-			log.Printf(format+"\n", data)
-		} else {
-			log.Printf(format + "\n")
-		}
+	return func() {
+		c.handlerMutex.Lock()
+		defer c.handlerMutex.Unlock()
+		delete(c.observers, id)
 	}
 }
 
+// notifyObservers reports whether there were any observers.
+func (c *Camera) notifyObservers(message *Message) bool {
+	c.handlerMutex.Lock()
+	observers := make([]func(message *Message), 0, len(c.observers))
+	for _, observer := range c.observers {
+		observers = append(observers, observer)
+	}
+	c.handlerMutex.Unlock()
 
-func (c *Camera) GetFileList() ([]StoredFile, error) {
-	fileListComplete := make(chan []StoredFile, 1)
-	fileListData := ""
+	for _, observer := range observers {
+		observer(message)
+	}
+	return len(observers) > 0
+}
 
-	c.Handle(FILE_LIST_CONTENT, func(c *Camera, m *Message) (bool, error) {
-		numParts := binary.LittleEndian.Uint32(m.Payload[:4])
-		currentPart := binary.LittleEndian.Uint32(m.Payload[4:8])
-		fileListData += string(m.Payload[8:])
-		if currentPart+1 >= numParts {
-			fileListComplete <- parseFileList(fileListData)
-			return RemoveHandler, nil
+// installConnectionHandlers (re-)installs the handlers every connection needs.
+func (c *Camera) installConnectionHandlers() {
+	c.handlerMutex.Lock()
+	previous := c.aliveHandlerID
+	c.handlerMutex.Unlock()
+
+	if previous != 0 {
+		c.removeHandler(ALIVE_REQUEST, previous)
+	}
+	id := c.addHandler(ALIVE_REQUEST, aliveRequestHandler, true)
+
+	c.handlerMutex.Lock()
+	c.aliveHandlerID = id
+	c.handlerMutex.Unlock()
+}
+
+func (c *Camera) removeHandler(messageType uint32, id uint64) {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+
+	handlers := c.messageHandlers[messageType]
+	for i, entry := range handlers {
+		if entry.id == id {
+			c.messageHandlers[messageType] = append(handlers[:i:i], handlers[i+1:]...)
+			return
 		}
-		return KeepHandler, nil
-	})
+	}
+}
+
+// Log writes a formatted debug message to the camera's logger when verbose output is enabled.
+func (c *Camera) Log(format string, data ...interface{}) {
+	c.log(LevelDebug, fmt.Sprintf(format, data...))
+}
+
+// log writes an entry to the camera's logger. Debug entries are only written
+// when verbose output is enabled.
+func (c *Camera) log(level Level, message string, fields ...Field) {
+	c.stateMutex.RLock()
+	logger := c.logger
+	verbose := c.verbose
+	c.stateMutex.RUnlock()
+
+	if level == LevelDebug && !verbose {
+		return
+	}
+	logger.Log(level, message, fields...)
+}
+
+// SetLogger replaces the logger of the camera. Fields carrying credentials
+// are redacted before they reach logger.
+func (c *Camera) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger()
+	}
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.logger = NewRedactingLogger(logger)
+}
+
+func (c *Camera) GetFileList() ([]StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultFileListTimeout)
+	defer cancel()
+	return c.GetFileListContext(ctx)
+}
 
-	err := c.SendPacket(CreatePacket(CreateCommandHeader(REQUEST_FILE_LIST), []byte{0x01, 0x00, 0x00, 0x00}))
+// GetFileListContext retrieves the list of files stored on the SD-Card.
+func (c *Camera) GetFileListContext(ctx context.Context) ([]StoredFile, error) {
+	request, _ := (&Uint32Payload{Value: 1}).MarshalBinary()
+	parts, err := c.RequestMultipart(ctx, REQUEST_FILE_LIST, request, FILE_LIST_CONTENT)
 	if err != nil {
 		return nil, err
 	}
 
-	select {
-	case result := <-fileListComplete:
-		return result, nil
-	case <-time.After(10 * time.Second):
-		return nil, errors.New("Tempo esgotado ao carregar a lista de arquivos")
+	fileListData := ""
+	for _, message := range parts {
+		part := FileListPart{}
+		if err := part.UnmarshalBinary(message.Payload); err != nil {
+			return nil, &ProtocolError{Header: message.Header, Reason: err.Error()}
+		}
+		fileListData += string(part.Data)
 	}
+	return parseFileList(fileListData), nil
 }
 
 func parseFileList(input string) []StoredFile {
@@ -266,135 +603,178 @@ func parseFileList(input string) []StoredFile {
 	return stored
 }
 
-
 func (c *Camera) GetFirmwareInfo() (string, error) {
-	if !c.isLoggedIn {
-		return "", errors.New("É necessário fazer login na câmera")
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetFirmwareInfoContext(ctx)
+}
+
+// GetFirmwareInfoContext retrieves the firmware version string of the camera.
+func (c *Camera) GetFirmwareInfoContext(ctx context.Context) (string, error) {
+	if !c.IsLoggedIn() {
+		return "", ErrNotLoggedIn
 	}
 
-	firmwareInfo := make(chan string, 1)
-	c.Handle(FIRMWARE_INFORMATION, func(c *Camera, m *Message) (bool, error) {
-		firmwareInfo <- string(m.Payload)
-		return RemoveHandler, nil
-	})
-	err := c.SendPacket(CreateCommandPacket(REQUEST_FIRMWARE_INFO))
+	reply, err := c.Request(ctx, REQUEST_FIRMWARE_INFO, nil, FIRMWARE_INFORMATION)
 	if err != nil {
 		return "", err
 	}
-
-	select {
-	case result := <-firmwareInfo:
-		return result, nil
-	case <-time.After(5 * time.Second):
-		return "", errors.New("Solicitação de informações de firmware expirou")
+	information := FirmwareInformation{}
+	if err := information.UnmarshalBinary(reply.Payload); err != nil {
+		return "", &ProtocolError{Header: reply.Header, Reason: err.Error()}
 	}
+	return information.Version, nil
 }
 
-
 func (c *Camera) SendPacket(packet []byte) error {
-	_, err := c.connection.Write(packet)
+	c.stateMutex.RLock()
+	conn := c.connection
+	c.stateMutex.RUnlock()
+
+	if conn == nil {
+		return ErrDisconnected
+	}
+	_, err := conn.Write(packet)
+	if err == nil {
+		c.trace(TraceSent, packet)
+	}
 	return err
 }
 
-
 func (c *Camera) TakePicture() error {
-	if !c.isLoggedIn {
-		return errors.New("É necessário fazer login na câmera")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.TakePictureContext(ctx)
+}
 
-	pictureTaken := make(chan bool, 1)
-	c.Handle(PICTURE_SAVED, func(c *Camera, m *Message) (bool, error) {
-		c.Log("A imagem foi salva no cartão SD")
-		pictureTaken <- true
-		return RemoveHandler, nil
-	})
+// TakePictureContext takes a picture and saves it to the SD-Card.
+func (c *Camera) TakePictureContext(ctx context.Context) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
 
-	err := c.SendPacket(CreateCommandPacket(TAKE_PICTURE))
+	_, err := c.Request(ctx, TAKE_PICTURE, nil, PICTURE_SAVED)
 	if err != nil {
 		return err
 	}
-
-	select {
-	case <-pictureTaken:
-		return nil
-	case <-time.After(5 * time.Second):
-		return errors.New("A solicitação TAKE_PICTURE expirou")
-	}
+	c.Log("A imagem foi salva no cartão SD")
+	return nil
 }
 
 func (c *Camera) StartPreviewStream() error {
-	if !c.isLoggedIn {
-		return errors.New("É necessário fazer login na câmera")
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	if err := c.checkSupported(START_PREVIEW); err != nil {
+		return err
 	}
 	c.Log("Iniciando fluxo de visualização")
-	return c.SendPacket(CreateCommandPacket(START_PREVIEW))
+	err := c.SendPacket(CreateCommandPacket(START_PREVIEW))
+	if err == nil {
+		c.stateMutex.Lock()
+		c.previewActive = true
+		c.stateMutex.Unlock()
+		c.setState(StateStreaming, nil)
+	}
+	return err
 }
 
-
 func (c *Camera) StartRecording() error {
-	if !c.isLoggedIn {
-		return errors.New("É necessário fazer login na câmera")
-	}
-
-	recordCommandAccept := make(chan bool, 1)
-
-	c.Handle(RECORD_COMMAND_ACCEPT, func(c *Camera, m *Message) (bool, error) {
-		c.Log("Começou a gravar vídeo")
-		recordCommandAccept <- true
-		return RemoveHandler, nil
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.StartRecordingContext(ctx)
+}
 
+// StartRecordingContext starts recording video to SD-Card
+func (c *Camera) StartRecordingContext(ctx context.Context) error {
 	c.Log("Solicitando câmera para iniciar a gravação")
-	err := c.SendPacket(CreatePacket(CreateCommandHeader(CONTROL_RECORDING), []byte{0x01, 0x00, 0x00, 0x00}))
-	if err != nil {
-		return err
-	}
-
-	select {
-	case <-recordCommandAccept:
-		return nil
-	case <-time.After(5 * time.Second):
-		return errors.New("A solicitação de CONTROL_RECORDING expirou")
+	err := c.controlRecording(ctx, true)
+	if err == nil {
+		c.Log("Começou a gravar vídeo")
 	}
+	return err
 }
 
 // StopRecording stops recording video to SD-Card
 func (c *Camera) StopRecording() error {
-	if !c.isLoggedIn {
-		return errors.New("É necessário fazer login na câmera")
-	}
-
-	recordCommandAccept := make(chan bool, 1)
-
-	c.Handle(RECORD_COMMAND_ACCEPT, func(c *Camera, m *Message) (bool, error) {
-		c.Log("Parando de gravar vídeo")
-		recordCommandAccept <- true
-		return RemoveHandler, nil
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.StopRecordingContext(ctx)
+}
 
+// StopRecordingContext stops recording video to SD-Card
+func (c *Camera) StopRecordingContext(ctx context.Context) error {
 	c.Log("Solicitando que a câmera pare de gravar")
-	err := c.SendPacket(CreatePacket(CreateCommandHeader(CONTROL_RECORDING), []byte{0x00, 0x00, 0x00, 0x00}))
-	if err != nil {
-		return err
+	err := c.controlRecording(ctx, false)
+	if err == nil {
+		c.Log("Parando de gravar vídeo")
 	}
+	return err
+}
 
-	select {
-	case <-recordCommandAccept:
-		return nil
-	case <-time.After(5 * time.Second):
-		return errors.New("A solicitação de CONTROL_RECORDING expirou")
+func (c *Camera) controlRecording(ctx context.Context, start bool) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
 	}
-}
 
+	payload, _ := (&RecordingControl{Start: start}).MarshalBinary()
+	_, err := c.Request(ctx, CONTROL_RECORDING, payload, RECORD_COMMAND_ACCEPT)
+	return err
+}
 
 func (c *Camera) Disconnect() {
+	defer c.setState(StateDisconnected, nil)
+
+	c.stateMutex.Lock()
 	c.disconnect = true
 	c.connected = false
-	c.connection.Close()
+	c.isLoggedIn = false
+	c.wasLoggedIn = false
+	c.previewActive = false
+	c.endReconnect()
+	if c.statusCancel != nil {
+		c.statusCancel()
+		c.statusCancel = nil
+	}
+	conn := c.connection
+	c.stateMutex.Unlock()
+
+	// The monitor is stopped without holding stateMutex, so healthMutex is
+	// never taken under it.
+	c.DisableHealthMonitor()
+	if conn != nil {
+		conn.Close()
+	}
 }
 
+// closeConnection drops the current connection without ending the session,
+// so the reconnect supervisor keeps running.
+func (c *Camera) closeConnection() {
+	c.stateMutex.RLock()
+	conn := c.connection
+	c.stateMutex.RUnlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// dropConnection closes the current connection like closeConnection,
+// reporting cause instead of the read error.
+func (c *Camera) dropConnection(cause error) {
+	c.stateMutex.Lock()
+	conn := c.connection
+	if conn != nil {
+		c.closeCause = cause
+	}
+	c.stateMutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
 
 func (c *Camera) SetVerbose(verbose bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
 	c.verbose = verbose
 }
 
@@ -404,20 +784,16 @@ func aliveRequestHandler(camera *Camera, message *Message) (bool, error) {
 	return KeepHandler, camera.SendPacket(response)
 }
 
-
-func firmwareInfoHandler(camera *Camera, message *Message) (bool, error) {
-	camera.Log("Informações de firmware recebidas")
-	camera.Log("Firmware Version: %s", string(message.Payload))
-	return KeepHandler, nil
-}
-
 func loginResultHandler(camera *Camera, message *Message) (bool, error) {
-	if message.Header.MessageType == 0x0111 {
-		camera.isLoggedIn = true
+	if message.Header.MessageType == LOGIN_ACCEPT {
+		if !camera.acceptLogin() {
+			return RemoveHandler, ErrDisconnected
+		}
+		camera.setState(StateLoggedIn, nil)
 		camera.Log("Login Aceito")
-	} else if message.Header.MessageType == 0x1234 { 
+	} else if message.Header.MessageType == LOGIN_REJECTED {
 		camera.Log("Login Falhou")
-		return RemoveHandler, errors.New("Já existe um cliente conectado à câmera")
+		return RemoveHandler, ErrCameraBusy
 	}
 	return RemoveHandler, nil
 }
@@ -1,17 +1,124 @@
-package libipcamera
+package libipcamera_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
 )
 
+// contextOperations are the operations taking a context, against an
+// emulated camera that never answers them.
+var contextOperations = map[string]func(c *libipcamera.Camera, ctx context.Context) error{
+	"TakePicture":    func(c *libipcamera.Camera, ctx context.Context) error { return c.TakePictureContext(ctx) },
+	"StartRecording": func(c *libipcamera.Camera, ctx context.Context) error { return c.StartRecordingContext(ctx) },
+	"StopRecording":  func(c *libipcamera.Camera, ctx context.Context) error { return c.StopRecordingContext(ctx) },
+	"GetFileList": func(c *libipcamera.Camera, ctx context.Context) error {
+		_, err := c.GetFileListContext(ctx)
+		return err
+	},
+	"GetFirmwareInfo": func(c *libipcamera.Camera, ctx context.Context) error {
+		_, err := c.GetFirmwareInfoContext(ctx)
+		return err
+	},
+}
+
+func TestContextCancellation(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.TAKE_PICTURE, libipcamera.CONTROL_RECORDING, libipcamera.REQUEST_FILE_LIST, libipcamera.REQUEST_FIRMWARE_INFO)
+	camera := connectClient(t, emu)
+	handlers := libipcamera.HandlerCount(camera)
+
+	for name, operation := range contextOperations {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err := operation(camera, ctx)
+		if !errors.Is(err, context.Canceled) || errors.Is(err, libipcamera.ErrTimeout) {
+			t.Fatalf("%s: expected context.Canceled, got %v", name, err)
+		}
+		if libipcamera.PendingCount(camera) != 0 || libipcamera.HandlerCount(camera) != handlers {
+			t.Fatalf("%s: request left behind after cancellation", name)
+		}
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.TAKE_PICTURE, libipcamera.CONTROL_RECORDING, libipcamera.REQUEST_FILE_LIST, libipcamera.REQUEST_FIRMWARE_INFO)
+	camera := connectClient(t, emu)
+	handlers := libipcamera.HandlerCount(camera)
+
+	for name, operation := range contextOperations {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := operation(camera, ctx)
+		cancel()
+		var timeout *libipcamera.TimeoutError
+		if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected *TimeoutError, got %v", name, err)
+		}
+		if libipcamera.PendingCount(camera) != 0 || libipcamera.HandlerCount(camera) != handlers {
+			t.Fatalf("%s: request left behind after timeout", name)
+		}
+	}
+}
+
+func TestLoginContextTimeout(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.LOGIN)
+	camera := createClient(t, emu)
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer camera.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := camera.LoginContext(ctx); !errors.Is(err, libipcamera.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if camera.IsLoggedIn() || libipcamera.PendingCount(camera) != 0 {
+		t.Fatal("login timed out but the camera is logged in or the request is pending")
+	}
+}
+
+func TestConnectContextCancellation(t *testing.T) {
+	camera, _ := libipcamera.CreateCamera(net.ParseIP("192.0.2.1"), 6666, "admin", "12345")
+	camera.SetLogger(libipcamera.NopLogger())
+	camera.SetDialer(libipcamera.DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := camera.ConnectContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if camera.IsConnected() || camera.State() != libipcamera.StateDisconnected {
+		t.Fatalf("cancelled dial left the camera %s", camera.State())
+	}
+}
+
 func ExampleCreateCamera() {
 	cameraIP := net.ParseIP("192.168.0.1")
-	camera := CreateCamera(cameraIP, 6666, "admin", "12345")
+	camera, err := libipcamera.CreateCamera(cameraIP, 6666, "admin", "12345")
+	if err != nil {
+		fmt.Printf("Falha ao criar a câmera: %s\n", err)
+		return
+	}
 	defer camera.Disconnect()
 	camera.SetVerbose(true)
-	camera.Connect()
-	err := camera.Login()
+	err = camera.Connect()
+	if err != nil {
+		fmt.Printf("Falha ao conectar: %s\n", err)
+		return
+	}
+	err = camera.Login()
 	if err != nil {
 		fmt.Printf("Falha ao fazer login: %s\n", err)
 	}
@@ -22,10 +129,30 @@ func ExampleCreateCamera() {
 	}
 }
 
+func ExampleCamera_TakePictureContext() {
+	camera, _ := libipcamera.CreateCamera(net.ParseIP("192.168.0.1"), 6666, "admin", "12345")
+	defer camera.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := camera.ConnectContext(ctx); err != nil {
+		fmt.Printf("Falha ao conectar: %s\n", err)
+		return
+	}
+	if err := camera.LoginContext(ctx); err != nil {
+		fmt.Printf("Falha ao fazer login: %s\n", err)
+		return
+	}
+	if err := camera.TakePictureContext(ctx); err != nil {
+		fmt.Printf("Falha ao tirar uma foto: %s\n", err)
+	}
+}
+
 func ExampleCreatePacket() {
 
-	header := CreateCommandHeader(TAKE_PICTURE)
+	header := libipcamera.CreateCommandHeader(libipcamera.TAKE_PICTURE)
 	payload := []byte{}
-	packet := CreatePacket(header, payload)
+	packet := libipcamera.CreatePacket(header, payload)
 	fmt.Printf("Packet Data: %X\n", packet)
 }
@@ -0,0 +1,130 @@
+package libipcamera
+
+import (
+	"context"
+	"time"
+)
+
+// ClockSync is the result of SyncClock. Drift is the offset of the camera
+// clock from the host clock before the sync, Residual the offset read back
+// afterwards. Positive values mean the camera is ahead. The camera clock has a
+// resolution of one second.
+type ClockSync struct {
+	CameraTime time.Time
+	Drift      time.Duration
+	Residual   time.Duration
+}
+
+// GetClock reads the date, time and timezone of the camera.
+func (c *Camera) GetClock() (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetClockContext(ctx)
+}
+
+// GetClockContext reads the date, time and timezone of the camera.
+func (c *Camera) GetClockContext(ctx context.Context) (time.Time, error) {
+	cameraTime, _, err := c.readClock(ctx)
+	return cameraTime, err
+}
+
+// readClock reads the camera clock and its offset from the host clock,
+// taking the host time halfway through the round trip.
+func (c *Camera) readClock(ctx context.Context) (time.Time, time.Duration, error) {
+	if !c.IsLoggedIn() {
+		return time.Time{}, 0, ErrNotLoggedIn
+	}
+
+	sent := time.Now()
+	reply, err := c.Request(ctx, REQUEST_CLOCK, nil, CLOCK_INFORMATION)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	received := time.Now()
+
+	clock := ClockTime{}
+	if err := clock.UnmarshalBinary(reply.Payload); err != nil {
+		return time.Time{}, 0, &ProtocolError{Header: reply.Header, Reason: err.Error()}
+	}
+	host := sent.Add(received.Sub(sent) / 2)
+	return clock.Time, clock.Time.Sub(host.Truncate(time.Second)), nil
+}
+
+// SetClock sets the date, time and timezone of the camera to t, in the
+// location of t.
+func (c *Camera) SetClock(t time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.SetClockContext(ctx, t)
+}
+
+// SetClockContext sets the date, time and timezone of the camera to t, in the
+// location of t.
+func (c *Camera) SetClockContext(ctx context.Context, t time.Time) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	payload, _ := (&ClockTime{Time: t}).MarshalBinary()
+	_, err := c.Request(ctx, SET_CLOCK, payload, CLOCK_SET_ACCEPT)
+	return err
+}
+
+// SyncClock sets the camera clock from the host clock in the local timezone
+// and reads it back to report the drift.
+func (c *Camera) SyncClock() (ClockSync, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.SyncClockContext(ctx)
+}
+
+// SyncClockContext sets the camera clock from the host clock in the local
+// timezone and reads it back to report the drift.
+func (c *Camera) SyncClockContext(ctx context.Context) (ClockSync, error) {
+	result := ClockSync{}
+	_, drift, err := c.readClock(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Drift = drift
+
+	// The camera only keeps whole seconds, so the clock is set when the host
+	// clock is at the start of a second.
+	now := time.Now()
+	next := now.Truncate(time.Second).Add(time.Second)
+	select {
+	case <-ctx.Done():
+		return result, ctx.Err()
+	case <-time.After(next.Sub(now)):
+	}
+	if err := c.SetClockContext(ctx, next.Local()); err != nil {
+		return result, err
+	}
+
+	result.CameraTime, result.Residual, err = c.readClock(ctx)
+	if err != nil {
+		return result, err
+	}
+	c.log(LevelDebug, "Relógio da câmera sincronizado", F("drift", result.Drift), F("residual", result.Residual))
+	return result, nil
+}
+
+// SetClockSyncOnLogin makes every successful Login, including those of the
+// reconnect supervisor, sync the camera clock from the host clock. A failed
+// sync is logged and does not fail the Login.
+func (c *Camera) SetClockSyncOnLogin(enabled bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.clockSyncOnLogin = enabled
+}
+
+func (c *Camera) syncClockAfterLogin(ctx context.Context) {
+	c.stateMutex.RLock()
+	enabled := c.clockSyncOnLogin
+	c.stateMutex.RUnlock()
+	if !enabled {
+		return
+	}
+	if _, err := c.SyncClockContext(ctx); err != nil {
+		c.log(LevelWarn, "Não foi possível sincronizar o relógio da câmera", F("error", err))
+	}
+}
@@ -0,0 +1,121 @@
+package libipcamera_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestClockCommandsRequireExperimental(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	if _, err := camera.GetClock(); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("GetClock = %v, expected ErrUnverifiedCommand", err)
+	}
+	var unverified *libipcamera.UnverifiedCommandError
+	if err := camera.SetClock(time.Now()); !errors.As(err, &unverified) || unverified.Command != libipcamera.SET_CLOCK {
+		t.Fatalf("SetClock = %v, expected an UnverifiedCommandError for SET_CLOCK", err)
+	}
+	if _, err := camera.SyncClock(); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("SyncClock = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(libipcamera.REQUEST_CLOCK) != 0 || emu.Received(libipcamera.SET_CLOCK) != 0 {
+		t.Fatal("placeholder clock commands were sent")
+	}
+}
+
+func TestClockPayload(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	// 2024-03-05 06:07:08 at UTC+02:00.
+	emu.Handle(libipcamera.REQUEST_CLOCK, func(payload []byte) (uint32, []byte) {
+		return libipcamera.CLOCK_INFORMATION, []byte{0xE8, 0x07, 3, 5, 6, 7, 8, 0, 0x78, 0x00}
+	})
+	clock, err := camera.GetClock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2024, 3, 5, 6, 7, 8, 0, time.FixedZone("", 2*60*60))
+	if !clock.Equal(expected) {
+		t.Fatalf("GetClock = %s, expected %s", clock, expected)
+	}
+	if _, offset := clock.Zone(); offset != 2*60*60 {
+		t.Fatalf("timezone offset %ds", offset)
+	}
+
+	payloads := make(chan []byte, 2)
+	emu.Handle(libipcamera.SET_CLOCK, func(payload []byte) (uint32, []byte) {
+		payloads <- payload
+		return libipcamera.CLOCK_SET_ACCEPT, nil
+	})
+	set := time.Date(2023, 12, 31, 23, 59, 58, 0, time.FixedZone("", -3*60*60))
+	if err := camera.SetClock(set); err != nil {
+		t.Fatal(err)
+	}
+	if sent := emu.Received(libipcamera.SET_CLOCK); sent != 1 {
+		t.Fatalf("SET_CLOCK sent %d times", sent)
+	}
+	if payload, wire := <-payloads, []byte{0xE7, 0x07, 12, 31, 23, 59, 58, 0, 0x4C, 0xFF}; !bytes.Equal(payload, wire) {
+		t.Fatalf("SET_CLOCK payload % X, expected % X", payload, wire)
+	}
+}
+
+func TestClockSync(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Clock: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)})
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	result, err := camera.SyncClock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Drift > -time.Hour {
+		t.Fatalf("drift of a reset clock reported as %s", result.Drift)
+	}
+	if result.Residual < -time.Second || result.Residual > time.Second {
+		t.Fatalf("residual drift %s after sync", result.Residual)
+	}
+	if emu.Received(libipcamera.SET_CLOCK) != 1 {
+		t.Fatalf("SET_CLOCK sent %d times", emu.Received(libipcamera.SET_CLOCK))
+	}
+}
+
+func TestClockSyncOnLogin(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Clock: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	// Without experimental commands the sync is skipped and Login succeeds.
+	camera := createClient(t, emu)
+	camera.SetClockSyncOnLogin(true)
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	camera.Disconnect()
+	waitFor(t, func() bool { return emu.Clients() == 0 })
+	if emu.Received(libipcamera.REQUEST_CLOCK) != 0 || emu.Received(libipcamera.SET_CLOCK) != 0 {
+		t.Fatal("clock commands sent without experimental commands")
+	}
+
+	camera = createClient(t, emu)
+	camera.SetClockSyncOnLogin(true)
+	camera.EnableExperimentalCommands(true)
+	defer camera.Disconnect()
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	if emu.Received(libipcamera.SET_CLOCK) != 1 {
+		t.Fatal("clock was not set after login")
+	}
+}
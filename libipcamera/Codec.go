@@ -0,0 +1,119 @@
+package libipcamera
+
+import (
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Codec is the decoded form of a message payload.
+type Codec interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	String() string
+}
+
+// CodecFactory returns a new, empty Codec for a message type.
+type CodecFactory func() Codec
+
+type registeredType struct {
+	name    string
+	factory CodecFactory
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[uint32]registeredType)
+)
+
+// RegisterMessageType registers the name and codec of a message type,
+// replacing a previous registration. A nil factory decodes the payload as RawPayload.
+func RegisterMessageType(messageType uint32, name string, factory CodecFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[messageType] = registeredType{name: name, factory: factory}
+}
+
+// MessageName returns the registered name of messageType or its hexadecimal value.
+func MessageName(messageType uint32) string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if registered, ok := registry[messageType]; ok {
+		return registered.name
+	}
+	return fmt.Sprintf("0x%04X", messageType)
+}
+
+// MessageTypeByName returns the message type registered under name.
+func MessageTypeByName(name string) (uint32, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for messageType, registered := range registry {
+		if registered.name == name {
+			return messageType, true
+		}
+	}
+	return 0, false
+}
+
+// MessageTypes returns every registered message type in ascending order.
+func MessageTypes() []uint32 {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	messageTypes := make([]uint32, 0, len(registry))
+	for messageType := range registry {
+		messageTypes = append(messageTypes, messageType)
+	}
+	sort.Slice(messageTypes, func(i, j int) bool { return messageTypes[i] < messageTypes[j] })
+	return messageTypes
+}
+
+// Decode decodes the payload with the codec registered for its type.
+// Unknown types decode as RawPayload.
+func (m *Message) Decode() (Codec, error) {
+	registryMutex.RLock()
+	registered, ok := registry[m.Header.MessageType]
+	registryMutex.RUnlock()
+
+	var codec Codec = &RawPayload{}
+	if ok && registered.factory != nil {
+		codec = registered.factory()
+	}
+	err := codec.UnmarshalBinary(m.Payload)
+	if err != nil {
+		return nil, &ProtocolError{Header: m.Header, Reason: err.Error()}
+	}
+	return codec, nil
+}
+
+// CreateMessagePacket encodes codec as the payload of a messageType packet.
+func CreateMessagePacket(messageType uint32, codec Codec) ([]byte, error) {
+	payload, err := codec.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return CreatePacket(CreateCommandHeader(messageType), payload), nil
+}
+
+// RawPayload is the codec of message types without a known payload structure.
+type RawPayload struct {
+	Data []byte
+}
+
+func (p *RawPayload) MarshalBinary() ([]byte, error) {
+	return p.Data, nil
+}
+
+func (p *RawPayload) UnmarshalBinary(data []byte) error {
+	p.Data = append([]byte(nil), data...)
+	return nil
+}
+
+func (p *RawPayload) String() string {
+	if len(p.Data) == 0 {
+		return "sem carga útil"
+	}
+	return fmt.Sprintf("%d bytes\n%s", len(p.Data), hex.Dump(p.Data))
+}
@@ -0,0 +1,100 @@
+package libipcamera
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := map[uint32]Codec{
+		LOGIN:                &LoginRequest{Username: "admin", Password: "12345"},
+		REQUEST_FILE_LIST:    &Uint32Payload{Value: 1},
+		FILE_LIST_CONTENT:    &FileListPart{PartHeader: PartHeader{Parts: 3, Part: 1}, Data: []byte("/DCIM/A.JPG:10;")},
+		FIRMWARE_INFORMATION: &FirmwareInformation{Version: "SJ4000AIR V1.0"},
+		CONTROL_RECORDING:    &RecordingControl{Start: true},
+		STATUS_INFORMATION:   &CameraStatus{BatteryPercent: 42, Charging: true, CardPresent: true, CardCapacity: 32 << 30, CardFree: 1 << 30, Recording: true, RecordingDuration: 90 * time.Second},
+		SET_CLOCK:            &ClockTime{Time: time.Date(2024, 2, 29, 23, 59, 58, 0, time.FixedZone("", -3*3600))},
+	}
+
+	for messageType, codec := range codecs {
+		payload, err := codec.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", MessageName(messageType), err)
+		}
+		message := &Message{Header: Header{Magic: 0xABCD, Length: uint16(len(payload)), MessageType: messageType}, Payload: payload}
+		decoded, err := message.Decode()
+		if err != nil {
+			t.Fatalf("%s: %v", MessageName(messageType), err)
+		}
+		encoded, _ := decoded.MarshalBinary()
+		if !bytes.Equal(encoded, payload) {
+			t.Fatalf("%s: round trip changed payload %x to %x", MessageName(messageType), payload, encoded)
+		}
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	part, _ := (&FileListPart{PartHeader: PartHeader{Parts: 5, Part: 1}, Data: make([]byte, 1024)}).MarshalBinary()
+	message := &Message{Header: Header{Magic: 0xABCD, MessageType: FILE_LIST_CONTENT}, Payload: part}
+	if s := message.String(); s != "FILE_LIST_CONTENT part 2/5 (1024 bytes)" {
+		t.Fatalf("unexpected description %q", s)
+	}
+
+	login, _ := (&LoginRequest{Username: "admin", Password: "12345"}).MarshalBinary()
+	message = &Message{Header: Header{Magic: 0xABCD, MessageType: LOGIN}, Payload: login}
+	if s := message.String(); strings.Contains(s, "12345") {
+		t.Fatalf("password not redacted in %q", s)
+	}
+
+	message = &Message{Header: Header{Magic: 0xABCD, MessageType: 0xBEEF}, Payload: []byte{0x01, 0x02}}
+	if s := message.String(); !strings.Contains(s, "0xBEEF") || !strings.Contains(s, "01 02") {
+		t.Fatalf("unknown message not dumped: %q", s)
+	}
+}
+
+func TestDecodeShortPayload(t *testing.T) {
+	message := &Message{Header: Header{Magic: 0xABCD, MessageType: FILE_LIST_CONTENT}, Payload: []byte{0x01}}
+	if _, err := message.Decode(); !errors.Is(err, ErrProtocol) {
+		t.Fatalf("expected ErrProtocol, got %v", err)
+	}
+}
+
+type batteryLevel struct {
+	Percent byte
+}
+
+func (p *batteryLevel) MarshalBinary() ([]byte, error) {
+	return []byte{p.Percent}, nil
+}
+
+func (p *batteryLevel) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errShortPayload
+	}
+	p.Percent = data[0]
+	return nil
+}
+
+func (p *batteryLevel) String() string {
+	return "battery"
+}
+
+func TestRegisterMessageType(t *testing.T) {
+	const batteryType = 0xF0F0
+	RegisterMessageType(batteryType, "TEST_BATTERY", func() Codec { return &batteryLevel{} })
+
+	if messageType, ok := MessageTypeByName("TEST_BATTERY"); !ok || messageType != batteryType {
+		t.Fatalf("lookup by name returned 0x%X, %v", messageType, ok)
+	}
+	message := &Message{Header: Header{Magic: 0xABCD, MessageType: batteryType}, Payload: []byte{42}}
+	codec, err := message.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level, ok := codec.(*batteryLevel); !ok || level.Percent != 42 {
+		t.Fatalf("unexpected decoded payload %#v", codec)
+	}
+}
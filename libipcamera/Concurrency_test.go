@@ -0,0 +1,130 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestConcurrentCommands(t *testing.T) {
+	camera := connectClient(t, startEmulator(t, emulator.Config{Files: []emulator.File{
+		{Path: "/DCIM/A.MP4", Size: 100}, {Path: "/DCIM/B.MP4", Size: 200}, {Path: "/DCIM/C.JPG", Size: 300},
+	}}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 20; i++ {
+		wg.Add(5)
+		go func() {
+			defer wg.Done()
+			firmware, err := camera.GetFirmwareInfo()
+			if err == nil && firmware != defaultFirmware {
+				err = errors.New("unexpected firmware " + firmware)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- camera.TakePicture()
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- camera.StartRecording()
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- camera.StopRecording()
+		}()
+		go func() {
+			defer wg.Done()
+			files, err := camera.GetFileList()
+			// The pictures taken meanwhile follow the stored files.
+			if err == nil && (len(files) < 3 || files[2].Path != "/DCIM/C.JPG") {
+				err = errors.New("unexpected file list")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestConcurrentHandlerRegistration(t *testing.T) {
+	camera := connectClient(t, startEmulator(t, emulator.Config{}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			camera.Handle(libipcamera.ALIVE_REQUEST, func(*libipcamera.Camera, *libipcamera.Message) (bool, error) {
+				return libipcamera.RemoveHandler, nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			camera.HandleFirst(libipcamera.PICTURE_SAVED, func(*libipcamera.Camera, *libipcamera.Message) (bool, error) {
+				return libipcamera.KeepHandler, nil
+			})
+			camera.IsConnected()
+			camera.IsLoggedIn()
+			camera.SetVerbose(false)
+		}()
+	}
+	wg.Wait()
+
+	if err := camera.TakePicture(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestCancelRemovesPending(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.TAKE_PICTURE)
+	camera := connectClient(t, emu)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := camera.TakePictureContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	if pending := libipcamera.PendingCount(camera); pending != 0 {
+		t.Fatalf("%d requests still pending after cancellation", pending)
+	}
+}
+
+func TestDisconnectFailsPending(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.REQUEST_FIRMWARE_INFO)
+	camera := connectClient(t, emu)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := camera.GetFirmwareInfo()
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	camera.Disconnect()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, libipcamera.ErrDisconnected) {
+			t.Fatalf("expected ErrDisconnected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not failed on disconnect")
+	}
+}
@@ -0,0 +1,157 @@
+package libipcamera
+
+import (
+	"context"
+	"time"
+)
+
+// Camera implements the role interfaces below. Code driving a camera should
+// accept the smallest role it needs, so it can be tested against a mock that
+// only implements that role; Controller combines all of them.
+
+// SessionController covers the connection, login and link supervision.
+type SessionController interface {
+	Connect() error
+	ConnectContext(ctx context.Context) error
+	Login() error
+	LoginContext(ctx context.Context) error
+	Relogin(ctx context.Context) error
+	Disconnect()
+	IsConnected() bool
+	IsLoggedIn() bool
+
+	State() ConnectionState
+	Subscribe() (<-chan StateEvent, func())
+	EnableReconnect(policy ReconnectPolicy)
+	DisableReconnect()
+	EnableHealthMonitor(policy HealthPolicy)
+	DisableHealthMonitor()
+	Health() HealthStats
+
+	SetVerbose(verbose bool)
+	EnableExperimentalCommands(enabled bool)
+	SetLogger(logger Logger)
+	SetTracer(tracer Tracer)
+}
+
+// CaptureController takes pictures, records and streams the preview.
+type CaptureController interface {
+	TakePicture() error
+	TakePictureContext(ctx context.Context) error
+	StartRecording() error
+	StartRecordingContext(ctx context.Context) error
+	StopRecording() error
+	StopRecordingContext(ctx context.Context) error
+	StartPreviewStream() error
+}
+
+// FileController manages the files on the SD card.
+type FileController interface {
+	GetFileList() ([]StoredFile, error)
+	GetFileListContext(ctx context.Context) ([]StoredFile, error)
+	DeleteFile(path string) error
+	DeleteFileContext(ctx context.Context, path string) error
+	DeleteFiles(paths []string) ([]string, error)
+	DeleteFilesContext(ctx context.Context, paths []string) ([]string, error)
+	FormatCard(confirmation string) error
+	FormatCardContext(ctx context.Context, confirmation string) error
+}
+
+// SettingsController reads and changes the camera settings.
+type SettingsController interface {
+	SettingsTable() *SettingsTable
+	SetSettingsTable(table *SettingsTable)
+	GetSetting(name string) (SettingValue, error)
+	GetSettingContext(ctx context.Context, name string) (SettingValue, error)
+	SetSetting(name, value string) error
+	SetSettingContext(ctx context.Context, name, value string) error
+	VideoResolution() (VideoResolution, error)
+	SetVideoResolution(resolution VideoResolution) error
+	FrameRate() (FrameRate, error)
+	SetFrameRate(frameRate FrameRate) error
+	Exposure() (Exposure, error)
+	SetExposure(exposure Exposure) error
+	WhiteBalance() (WhiteBalance, error)
+	SetWhiteBalance(whiteBalance WhiteBalance) error
+	LoopRecording() (LoopRecording, error)
+	SetLoopRecording(loop LoopRecording) error
+	AudioEnabled() (bool, error)
+	SetAudioEnabled(enabled bool) error
+	DateStampEnabled() (bool, error)
+	SetDateStampEnabled(enabled bool) error
+}
+
+// FirmwareController identifies the firmware and model of the camera.
+type FirmwareController interface {
+	GetFirmwareInfo() (string, error)
+	GetFirmwareInfoContext(ctx context.Context) (string, error)
+	GetFirmware() (FirmwareVersion, error)
+	GetFirmwareContext(ctx context.Context) (FirmwareVersion, error)
+	SetModelProfile(profile *ModelProfile)
+	ModelProfile() (ModelProfile, bool)
+	UpgradeFirmware(ctx context.Context, image []byte, config UpgradeConfig) error
+}
+
+// ClockController reads and sets the camera clock.
+type ClockController interface {
+	GetClock() (time.Time, error)
+	GetClockContext(ctx context.Context) (time.Time, error)
+	SetClock(t time.Time) error
+	SetClockContext(ctx context.Context, t time.Time) error
+	SyncClock() (ClockSync, error)
+	SyncClockContext(ctx context.Context) (ClockSync, error)
+	SetClockSyncOnLogin(enabled bool)
+}
+
+// StatusController queries and polls the battery, card and recording status.
+type StatusController interface {
+	GetStatus() (CameraStatus, error)
+	GetStatusContext(ctx context.Context) (CameraStatus, error)
+	StartStatusPoller(interval time.Duration)
+	StopStatusPoller()
+	LastStatus() (CameraStatus, bool)
+	SubscribeStatus() (<-chan StatusEvent, func())
+}
+
+// EventController delivers the notifications sent by the camera itself.
+type EventController interface {
+	SubscribeEvents() (<-chan CameraEvent, func())
+}
+
+// WifiController reads and changes the access point of the camera.
+type WifiController interface {
+	GetWifi() (WifiConfig, error)
+	GetWifiContext(ctx context.Context) (WifiConfig, error)
+	SetWifi(config WifiConfig) error
+	SetWifiContext(ctx context.Context, config WifiConfig) error
+	SetCredentials(username, password string) error
+	SetCredentialsContext(ctx context.Context, username, password string) error
+	VerifyWifi(ctx context.Context, expected WifiConfig) error
+}
+
+// ProtocolController exchanges raw messages with the camera.
+type ProtocolController interface {
+	Request(ctx context.Context, command uint32, payload []byte, replyType uint32) (*Message, error)
+	RequestMultipart(ctx context.Context, command uint32, payload []byte, replyType uint32) ([]*Message, error)
+	SendPacket(packet []byte) error
+	Handle(messageType uint32, handleFunc MessageHandler)
+	HandleFirst(messageType uint32, handleFunc MessageHandler)
+	Observe(observer func(message *Message)) func()
+	Probe(ctx context.Context, config ProbeConfig) ([]ProbeResult, error)
+}
+
+// Controller covers every public operation of Camera.
+type Controller interface {
+	SessionController
+	CaptureController
+	FileController
+	SettingsController
+	FirmwareController
+	ClockController
+	StatusController
+	EventController
+	WifiController
+	ProtocolController
+}
+
+var _ Controller = (*Camera)(nil)
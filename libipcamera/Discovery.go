@@ -1,56 +1,351 @@
 package libipcamera
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net"
+	"sort"
 	"time"
 )
 
+// AutodiscoverCamera returns the address of the first camera answering the
+// discovery on any network interface.
+func AutodiscoverCamera(verbose bool) (net.IP, error) {
+	minLevel := LevelInfo
+	if verbose {
+		minLevel = LevelDebug
+	}
+	return AutodiscoverCameraWithLogger(NewStdLogger(nil, minLevel))
+}
 
-var targetPorts = []int{22600, 21600}
+// AutodiscoverCameraWithLogger is AutodiscoverCamera writing its progress to logger.
+func AutodiscoverCameraWithLogger(logger Logger) (net.IP, error) {
+	return AutodiscoverCameraWithConfig(context.Background(), DiscoveryConfig{Logger: logger})
+}
 
-func AutodiscoverCamera(verbose bool) (net.IP, error) {
-	conn, err := net.ListenPacket("udp", ":22601")
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+// AutodiscoverCameraWithConfig runs Discover with config until the first
+// camera answers and returns its address, or ErrNoCameraFound. The window is
+// five seconds unless config sets one.
+func AutodiscoverCameraWithConfig(ctx context.Context, config DiscoveryConfig) (net.IP, error) {
+	config.Limit = 1
+	if config.Window <= 0 {
+		config.Window = 5 * time.Second
+	}
+	cameras, err := Discover(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(cameras) == 0 {
+		return nil, ErrNoCameraFound
+	}
+	return cameras[0].Address, nil
+}
+
+// Defaults of DiscoveryConfig.
+const (
+	// DefaultDiscoveryListenPort is the local UDP port responses are read on.
+	DefaultDiscoveryListenPort = 22601
+	// DefaultDiscoveryWindow is how long responses are collected.
+	DefaultDiscoveryWindow = 3 * time.Second
+	// DefaultDiscoveryRetries is how many times DISCOVERY_REQUEST is sent to each target.
+	DefaultDiscoveryRetries = 5
+	// DefaultDiscoveryInterval is the pause between the rounds of requests.
+	DefaultDiscoveryInterval = 500 * time.Millisecond
+)
+
+// DiscoveredCamera is a camera that answered DiscoverAll. The layout of
+// DISCOVERY_RESPONSE is unknown, so the name, MAC address, model and ports of
+// the camera are not extracted from it; only the address the response came
+// from and the ports that answered are known.
+type DiscoveredCamera struct {
+	Address net.IP
+	// Payload is the DISCOVERY_RESPONSE payload of the first response, as
+	// long as the header announces, kept as received.
+	Payload []byte
+	// DiscoveryPorts are the discovery ports the camera answered on.
+	DiscoveryPorts []int
+}
+
+type discoveredCameraJSON struct {
+	Address        string `json:"address"`
+	Payload        string `json:"payload"`
+	DiscoveryPorts []int  `json:"discovery_ports"`
+}
+
+// MarshalJSON encodes the address as a string and the payload in hexadecimal.
+func (d DiscoveredCamera) MarshalJSON() ([]byte, error) {
+	return json.Marshal(discoveredCameraJSON{
+		Address:        d.Address.String(),
+		Payload:        hex.EncodeToString(d.Payload),
+		DiscoveryPorts: d.DiscoveryPorts,
+	})
+}
 
+// DiscoveryConfig configures Discover. DISCOVERY_REQUEST is sent to the
+// directed broadcast address of the Interfaces and Subnets and to the
+// Addresses, on each of the Ports, and to the Targets. When none of them is
+// set, it is sent to the directed broadcast address of every network
+// interface that is up and to 255.255.255.255.
+type DiscoveryConfig struct {
+	// Interfaces are names of network interfaces, e.g. the Wi-Fi adapter
+	// connected to the camera's access point. With a single interface the
+	// responses are also read on its address only.
+	Interfaces []string
+	// Subnets are IPv4 networks, e.g. 192.168.1.0/24.
+	Subnets []*net.IPNet
+	// Addresses are probed with unicast requests, e.g. 192.168.100.1 when
+	// broadcasts do not reach the camera.
+	Addresses []net.IP
+	// Targets are sent DISCOVERY_REQUEST as they are.
+	Targets []*net.UDPAddr
+	// Ports are the discovery ports of the cameras, DiscoveryPorts by default.
+	Ports []int
+	// ListenPort is the local UDP port responses are read on,
+	// DefaultDiscoveryListenPort by default.
+	ListenPort int
+	// EphemeralPort reads the responses on a port chosen by the system
+	// instead, for when ListenPort is taken by another program.
+	EphemeralPort bool
+	// Retries is how many times the requests are sent, DefaultDiscoveryRetries
+	// by default, every Interval, DefaultDiscoveryInterval by default.
+	Retries  int
+	Interval time.Duration
+	// Window is how long responses are collected, DefaultDiscoveryWindow by default.
+	Window time.Duration
+	// Limit stops the discovery once as many cameras answered, zero waits
+	// for the whole window.
+	Limit int
+	// Logger receives the progress, nothing is logged by default.
+	Logger Logger
+}
+
+// DiscoverAll broadcasts DISCOVERY_REQUEST on every network interface and
+// discovery port and returns every camera that answered within
+// DefaultDiscoveryWindow, or until ctx is done.
+func DiscoverAll(ctx context.Context) ([]DiscoveredCamera, error) {
+	return Discover(ctx, DiscoveryConfig{})
+}
+
+// Discover sends DISCOVERY_REQUEST to the targets of config and collects the
+// responses until the window ends, Limit cameras answered or ctx is done. A
+// camera answering on several ports or several times is returned once, as
+// identified by its address. Cameras are ordered by address.
+func Discover(ctx context.Context, config DiscoveryConfig) ([]DiscoveredCamera, error) {
+	if config.Ports == nil {
+		config.Ports = DiscoveryPorts()
+	}
+	if config.ListenPort <= 0 {
+		config.ListenPort = DefaultDiscoveryListenPort
+	}
+	if config.EphemeralPort {
+		config.ListenPort = 0
+	}
+	if config.Retries <= 0 {
+		config.Retries = DefaultDiscoveryRetries
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultDiscoveryInterval
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultDiscoveryWindow
+	}
+	if config.Logger == nil {
+		config.Logger = NopLogger()
+	}
+	logger := NewRedactingLogger(config.Logger)
+
+	targets, localIP, err := config.targets()
 	if err != nil {
 		return nil, err
 	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("Nenhum destino para a descoberta")
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: localIP, Port: config.ListenPort})
+	if err != nil {
+		if config.ListenPort != 0 {
+			return nil, fmt.Errorf("Não foi possível escutar a descoberta na porta UDP %d, use uma porta efêmera se ela estiver em uso: %w", config.ListenPort, err)
+		}
+		return nil, fmt.Errorf("Não foi possível escutar a descoberta: %w", err)
+	}
 	defer conn.Close()
+	logger.Log(LevelDebug, "Descoberta iniciada", F("local", conn.LocalAddr()), F("targets", len(targets)))
+
+	ctx, cancel := context.WithTimeout(ctx, config.Window)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	go func() {
+		// Unblocks ReadFrom when ctx is cancelled before the deadline.
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+	go sendDiscoveryRequests(ctx, conn, targets, config.Retries, config.Interval, logger)
 
-	for _, port := range targetPorts {
-		go sendDiscoveryBroadcasts(conn, port, 5, verbose)
+	found := make(map[string]*DiscoveredCamera)
+	buffer := make([]byte, 512)
+	for config.Limit <= 0 || len(found) < config.Limit {
+		n, remoteAddr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		header := Header{}
+		if n < 8 || binary.Read(bytes.NewReader(buffer[:8]), binary.BigEndian, &header) != nil ||
+			header.Magic != 0xABCD || header.MessageType != DISCOVERY_RESPONSE {
+			continue
+		}
+
+		udpAddr := remoteAddr.(*net.UDPAddr)
+		known, ok := found[udpAddr.IP.String()]
+		if !ok {
+			known = &DiscoveredCamera{Address: udpAddr.IP}
+			if end := 8 + int(header.Length); end <= n {
+				known.Payload = append([]byte(nil), buffer[8:end]...)
+			} else {
+				logger.Log(LevelDebug, "Resposta de descoberta truncada", F("address", udpAddr.IP), F("size", n), F("length", header.Length))
+			}
+			logger.Log(LevelDebug, "Câmera respondeu à descoberta", F("address", udpAddr.IP), F("size", len(known.Payload)))
+			found[udpAddr.IP.String()] = known
+		}
+		if !containsPort(known.DiscoveryPorts, udpAddr.Port) {
+			known.DiscoveryPorts = append(known.DiscoveryPorts, udpAddr.Port)
+		}
 	}
 
-	buffer := make([]byte, 80)
-	_, remoteAddr, err := conn.ReadFrom(buffer)
+	cameras := make([]DiscoveredCamera, 0, len(found))
+	for _, camera := range found {
+		sort.Ints(camera.DiscoveryPorts)
+		cameras = append(cameras, *camera)
+	}
+	sort.Slice(cameras, func(i, j int) bool {
+		return bytes.Compare(cameras[i].Address.To16(), cameras[j].Address.To16()) < 0
+	})
+	return cameras, nil
+}
 
-	if err != nil {
-		return nil, err
+// targets returns the addresses DISCOVERY_REQUEST is sent to and the local
+// address responses are read on, nil for every address.
+func (config *DiscoveryConfig) targets() ([]*net.UDPAddr, net.IP, error) {
+	var broadcasts []net.IP
+	var localIP net.IP
+	for _, name := range config.Interfaces {
+		networks, err := interfaceNetworks(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Interface de descoberta %s inválida: %w", name, err)
+		}
+		if len(config.Interfaces) == 1 {
+			localIP = networks[0].IP.To4()
+		}
+		for _, network := range networks {
+			broadcasts = append(broadcasts, directedBroadcast(network))
+		}
+	}
+	for _, subnet := range config.Subnets {
+		if subnet.IP.To4() == nil {
+			return nil, nil, fmt.Errorf("A sub-rede %s não é IPv4", subnet)
+		}
+		broadcasts = append(broadcasts, directedBroadcast(subnet))
+	}
+	if len(config.Interfaces) == 0 && len(config.Subnets) == 0 && len(config.Addresses) == 0 && len(config.Targets) == 0 {
+		networks, err := broadcastNetworks()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, network := range networks {
+			broadcasts = append(broadcasts, directedBroadcast(network))
+		}
+		broadcasts = append(broadcasts, net.IPv4bcast)
 	}
 
-	udpAddr := remoteAddr.(*net.UDPAddr)
-	return udpAddr.IP, nil
+	var targets []*net.UDPAddr
+	seen := make(map[string]bool)
+	add := func(target *net.UDPAddr) {
+		if !seen[target.String()] {
+			seen[target.String()] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, ip := range append(broadcasts, config.Addresses...) {
+		for _, port := range config.Ports {
+			add(&net.UDPAddr{IP: ip, Port: port})
+		}
+	}
+	for _, target := range config.Targets {
+		add(target)
+	}
+	return targets, localIP, nil
 }
 
-func sendDiscoveryBroadcasts(localConn net.PacketConn, port, count int, verbose bool) {
-	broadcastAddress, err := net.ResolveUDPAddr("udp", fmt.Sprintf("255.255.255.255:%d", port))
+// broadcastNetworks returns the IPv4 networks of every network interface that
+// is up and supports broadcasts, except loopback.
+func broadcastNetworks() ([]*net.IPNet, error) {
+	interfaces, err := net.Interfaces()
 	if err != nil {
-		return
+		return nil, err
 	}
+	var networks []*net.IPNet
+	for i := range interfaces {
+		iface := &interfaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceNetworks, err := ipv4Networks(iface)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, ifaceNetworks...)
+	}
+	return networks, nil
+}
 
-	broadcastPacket := CreateCommandPacket(0x0114)
-
-	if verbose {
-		log.Printf("Trying Autodiscovery using UDP Port %d\n", port)
+// directedBroadcast returns the broadcast address of the IPv4 network, e.g.
+// 192.168.1.255 for 192.168.1.0/24.
+func directedBroadcast(network *net.IPNet) net.IP {
+	ip := network.IP.To4()
+	mask := network.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = ip[i] | ^mask[i]
 	}
-	for i := 0; i < count; i++ {
-		_, err = localConn.WriteTo(broadcastPacket, broadcastAddress)
+	return broadcast
+}
 
-		if err != nil {
+// sendDiscoveryRequests sends DISCOVERY_REQUEST to every target retries times,
+// interval apart, or until ctx is done.
+func sendDiscoveryRequests(ctx context.Context, conn net.PacketConn, targets []*net.UDPAddr, retries int, interval time.Duration, logger Logger) {
+	packet := CreateCommandPacket(DISCOVERY_REQUEST)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; i < retries; i++ {
+		for _, target := range targets {
+			if _, err := conn.WriteTo(packet, target); err != nil && ctx.Err() == nil {
+				logger.Log(LevelWarn, "ERRO ao enviar a requisição de descoberta", F("target", target), F("error", err))
+			}
+		}
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
 		}
-		time.Sleep(time.Millisecond * 500)
 	}
+	return false
 }
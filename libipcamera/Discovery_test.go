@@ -0,0 +1,146 @@
+package libipcamera
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDirectedBroadcast(t *testing.T) {
+	cases := map[string]string{
+		"192.168.1.23/24":  "192.168.1.255",
+		"192.168.100.2/24": "192.168.100.255",
+		"10.1.2.3/8":       "10.255.255.255",
+		"172.16.5.1/20":    "172.16.15.255",
+		"192.168.1.9/32":   "192.168.1.9",
+	}
+	for cidr, expected := range cases {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.IP = ip
+		if broadcast := directedBroadcast(network); broadcast.String() != expected {
+			t.Fatalf("directedBroadcast(%s) = %s, expected %s", cidr, broadcast, expected)
+		}
+	}
+
+	// Interface addresses may carry 16 byte masks.
+	network := &net.IPNet{IP: net.ParseIP("192.168.42.1"), Mask: net.CIDRMask(120, 128)}
+	if broadcast := directedBroadcast(network); broadcast.String() != "192.168.42.255" {
+		t.Fatalf("directedBroadcast with 16 byte mask = %s", broadcast)
+	}
+}
+
+func TestDiscoveryTargets(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	config := DiscoveryConfig{
+		Subnets:   []*net.IPNet{subnet},
+		Addresses: []net.IP{net.ParseIP("192.168.100.1"), net.ParseIP("192.168.1.255")},
+		Targets:   []*net.UDPAddr{{IP: net.ParseIP("10.0.0.5"), Port: 9999}},
+		Ports:     []int{22600, 21600},
+	}
+	targets, localIP, err := config.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if localIP != nil {
+		t.Fatalf("expected every local address, got %s", localIP)
+	}
+	expected := []string{"192.168.1.255:22600", "192.168.1.255:21600", "192.168.100.1:22600", "192.168.100.1:21600", "10.0.0.5:9999"}
+	if len(targets) != len(expected) {
+		t.Fatalf("targets = %v, expected %v", targets, expected)
+	}
+	for i, target := range targets {
+		if target.String() != expected[i] {
+			t.Fatalf("targets = %v, expected %v", targets, expected)
+		}
+	}
+
+	_, subnet6, _ := net.ParseCIDR("fe80::/64")
+	if _, _, err := (&DiscoveryConfig{Subnets: []*net.IPNet{subnet6}}).targets(); err == nil {
+		t.Fatal("IPv6 subnet accepted")
+	}
+	if _, _, err := (&DiscoveryConfig{Interfaces: []string{"no-such-interface0"}}).targets(); err == nil {
+		t.Fatal("unknown interface accepted")
+	}
+}
+
+func TestDiscoveryDefaultTargets(t *testing.T) {
+	targets, _, err := (&DiscoveryConfig{Ports: []int{22600}}).targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		if target.IP.Equal(net.IPv4bcast) && target.Port == 22600 {
+			return
+		}
+	}
+	t.Fatalf("limited broadcast missing in %v", targets)
+}
+
+// startDiscoveryReplay answers every DISCOVERY_REQUEST on a UDP port of ip
+// with response, a whole packet, and returns the address of the port. It
+// skips the test when ip is not a local address.
+func startDiscoveryReplay(t *testing.T, ip string, response []byte) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", ip+":0")
+	if err != nil {
+		t.Skipf("listen on %s: %s", ip, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, remote, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if n >= 8 && binary.BigEndian.Uint32(buffer[4:8]) == DISCOVERY_REQUEST {
+				conn.WriteTo(response, remote)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestDiscover(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x5A}, 80)
+	response := CreatePacket(CreateCommandHeader(DISCOVERY_RESPONSE), payload)
+	truncated := CreatePacket(CreateCommandHeader(DISCOVERY_RESPONSE), payload)[:20]
+
+	// One camera answering on two ports, with bytes past the announced
+	// length, and a reply of another type from the same address.
+	first := startDiscoveryReplay(t, "127.0.0.1", append(append([]byte(nil), response...), "trailer"...))
+	second := startDiscoveryReplay(t, "127.0.0.1", response)
+	other := startDiscoveryReplay(t, "127.0.0.1", CreateCommandPacket(ALIVE_REQUEST))
+	// Another camera sending less than the header announces.
+	short := startDiscoveryReplay(t, "127.0.0.2", truncated)
+
+	cameras, err := Discover(context.Background(), DiscoveryConfig{
+		Targets:       []*net.UDPAddr{short, first, second, other},
+		EphemeralPort: true,
+		Interval:      20 * time.Millisecond,
+		Window:        300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cameras) != 2 || !cameras[0].Address.Equal(first.IP) || !cameras[1].Address.Equal(short.IP) {
+		t.Fatalf("expected 2 cameras ordered by address, got %+v", cameras)
+	}
+	ports := []int{first.Port, second.Port}
+	if ports[0] > ports[1] {
+		ports[0], ports[1] = ports[1], ports[0]
+	}
+	camera := cameras[0]
+	if !bytes.Equal(camera.Payload, payload) || len(camera.DiscoveryPorts) != 2 || camera.DiscoveryPorts[0] != ports[0] || camera.DiscoveryPorts[1] != ports[1] {
+		t.Fatalf("unexpected camera %+v, expected ports %v", camera, ports)
+	}
+	if camera := cameras[1]; camera.Payload != nil || len(camera.DiscoveryPorts) != 1 {
+		t.Fatalf("unexpected truncated camera %+v", camera)
+	}
+}
@@ -0,0 +1,260 @@
+package libipcamera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNotLoggedIn is returned by operations that require a successful Login.
+	ErrNotLoggedIn = errors.New("É necessário fazer login na câmera")
+
+	// ErrCameraBusy is returned by Login when another client is already connected to the camera.
+	ErrCameraBusy = errors.New("Já existe um cliente conectado à câmera")
+
+	// ErrDisconnected is returned when the control connection is not open or was closed
+	// while waiting for a reply.
+	ErrDisconnected = errors.New("A conexão com a câmera foi encerrada")
+
+	// ErrLinkDead is returned to pending requests when the health monitor
+	// declares the link dead after missed keepalives.
+	ErrLinkDead = errors.New("A câmera parou de enviar keepalives")
+
+	// ErrUnsupported matches every UnsupportedError.
+	ErrUnsupported = errors.New("Comando não suportado por este modelo")
+
+	// ErrUnverifiedCommand matches every UnverifiedCommandError.
+	ErrUnverifiedCommand = errors.New("Comando não verificado em uma câmera real")
+
+	// ErrTimeout matches every TimeoutError.
+	ErrTimeout = errors.New("A solicitação expirou")
+
+	// ErrProtocol matches every ProtocolError.
+	ErrProtocol = errors.New("Erro de protocolo")
+
+	// ErrInvalidSetting matches every SettingError.
+	ErrInvalidSetting = errors.New("Configuração inválida")
+
+	// ErrFileNotFound is returned when a file to delete is not on the SD card.
+	ErrFileNotFound = errors.New("Arquivo não encontrado no cartão SD")
+
+	// ErrNotConfirmed is returned by destructive operations called without
+	// their confirmation token.
+	ErrNotConfirmed = errors.New("A operação não foi confirmada")
+
+	// ErrInvalidFirmwareImage matches every FirmwareImageError.
+	ErrInvalidFirmwareImage = errors.New("Imagem de firmware inválida")
+
+	// ErrLowBattery matches every BatteryError.
+	ErrLowBattery = errors.New("Bateria insuficiente")
+
+	// ErrNoCameraFound is returned by AutodiscoverCamera when no camera answers.
+	ErrNoCameraFound = errors.New("Nenhuma câmera respondeu à descoberta")
+)
+
+// TimeoutError is returned when the camera does not reply to Command before the deadline.
+type TimeoutError struct {
+	Command   uint32
+	ReplyType uint32
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("A solicitação 0x%04X expirou aguardando 0x%04X", e.Command, e.ReplyType)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// UnsupportedError is returned without contacting the camera when the model
+// profile of the camera does not list Command, or with an empty Model for
+// operations no camera is known to support. Reason explains the operations
+// that have no command ID.
+type UnsupportedError struct {
+	Model   string
+	Command uint32
+	Reason  string
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("Operação não suportada: %s", e.Reason)
+	}
+	if e.Model == "" {
+		return fmt.Sprintf("O comando %s não é suportado: ele não foi verificado em uma câmera real", MessageName(e.Command))
+	}
+	return fmt.Sprintf("O modelo %s não suporta o comando %s", e.Model, MessageName(e.Command))
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// UnverifiedCommandError is returned without contacting the camera for
+// commands whose ID and payload are placeholders, unless experimental
+// commands are enabled.
+type UnverifiedCommandError struct {
+	Command uint32
+}
+
+func (e *UnverifiedCommandError) Error() string {
+	return fmt.Sprintf("O comando %s não foi verificado em uma câmera real; habilite os comandos experimentais para enviá-lo", MessageName(e.Command))
+}
+
+func (e *UnverifiedCommandError) Is(target error) bool {
+	return target == ErrUnverifiedCommand
+}
+
+// ProtocolError is returned when the camera sends a message that violates the protocol.
+type ProtocolError struct {
+	Header Header
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("Erro de protocolo (%s): %s", e.Header.String(), e.Reason)
+}
+
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocol
+}
+
+// RequestError is returned by Request and RequestMultipart when a command
+// fails for another reason than a timeout, such as a write error, a
+// disconnect or a cancelled context.
+type RequestError struct {
+	Command   uint32
+	ReplyType uint32
+	Err       error
+}
+
+func (e *RequestError) Error() string {
+	if errors.Is(e.Err, context.Canceled) {
+		return fmt.Sprintf("A solicitação 0x%04X foi cancelada", e.Command)
+	}
+	return fmt.Sprintf("A solicitação 0x%04X falhou: %s", e.Command, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// SettingError is returned for settings missing from the settings table, for
+// values outside the enumerated values of a setting and when the camera
+// applies another value than the one requested.
+type SettingError struct {
+	Setting  string
+	Value    string
+	Allowed  []string
+	Rejected bool
+}
+
+func (e *SettingError) Error() string {
+	switch {
+	case e.Rejected:
+		return fmt.Sprintf("A câmera recusou o valor %s para %s", e.Value, e.Setting)
+	case e.Value != "":
+		return fmt.Sprintf("Valor %s inválido para %s, permitidos: %s", e.Value, e.Setting, strings.Join(e.Allowed, ", "))
+	}
+	return fmt.Sprintf("Configuração desconhecida: %s", e.Setting)
+}
+
+func (e *SettingError) Is(target error) bool {
+	return target == ErrInvalidSetting
+}
+
+// Result codes answered by commands that report success or failure.
+const (
+	ResultOK       = 0
+	ResultNotFound = 1
+	ResultDenied   = 2
+)
+
+// ResultError is returned when the camera answers Command with a result code
+// other than ResultOK. ResultNotFound matches ErrFileNotFound.
+type ResultError struct {
+	Command uint32
+	Code    uint32
+}
+
+func (e *ResultError) Error() string {
+	switch e.Code {
+	case ResultNotFound:
+		return fmt.Sprintf("A câmera respondeu %s: arquivo não encontrado", MessageName(e.Command))
+	case ResultDenied:
+		return fmt.Sprintf("A câmera recusou %s", MessageName(e.Command))
+	}
+	return fmt.Sprintf("A câmera respondeu %s com o código %d", MessageName(e.Command), e.Code)
+}
+
+func (e *ResultError) Is(target error) bool {
+	return target == ErrFileNotFound && e.Code == ResultNotFound
+}
+
+// DeleteError is returned by DeleteFiles when some of the files could not be deleted.
+type DeleteError struct {
+	Failed map[string]error
+}
+
+func (e *DeleteError) Error() string {
+	paths := make([]string, 0, len(e.Failed))
+	for path := range e.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	messages := make([]string, len(paths))
+	for i, path := range paths {
+		messages[i] = fmt.Sprintf("%s: %s", path, e.Failed[path])
+	}
+	return fmt.Sprintf("Não foi possível apagar %d arquivo(s): %s", len(paths), strings.Join(messages, "; "))
+}
+
+// GroupError is returned by group actions when some of the cameras failed.
+type GroupError struct {
+	Failed map[string]error
+}
+
+func (e *GroupError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = fmt.Sprintf("%s: %s", name, e.Failed[name])
+	}
+	return fmt.Sprintf("Falha em %d câmera(s): %s", len(names), strings.Join(messages, "; "))
+}
+
+// FirmwareImageError is returned by UpgradeFirmware and CheckFirmwareImage
+// for images that are empty, too large or built for another model.
+type FirmwareImageError struct {
+	Reason string
+}
+
+func (e *FirmwareImageError) Error() string {
+	return fmt.Sprintf("Imagem de firmware inválida: %s", e.Reason)
+}
+
+func (e *FirmwareImageError) Is(target error) bool {
+	return target == ErrInvalidFirmwareImage
+}
+
+// BatteryError is returned by UpgradeFirmware when the battery is below
+// Required percent and the camera is not charging.
+type BatteryError struct {
+	Percent  int
+	Required int
+}
+
+func (e *BatteryError) Error() string {
+	return fmt.Sprintf("Bateria em %d%%, são necessários %d%% ou a câmera carregando", e.Percent, e.Required)
+}
+
+func (e *BatteryError) Is(target error) bool {
+	return target == ErrLowBattery
+}
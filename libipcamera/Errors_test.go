@@ -0,0 +1,56 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestTimeoutError(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	dropReplies(emu, libipcamera.CONTROL_RECORDING)
+	camera := connectClient(t, emu)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := camera.StartRecordingContext(ctx)
+
+	var timeout *libipcamera.TimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, libipcamera.ErrTimeout) {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if timeout.Command != libipcamera.CONTROL_RECORDING || timeout.ReplyType != libipcamera.RECORD_COMMAND_ACCEPT {
+		t.Fatalf("unexpected command in %v", timeout)
+	}
+}
+
+func TestCameraBusyError(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	emu.SetFaults(emulator.Faults{RejectLogin: true})
+	camera, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	camera.SetVerbose(false)
+	defer camera.Disconnect()
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := camera.Login(); !errors.Is(err, libipcamera.ErrCameraBusy) {
+		t.Fatalf("expected ErrCameraBusy, got %v", err)
+	}
+	if err := camera.TakePicture(); !errors.Is(err, libipcamera.ErrNotLoggedIn) {
+		t.Fatalf("expected ErrNotLoggedIn, got %v", err)
+	}
+}
+
+func TestSendWithoutConnection(t *testing.T) {
+	camera, _ := libipcamera.CreateCamera(net.ParseIP("127.0.0.1"), 6666, "admin", "12345")
+	_, err := camera.Request(context.Background(), libipcamera.TAKE_PICTURE, nil, libipcamera.PICTURE_SAVED)
+	if !errors.Is(err, libipcamera.ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+}
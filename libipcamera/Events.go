@@ -0,0 +1,180 @@
+package libipcamera
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CameraMode is the mode selected on the camera, reported by NOTIFY_MODE_CHANGED.
+type CameraMode uint32
+
+const (
+	ModeVideo CameraMode = iota
+	ModePhoto
+	ModePlayback
+	ModeSettings
+)
+
+func (m CameraMode) String() string {
+	switch m {
+	case ModeVideo:
+		return "vídeo"
+	case ModePhoto:
+		return "foto"
+	case ModePlayback:
+		return "reprodução"
+	case ModeSettings:
+		return "configurações"
+	}
+	return fmt.Sprintf("CameraMode(%d)", uint32(m))
+}
+
+// EventType identifies an unsolicited notification of the camera.
+type EventType int
+
+const (
+	EventRecordingStarted EventType = iota
+	EventRecordingStopped
+	EventPictureTaken
+	EventCardRemoved
+	EventCardFull
+	EventLowBattery
+	EventModeChanged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRecordingStarted:
+		return "gravação iniciada na câmera"
+	case EventRecordingStopped:
+		return "gravação parada na câmera"
+	case EventPictureTaken:
+		return "foto tirada na câmera"
+	case EventCardRemoved:
+		return "cartão SD removido"
+	case EventCardFull:
+		return "cartão SD cheio"
+	case EventLowBattery:
+		return "bateria fraca"
+	case EventModeChanged:
+		return "modo alterado"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+var (
+	notificationMutex sync.RWMutex
+	// notificationEvents maps the notification messages to their event
+	// type. It starts with the placeholder NOTIFY_* IDs.
+	notificationEvents = map[uint32]EventType{
+		NOTIFY_RECORDING_STARTED: EventRecordingStarted,
+		NOTIFY_RECORDING_STOPPED: EventRecordingStopped,
+		NOTIFY_PICTURE_TAKEN:     EventPictureTaken,
+		NOTIFY_CARD_REMOVED:      EventCardRemoved,
+		NOTIFY_CARD_FULL:         EventCardFull,
+		NOTIFY_LOW_BATTERY:       EventLowBattery,
+		NOTIFY_MODE_CHANGED:      EventModeChanged,
+	}
+)
+
+// RegisterNotification makes messages of type id notify eventType to the
+// SubscribeEvents subscribers, replacing a previous registration of id. The
+// payload is decoded with the codec registered for id, see
+// RegisterMessageType: a FilePath sets Path and a Uint32Payload sets Battery
+// or Mode.
+func RegisterNotification(id uint32, eventType EventType) {
+	notificationMutex.Lock()
+	defer notificationMutex.Unlock()
+	notificationEvents[id] = eventType
+}
+
+// CameraEvent is something that happened on the camera itself, such as the
+// shutter button being pressed or the SD card being pulled. Commands sent by
+// clients are not notified.
+type CameraEvent struct {
+	Type EventType
+	// Path is the file written, for EventRecordingStopped and EventPictureTaken.
+	Path string
+	// Battery is the battery level in percent, for EventLowBattery.
+	Battery int
+	// Mode is the new mode, for EventModeChanged.
+	Mode CameraMode
+	Time time.Time
+}
+
+func (e CameraEvent) String() string {
+	switch e.Type {
+	case EventRecordingStopped, EventPictureTaken:
+		return fmt.Sprintf("%s: %s", e.Type, e.Path)
+	case EventLowBattery:
+		return fmt.Sprintf("%s: %d%%", e.Type, e.Battery)
+	case EventModeChanged:
+		return fmt.Sprintf("%s: %s", e.Type, e.Mode)
+	}
+	return e.Type.String()
+}
+
+// SubscribeEvents returns a channel receiving the unsolicited notifications
+// of the camera and a function that cancels the subscription and closes the
+// channel.
+func (c *Camera) SubscribeEvents() (<-chan CameraEvent, func()) {
+	events := make(chan CameraEvent, stateSubscriberBuffer)
+
+	c.eventMutex.Lock()
+	c.nextSubscriberID++
+	id := c.nextSubscriberID
+	if c.eventSubscribers == nil {
+		c.eventSubscribers = make(map[uint64]chan CameraEvent)
+	}
+	c.eventSubscribers[id] = events
+	c.eventMutex.Unlock()
+
+	unsubscribe := func() {
+		c.eventMutex.Lock()
+		defer c.eventMutex.Unlock()
+		if _, ok := c.eventSubscribers[id]; ok {
+			delete(c.eventSubscribers, id)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+// publishEvent notifies subscribers of a notification message and reports
+// whether message was one.
+func (c *Camera) publishEvent(message *Message) bool {
+	notificationMutex.RLock()
+	eventType, ok := notificationEvents[message.Header.MessageType]
+	notificationMutex.RUnlock()
+	if !ok {
+		return false
+	}
+	event := CameraEvent{Type: eventType, Time: time.Now()}
+	codec, err := message.Decode()
+	if err != nil {
+		c.log(LevelWarn, "Notificação inválida recebida", F("message", message), F("error", err))
+		return true
+	}
+	switch payload := codec.(type) {
+	case *FilePath:
+		event.Path = payload.Path
+	case *Uint32Payload:
+		if eventType == EventLowBattery {
+			event.Battery = int(payload.Value)
+		} else {
+			event.Mode = CameraMode(payload.Value)
+		}
+	}
+	c.log(LevelDebug, "Notificação recebida", F("event", event))
+
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	for _, subscriber := range c.eventSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+	return true
+}
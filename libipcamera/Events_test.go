@@ -0,0 +1,76 @@
+package libipcamera_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func receiveEvent(t *testing.T, events <-chan libipcamera.CameraEvent) libipcamera.CameraEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		event.Time = time.Time{}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return libipcamera.CameraEvent{}
+}
+
+func TestCameraEvents(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	events, unsubscribe := camera.SubscribeEvents()
+	defer unsubscribe()
+
+	value := func(v uint32) []byte {
+		payload := make([]byte, 4)
+		binary.LittleEndian.PutUint32(payload, v)
+		return payload
+	}
+	notifications := []struct {
+		messageType uint32
+		payload     []byte
+		expected    libipcamera.CameraEvent
+	}{
+		{libipcamera.NOTIFY_RECORDING_STARTED, nil, libipcamera.CameraEvent{Type: libipcamera.EventRecordingStarted}},
+		{libipcamera.NOTIFY_RECORDING_STOPPED, []byte("/DCIM/MOVIE/VID0001.MP4\x00"), libipcamera.CameraEvent{Type: libipcamera.EventRecordingStopped, Path: "/DCIM/MOVIE/VID0001.MP4"}},
+		{libipcamera.NOTIFY_MODE_CHANGED, value(uint32(libipcamera.ModePhoto)), libipcamera.CameraEvent{Type: libipcamera.EventModeChanged, Mode: libipcamera.ModePhoto}},
+		{libipcamera.NOTIFY_PICTURE_TAKEN, []byte("/DCIM/PHOTO/IMG0001.JPG"), libipcamera.CameraEvent{Type: libipcamera.EventPictureTaken, Path: "/DCIM/PHOTO/IMG0001.JPG"}},
+		{libipcamera.NOTIFY_LOW_BATTERY, value(10), libipcamera.CameraEvent{Type: libipcamera.EventLowBattery, Battery: 10}},
+		{libipcamera.NOTIFY_CARD_FULL, nil, libipcamera.CameraEvent{Type: libipcamera.EventCardFull}},
+		{libipcamera.NOTIFY_CARD_REMOVED, nil, libipcamera.CameraEvent{Type: libipcamera.EventCardRemoved}},
+	}
+	for _, notification := range notifications {
+		emu.Notify(notification.messageType, notification.payload)
+		if event := receiveEvent(t, events); event != notification.expected {
+			t.Fatalf("%s: received %s, expected %s", libipcamera.MessageName(notification.messageType), event, notification.expected)
+		}
+	}
+}
+
+func TestRegisterNotification(t *testing.T) {
+	const observed = 0xA0F8
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	events, unsubscribe := camera.SubscribeEvents()
+	defer unsubscribe()
+
+	emu.Notify(observed, nil)
+	emu.Notify(libipcamera.NOTIFY_CARD_FULL, nil)
+	if event := receiveEvent(t, events); event.Type != libipcamera.EventCardFull {
+		t.Fatalf("unregistered message notified %s", event)
+	}
+
+	libipcamera.RegisterNotification(observed, libipcamera.EventPictureTaken)
+	libipcamera.RegisterMessageType(observed, "TEST_PICTURE_TAKEN", func() libipcamera.Codec { return &libipcamera.FilePath{} })
+	defer libipcamera.UnregisterMessageType(observed)
+	emu.Notify(observed, []byte("/DCIM/PHOTO/IMG0002.JPG"))
+	if event := receiveEvent(t, events); event != (libipcamera.CameraEvent{Type: libipcamera.EventPictureTaken, Path: "/DCIM/PHOTO/IMG0002.JPG"}) {
+		t.Fatalf("received %s for a registered notification", event)
+	}
+}
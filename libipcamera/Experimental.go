@@ -0,0 +1,48 @@
+package libipcamera
+
+// unverifiedCommands lists the commands whose IDs and payload layouts were
+// never observed in traffic of a real camera. They are placeholders kept so
+// the features built on them can be tested against the emulator; sending them
+// to a real camera may trigger anything, so Request refuses them until
+// EnableExperimentalCommands is called. The get and set commands of
+// DefaultSettingsTable are added when the package is initialised.
+var unverifiedCommands = map[uint32]bool{
+	REQUEST_CLOCK:  true,
+	SET_CLOCK:      true,
+	REQUEST_STATUS: true,
+	DELETE_FILE:    true,
+	FORMAT_CARD:    true,
+	REQUEST_WIFI:   true,
+	SET_WIFI:       true,
+	SET_LOGIN:      true,
+}
+
+// IsVerifiedCommand reports whether the ID and payload of command were
+// observed on a real camera. Unverified commands are only sent after
+// EnableExperimentalCommands.
+func IsVerifiedCommand(command uint32) bool {
+	return !unverifiedCommands[command]
+}
+
+// EnableExperimentalCommands allows sending commands whose IDs and payloads
+// are unverified placeholders, see IsVerifiedCommand.
+func (c *Camera) EnableExperimentalCommands(enabled bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.experimental = enabled
+}
+
+// checkVerified returns an UnverifiedCommandError for unverified commands
+// unless experimental commands are enabled.
+func (c *Camera) checkVerified(command uint32) error {
+	if IsVerifiedCommand(command) {
+		return nil
+	}
+	c.stateMutex.RLock()
+	experimental := c.experimental
+	c.stateMutex.RUnlock()
+	if !experimental {
+		return &UnverifiedCommandError{Command: command}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package libipcamera_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// defaultFirmware is the firmware version the emulator reports by default.
+const defaultFirmware = "SJ4000AIR V1.0 20200101"
+
+// startEmulator serves an emulated camera on local ports, sending keepalives
+// every 5ms, and stops it when the test ends.
+func startEmulator(t *testing.T, config emulator.Config) *emulator.Emulator {
+	t.Helper()
+	config.ControlAddress = "127.0.0.1:0"
+	config.DisableDiscovery = true
+	config.DisableHTTP = true
+	if config.AliveInterval == 0 {
+		config.AliveInterval = 5 * time.Millisecond
+	}
+	emu := emulator.CreateEmulator(config)
+	if err := emu.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	t.Cleanup(func() { emu.Close() })
+	return emu
+}
+
+// dropReplies makes emu stop answering commands.
+func dropReplies(emu *emulator.Emulator, commands ...uint32) {
+	drop := make(map[uint32]bool)
+	for _, command := range commands {
+		drop[command] = true
+	}
+	emu.SetFaults(emulator.Faults{DropReplies: drop})
+}
+
+// createClient returns a quiet camera for emu, not yet connected.
+func createClient(t *testing.T, emu *emulator.Emulator) *libipcamera.Camera {
+	t.Helper()
+	camera, err := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	if err != nil {
+		t.Fatalf("CreateCamera: %s", err)
+	}
+	camera.SetVerbose(false)
+	camera.SetLogger(libipcamera.NopLogger())
+	return camera
+}
+
+// connectClient returns a camera logged in to emu.
+func connectClient(t *testing.T, emu *emulator.Emulator) *libipcamera.Camera {
+	t.Helper()
+	camera := createClient(t, emu)
+	if err := camera.Connect(); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	t.Cleanup(camera.Disconnect)
+	if err := camera.Login(); err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	return camera
+}
+
+// waitFor fails the test unless condition becomes true within 2s.
+var waitFor = libipcamera.WaitFor
@@ -0,0 +1,276 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupCameraConfig describes one camera of a group in a groups file.
+type GroupCameraConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Port defaults to 6666.
+	Port int `json:"port,omitempty"`
+	// Interface is the network interface connected to the camera's access
+	// point, empty uses the default route.
+	Interface string `json:"interface,omitempty"`
+	// Username and Password default to admin and 12345.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// LoadGroups reads a JSON object mapping group names to their cameras, e.g.
+// {"rig1": [{"name": "esquerda", "address": "192.168.1.254", "interface": "wlan1"}]}.
+func LoadGroups(r io.Reader) (map[string][]GroupCameraConfig, error) {
+	groups := make(map[string][]GroupCameraConfig)
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&groups); err != nil {
+		return nil, err
+	}
+	for name, cameras := range groups {
+		seen := make(map[string]bool)
+		for _, camera := range cameras {
+			if camera.Name == "" || camera.Address == "" {
+				return nil, fmt.Errorf("Grupo %s: cada câmera precisa de name e address", name)
+			}
+			if seen[camera.Name] {
+				return nil, fmt.Errorf("Grupo %s: câmera %s repetida", name, camera.Name)
+			}
+			seen[camera.Name] = true
+		}
+	}
+	return groups, nil
+}
+
+// GroupCamera is what a CameraGroup needs of its cameras.
+type GroupCamera interface {
+	SessionController
+	CaptureController
+}
+
+// GroupMember is a named camera of a CameraGroup.
+type GroupMember struct {
+	Name   string
+	Camera GroupCamera
+}
+
+// CameraGroup drives several cameras at once, e.g. the cameras of a rig, each
+// usually reached through its own Wi-Fi interface. It is safe for concurrent use.
+type CameraGroup struct {
+	mutex   sync.Mutex
+	members []GroupMember
+}
+
+func NewCameraGroup() *CameraGroup {
+	return &CameraGroup{}
+}
+
+// CreateCameraGroup creates a group with a Camera for each entry of configs.
+// The cameras are not connected, call Connect and Login.
+func CreateCameraGroup(configs []GroupCameraConfig) (*CameraGroup, error) {
+	group := NewCameraGroup()
+	for _, config := range configs {
+		address := net.ParseIP(config.Address)
+		if address == nil {
+			return nil, fmt.Errorf("Endereço inválido para a câmera %s: %s", config.Name, config.Address)
+		}
+		if config.Port == 0 {
+			config.Port = 6666
+		}
+		if config.Username == "" {
+			config.Username = "admin"
+		}
+		if config.Password == "" {
+			config.Password = "12345"
+		}
+		camera, err := CreateCamera(address, config.Port, config.Username, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		if config.Interface != "" {
+			dialer, err := InterfaceDialer(config.Interface)
+			if err != nil {
+				return nil, fmt.Errorf("Câmera %s: %s", config.Name, err)
+			}
+			camera.SetDialer(dialer)
+		}
+		group.Add(config.Name, camera)
+	}
+	return group, nil
+}
+
+// Add adds camera to the group under name.
+func (g *CameraGroup) Add(name string, camera GroupCamera) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.members = append(g.members, GroupMember{Name: name, Camera: camera})
+}
+
+// Members returns the cameras of the group in the order they were added.
+func (g *CameraGroup) Members() []GroupMember {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]GroupMember(nil), g.members...)
+}
+
+// MemberResult is the outcome of a group action on one camera.
+type MemberResult struct {
+	Name string
+	// Sent is when the first command of the action was written to the
+	// connection, or when the action started for cameras that do not send
+	// through Request. Replied is when the action returned.
+	Sent    time.Time
+	Replied time.Time
+	Err     error
+}
+
+// GroupResult is the outcome of a group action on every camera.
+type GroupResult struct {
+	Members []MemberResult
+	// Skew is the spread of the times the command was sent to the cameras,
+	// ReplySkew the spread of the replies of the cameras that succeeded.
+	Skew      time.Duration
+	ReplySkew time.Duration
+}
+
+// Err returns a GroupError listing the cameras that failed, or nil.
+func (r *GroupResult) Err() error {
+	failed := make(map[string]error)
+	for _, member := range r.Members {
+		if member.Err != nil {
+			failed[member.Name] = member.Err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &GroupError{Failed: failed}
+}
+
+// String describes the result, one line per camera.
+func (r *GroupResult) String() string {
+	lines := []string{fmt.Sprintf("defasagem de envio %s, de resposta %s", r.Skew, r.ReplySkew)}
+	for _, member := range r.Members {
+		if member.Err != nil {
+			lines = append(lines, fmt.Sprintf("%s: ERRO %s", member.Name, member.Err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: ok em %s", member.Name, member.Replied.Sub(member.Sent).Round(time.Microsecond)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// run calls action on every camera in parallel. The goroutines are started
+// first and released together, so the commands leave as close in time as
+// possible. The send times are taken by Request right after writing, so the
+// skew includes the time spent waiting for the connection, not only the
+// wake-up of the goroutines.
+func (g *CameraGroup) run(ctx context.Context, action func(ctx context.Context, camera GroupCamera) error) (*GroupResult, error) {
+	members := g.Members()
+	result := &GroupResult{Members: make([]MemberResult, len(members))}
+
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+	ready.Add(len(members))
+	done.Add(len(members))
+	for i, member := range members {
+		go func(i int, member GroupMember) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			var sent time.Time
+			actionCtx := withSentHook(ctx, func(written time.Time) {
+				if sent.IsZero() {
+					sent = written
+				}
+			})
+			started := time.Now()
+			err := action(actionCtx, member.Camera)
+			if sent.IsZero() {
+				sent = started
+			}
+			result.Members[i] = MemberResult{Name: member.Name, Sent: sent, Replied: time.Now(), Err: err}
+		}(i, member)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+
+	var sent, replied []time.Time
+	for _, member := range result.Members {
+		sent = append(sent, member.Sent)
+		if member.Err == nil {
+			replied = append(replied, member.Replied)
+		}
+	}
+	result.Skew, result.ReplySkew = spread(sent), spread(replied)
+	return result, result.Err()
+}
+
+func spread(times []time.Time) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+	first, last := times[0], times[0]
+	for _, t := range times[1:] {
+		if t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last.Sub(first)
+}
+
+// Connect connects every camera in parallel.
+func (g *CameraGroup) Connect(ctx context.Context) (*GroupResult, error) {
+	return g.run(ctx, func(ctx context.Context, camera GroupCamera) error {
+		return camera.ConnectContext(ctx)
+	})
+}
+
+// Login logs in to every camera in parallel.
+func (g *CameraGroup) Login(ctx context.Context) (*GroupResult, error) {
+	return g.run(ctx, func(ctx context.Context, camera GroupCamera) error {
+		return camera.LoginContext(ctx)
+	})
+}
+
+// Disconnect disconnects every camera.
+func (g *CameraGroup) Disconnect() {
+	for _, member := range g.Members() {
+		member.Camera.Disconnect()
+	}
+}
+
+// TakePicture takes a picture on every camera at once. The result is
+// returned with a GroupError when some of the cameras failed.
+func (g *CameraGroup) TakePicture(ctx context.Context) (*GroupResult, error) {
+	return g.run(ctx, func(ctx context.Context, camera GroupCamera) error {
+		return camera.TakePictureContext(ctx)
+	})
+}
+
+// StartRecording starts recording on every camera at once. The result is
+// returned with a GroupError when some of the cameras failed.
+func (g *CameraGroup) StartRecording(ctx context.Context) (*GroupResult, error) {
+	return g.run(ctx, func(ctx context.Context, camera GroupCamera) error {
+		return camera.StartRecordingContext(ctx)
+	})
+}
+
+// StopRecording stops recording on every camera at once. The result is
+// returned with a GroupError when some of the cameras failed.
+func (g *CameraGroup) StopRecording(ctx context.Context) (*GroupResult, error) {
+	return g.run(ctx, func(ctx context.Context, camera GroupCamera) error {
+		return camera.StopRecordingContext(ctx)
+	})
+}
@@ -0,0 +1,139 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// mockCamera implements GroupCamera with the capture calls only, as code
+// using the role interfaces would mock it.
+type mockCamera struct {
+	libipcamera.GroupCamera
+	err      error
+	pictures int
+}
+
+func (m *mockCamera) TakePictureContext(ctx context.Context) error {
+	m.pictures++
+	return m.err
+}
+
+func TestGroupWithMockCameras(t *testing.T) {
+	working, broken := &mockCamera{}, &mockCamera{err: libipcamera.ErrCameraBusy}
+	group := libipcamera.NewCameraGroup()
+	group.Add("a", working)
+	group.Add("b", broken)
+
+	result, err := group.TakePicture(context.Background())
+	var groupError *libipcamera.GroupError
+	if !errors.As(err, &groupError) || len(groupError.Failed) != 1 || !errors.Is(groupError.Failed["b"], libipcamera.ErrCameraBusy) {
+		t.Fatalf("expected camera b to fail, got %v", err)
+	}
+	if working.pictures != 1 || broken.pictures != 1 || len(result.Members) != 2 {
+		t.Fatalf("unexpected calls %d/%d, result %+v", working.pictures, broken.pictures, result)
+	}
+}
+
+func TestCameraGroup(t *testing.T) {
+	group := libipcamera.NewCameraGroup()
+	emulators := make([]*emulator.Emulator, 3)
+	for i := range emulators {
+		emulators[i] = startEmulator(t, emulator.Config{})
+		group.Add(fmt.Sprintf("cam%d", i), connectClient(t, emulators[i]))
+	}
+
+	result, err := group.StartRecording(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Members) != 3 || result.Skew > 100*time.Millisecond {
+		t.Fatalf("unexpected result %s", result)
+	}
+	for i, emu := range emulators {
+		if emu.Received(libipcamera.CONTROL_RECORDING) != 1 {
+			t.Fatalf("cam%d did not receive CONTROL_RECORDING", i)
+		}
+	}
+
+	dropReplies(emulators[1], libipcamera.CONTROL_RECORDING)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err = group.StopRecording(ctx)
+	var groupError *libipcamera.GroupError
+	if !errors.As(err, &groupError) || len(groupError.Failed) != 1 || !errors.Is(groupError.Failed["cam1"], libipcamera.ErrTimeout) {
+		t.Fatalf("expected cam1 to time out, got %v", err)
+	}
+	if result.Members[0].Err != nil || result.Members[2].Err != nil {
+		t.Fatalf("other cameras not stopped: %s", result)
+	}
+}
+
+func TestGroupSentIsWriteTime(t *testing.T) {
+	fast := startEmulator(t, emulator.Config{})
+	slowCamera := connectClient(t, startEmulator(t, emulator.Config{}))
+	group := libipcamera.NewCameraGroup()
+	group.Add("fast", connectClient(t, fast))
+	group.Add("slow", slowCamera)
+
+	// The command of the slow camera cannot be written before the send
+	// lock is released.
+	unlock := libipcamera.LockSend(slowCamera)
+	results := make(chan *libipcamera.GroupResult)
+	go func() {
+		result, _ := group.TakePicture(context.Background())
+		results <- result
+	}()
+	waitFor(t, func() bool { return fast.Received(libipcamera.TAKE_PICTURE) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	released := time.Now()
+	unlock()
+
+	result := <-results
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if result.Members[1].Sent.Before(released) {
+		t.Fatalf("slow camera sent at %s, before the send lock was released at %s", result.Members[1].Sent, released)
+	}
+	if result.Skew < 50*time.Millisecond {
+		t.Fatalf("skew %s hides the blocked write", result.Skew)
+	}
+}
+
+func TestLoadGroups(t *testing.T) {
+	groups, err := libipcamera.LoadGroups(strings.NewReader(`{"rig1": [
+		{"name": "esquerda", "address": "192.168.1.254"},
+		{"name": "direita", "address": "192.168.1.254", "port": 7777, "password": "secret"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := libipcamera.CreateCameraGroup(groups["rig1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := group.Members()
+	if len(members) != 2 || members[0].Name != "esquerda" || members[1].Name != "direita" {
+		t.Fatalf("unexpected members %+v", members)
+	}
+	if ip, port, username, password := libipcamera.Endpoint(members[1].Camera.(*libipcamera.Camera)); port != 7777 || username != "admin" || password != "secret" {
+		t.Fatalf("defaults not applied: %s:%d %s", ip, port, username)
+	}
+
+	for _, invalid := range []string{
+		`{"rig1": [{"name": "a"}]}`,
+		`{"rig1": [{"name": "a", "address": "10.0.0.1"}, {"name": "a", "address": "10.0.0.2"}]}`,
+		`{"rig1": [{"name": "a", "address": "10.0.0.1", "ssid": "x"}]}`,
+	} {
+		if _, err := libipcamera.LoadGroups(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("%s accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package libipcamera
+
+import (
+	"context"
+	"time"
+)
+
+// HealthPolicy configures the keepalive monitor enabled with Camera.EnableHealthMonitor.
+// Zero values are replaced by the values of DefaultHealthPolicy.
+type HealthPolicy struct {
+	// Interval is the expected period of the ALIVE_REQUEST messages sent by
+	// the camera, used until the monitor has measured it.
+	Interval time.Duration
+	// MaxMissed is the number of keepalive intervals without any message from
+	// the camera after which the link is declared dead.
+	MaxMissed int
+}
+
+// maxHealthCheckPeriod is the longest pause between two checks of the monitor.
+const maxHealthCheckPeriod = 250 * time.Millisecond
+
+// DefaultHealthPolicy returns the policy used for unset HealthPolicy fields.
+func DefaultHealthPolicy() HealthPolicy {
+	return HealthPolicy{
+		Interval:  time.Second,
+		MaxMissed: 3,
+	}
+}
+
+// HealthStats are the link metrics of the current connection. The camera
+// sends the keepalives and the client only answers them, so the link is
+// judged by how regularly they arrive and by how fast requests are answered.
+type HealthStats struct {
+	// LastSeen is when the last message of any type arrived, LastKeepalive
+	// when the last ALIVE_REQUEST arrived.
+	LastSeen      time.Time
+	LastKeepalive time.Time
+	// KeepaliveInterval is the average time between the ALIVE_REQUEST
+	// messages of the camera, zero until two have arrived.
+	KeepaliveInterval time.Duration
+	// RoundTrip is the average time between sending a request and receiving
+	// its first reply, zero until a request has been answered. It includes
+	// the time the camera takes to handle the command.
+	RoundTrip time.Duration
+	// Missed is the number of keepalive intervals elapsed since LastSeen.
+	Missed int
+	// Dead is set when the monitor declared the link dead.
+	Dead bool
+}
+
+// EnableHealthMonitor starts watching the keepalives sent by the camera. When
+// MaxMissed keepalive intervals pass without any message, pending requests
+// fail with ErrLinkDead and the connection is closed, which starts the
+// reconnect supervisor if reconnecting is enabled.
+func (c *Camera) EnableHealthMonitor(policy HealthPolicy) {
+	defaults := DefaultHealthPolicy()
+	if policy.Interval <= 0 {
+		policy.Interval = defaults.Interval
+	}
+	if policy.MaxMissed <= 0 {
+		policy.MaxMissed = defaults.MaxMissed
+	}
+
+	c.DisableHealthMonitor()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.healthMutex.Lock()
+	c.healthCancel = cancel
+	c.healthMutex.Unlock()
+	go c.monitorHealth(ctx, policy)
+}
+
+// DisableHealthMonitor stops the keepalive monitor.
+func (c *Camera) DisableHealthMonitor() {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	if c.healthCancel != nil {
+		c.healthCancel()
+		c.healthCancel = nil
+	}
+}
+
+// Health returns the keepalive metrics of the current connection.
+func (c *Camera) Health() HealthStats {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	return c.health
+}
+
+// resetHealth starts the metrics of a new connection.
+func (c *Camera) resetHealth() {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	c.health = HealthStats{LastSeen: time.Now()}
+}
+
+// recordHealth updates the metrics with a received message.
+func (c *Camera) recordHealth(message *Message) {
+	now := time.Now()
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	c.health.LastSeen = now
+	c.health.Missed = 0
+	if message.Header.MessageType == ALIVE_REQUEST {
+		if !c.health.LastKeepalive.IsZero() {
+			// A moving average keeps two keepalives read back to back
+			// from shrinking the interval the misses are counted against.
+			interval := now.Sub(c.health.LastKeepalive)
+			if c.health.KeepaliveInterval > 0 {
+				interval = (7*c.health.KeepaliveInterval + interval) / 8
+			}
+			c.health.KeepaliveInterval = interval
+		}
+		c.health.LastKeepalive = now
+	}
+}
+
+// recordRoundTrip updates the metrics with the time a request waited for its
+// first reply.
+func (c *Camera) recordRoundTrip(roundTrip time.Duration) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	if c.health.RoundTrip > 0 {
+		roundTrip = (7*c.health.RoundTrip + roundTrip) / 8
+	}
+	c.health.RoundTrip = roundTrip
+}
+
+// keepaliveInterval returns the measured keepalive interval, or fallback
+// until one has been measured.
+func (c *Camera) keepaliveInterval(fallback time.Duration) time.Duration {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	if c.health.KeepaliveInterval > 0 {
+		return c.health.KeepaliveInterval
+	}
+	return fallback
+}
+
+func (c *Camera) monitorHealth(ctx context.Context, policy HealthPolicy) {
+	for {
+		// Checking twice per interval notices a dead link at most half an
+		// interval late; the cap picks up a newly measured interval soon.
+		interval := c.keepaliveInterval(policy.Interval)
+		check := interval / 2
+		if check > maxHealthCheckPeriod {
+			check = maxHealthCheckPeriod
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(check):
+		}
+		if !c.IsConnected() {
+			continue
+		}
+
+		c.healthMutex.Lock()
+		missed := int(time.Since(c.health.LastSeen) / interval)
+		c.health.Missed = missed
+		dead := missed >= policy.MaxMissed && !c.health.Dead
+		if dead {
+			c.health.Dead = true
+		}
+		c.healthMutex.Unlock()
+
+		if dead {
+			c.log(LevelWarn, "A câmera parou de enviar keepalives, encerrando a conexão", F("missed", missed), F("interval", interval), F("address", c.ipAddress))
+			c.failPending(ErrLinkDead)
+			c.dropConnection(ErrLinkDead)
+		}
+	}
+}
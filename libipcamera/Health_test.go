@@ -0,0 +1,94 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestHealthMeasuresCameraKeepalives(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
+	defer camera.DisableHealthMonitor()
+
+	waitFor(t, func() bool { return emu.Received(libipcamera.ALIVE_RESPONSE) >= 5 })
+	health := camera.Health()
+	if health.KeepaliveInterval <= 0 || health.KeepaliveInterval > 100*time.Millisecond {
+		t.Fatalf("keepalive interval of the emulator measured as %s", health.KeepaliveInterval)
+	}
+	if time.Since(health.LastKeepalive) > time.Second || health.Dead || health.Missed != 0 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+	// The camera sends the keepalives, the client only answers them.
+	if emu.Received(libipcamera.ALIVE_REQUEST) != 0 {
+		t.Fatalf("client sent %d ALIVE_REQUEST", emu.Received(libipcamera.ALIVE_REQUEST))
+	}
+}
+
+func TestHealthMeasuresRoundTrip(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	emu.SetFaults(emulator.Faults{ReplyDelay: 20 * time.Millisecond})
+	camera := connectClient(t, emu)
+
+	if _, err := camera.GetFirmwareInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if roundTrip := camera.Health().RoundTrip; roundTrip < 10*time.Millisecond || roundTrip > time.Second {
+		t.Fatalf("round trip measured as %s with replies delayed 20ms", roundTrip)
+	}
+}
+
+func TestHealthMonitorDeclaresDeadLink(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	// The fallback interval is far longer than the 5ms keepalives of the
+	// emulator, so a quick detection shows the measured interval is used.
+	camera.EnableHealthMonitor(libipcamera.HealthPolicy{Interval: 10 * time.Second, MaxMissed: 3})
+	defer camera.DisableHealthMonitor()
+	restored := make(chan struct{}, 1)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			if event.Type == libipcamera.ReconnectRestored {
+				restored <- struct{}{}
+			}
+		},
+	})
+	waitFor(t, func() bool { return camera.Health().KeepaliveInterval > 0 })
+
+	emu.SetFaults(emulator.Faults{Unresponsive: true})
+	started := time.Now()
+	if _, err := camera.GetFirmwareInfoContext(context.Background()); !errors.Is(err, libipcamera.ErrLinkDead) {
+		t.Fatalf("expected ErrLinkDead, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("dead link detected after %s", elapsed)
+	}
+
+	emu.SetFaults(emulator.Faults{})
+	select {
+	case <-restored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not restored")
+	}
+	if !camera.IsLoggedIn() || camera.Health().Dead {
+		t.Fatalf("session not restored, health %+v", camera.Health())
+	}
+}
+
+func TestHealthMonitorUsesFallbackInterval(t *testing.T) {
+	camera := connectClient(t, startEmulator(t, emulator.Config{AliveInterval: -1}))
+
+	// Without any keepalive the fallback interval applies.
+	camera.EnableHealthMonitor(libipcamera.HealthPolicy{Interval: 20 * time.Millisecond, MaxMissed: 2})
+	defer camera.DisableHealthMonitor()
+	waitFor(t, func() bool { return camera.Health().Dead })
+	if health := camera.Health(); health.KeepaliveInterval != 0 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+}
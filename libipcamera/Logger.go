@@ -0,0 +1,142 @@
+package libipcamera
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERRO"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Field is a structured key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger receives the log entries of Camera, RTPRelay, the discovery
+// functions and rtsp.Server. Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(level Level, message string, fields ...Field)
+}
+
+// RedactedValue replaces the value of sensitive fields.
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactedKeys are the field keys whose values are never logged.
+// Keys are matched case-insensitively against the end of a field key, so
+// "wifi_password" is redacted as well.
+var DefaultRedactedKeys = []string{"password", "senha", "passphrase", "secret", "token"}
+
+type stdLogger struct {
+	logger   *log.Logger
+	minLevel Level
+}
+
+// NewStdLogger returns a Logger writing entries of at least minLevel to logger,
+// formatted as "LEVEL message key=value ...". A nil logger writes to the
+// standard logger of the log package.
+func NewStdLogger(logger *log.Logger, minLevel Level) Logger {
+	return &stdLogger{logger: logger, minLevel: minLevel}
+}
+
+func (l *stdLogger) Log(level Level, message string, fields ...Field) {
+	if level < l.minLevel {
+		return
+	}
+	var line strings.Builder
+	line.WriteString(level.String())
+	line.WriteString(" ")
+	line.WriteString(message)
+	for _, field := range fields {
+		fmt.Fprintf(&line, " %s=%v", field.Key, field.Value)
+	}
+	if l.logger == nil {
+		log.Println(line.String())
+		return
+	}
+	l.logger.Println(line.String())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+// NopLogger returns a Logger discarding every entry.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type redactingLogger struct {
+	next Logger
+	keys []string
+}
+
+// NewRedactingLogger wraps next so that fields matching keys, or
+// DefaultRedactedKeys when none are given, are logged as RedactedValue.
+func NewRedactingLogger(next Logger, keys ...string) Logger {
+	if _, ok := next.(*redactingLogger); ok && len(keys) == 0 {
+		return next
+	}
+	if len(keys) == 0 {
+		keys = DefaultRedactedKeys
+	}
+	lowerKeys := make([]string, len(keys))
+	for i, key := range keys {
+		lowerKeys[i] = strings.ToLower(key)
+	}
+	return &redactingLogger{next: next, keys: lowerKeys}
+}
+
+func (l *redactingLogger) Log(level Level, message string, fields ...Field) {
+	redacted := make([]Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = field
+		if l.sensitive(field.Key) {
+			redacted[i].Value = RedactedValue
+		}
+	}
+	l.next.Log(level, message, redacted...)
+}
+
+func (l *redactingLogger) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range l.keys {
+		if strings.HasSuffix(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultLogger is used until SetLogger is called.
+func defaultLogger() Logger {
+	return NewRedactingLogger(NewStdLogger(nil, LevelDebug))
+}
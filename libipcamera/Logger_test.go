@@ -0,0 +1,64 @@
+package libipcamera_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+type recordingLogger struct {
+	mutex   sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) Log(level libipcamera.Level, message string, fields ...libipcamera.Field) {
+	var buffer bytes.Buffer
+	libipcamera.NewStdLogger(log.New(&buffer, "", 0), libipcamera.LevelDebug).Log(level, message, fields...)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, strings.TrimSpace(buffer.String()))
+}
+
+func (l *recordingLogger) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return strings.Join(l.entries, "\n")
+}
+
+func TestRedactingLogger(t *testing.T) {
+	recorder := &recordingLogger{}
+	logger := libipcamera.NewRedactingLogger(recorder)
+	logger.Log(libipcamera.LevelInfo, "login", libipcamera.F("username", "admin"), libipcamera.F("password", "12345"), libipcamera.F("Wifi_Password", "secret"))
+
+	output := recorder.String()
+	if output != "INFO login username=admin password=[REDACTED] Wifi_Password=[REDACTED]" {
+		t.Fatalf("unexpected output %q", output)
+	}
+}
+
+func TestCameraLogDoesNotLeakPassword(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "s3cr3t")
+	recorder := &recordingLogger{}
+	camera.SetLogger(recorder)
+	camera.SetVerbose(true)
+	defer camera.Disconnect()
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	camera.Log("Formato %s com %d argumentos", "correto", 2)
+
+	output := recorder.String()
+	if strings.Contains(output, "s3cr3t") {
+		t.Fatalf("password leaked into log:\n%s", output)
+	}
+	if !strings.Contains(output, "Formato correto com 2 argumentos") {
+		t.Fatalf("formatted message missing:\n%s", output)
+	}
+}
@@ -0,0 +1,314 @@
+package libipcamera
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func init() {
+	RegisterMessageType(LOGIN, "LOGIN", func() Codec { return &LoginRequest{} })
+	RegisterMessageType(LOGIN_ACCEPT, "LOGIN_ACCEPT", emptyCodec)
+	RegisterMessageType(LOGIN_REJECTED, "LOGIN_REJECTED", emptyCodec)
+	RegisterMessageType(ALIVE_REQUEST, "ALIVE_REQUEST", emptyCodec)
+	RegisterMessageType(ALIVE_RESPONSE, "ALIVE_RESPONSE", emptyCodec)
+	RegisterMessageType(DISCOVERY_REQUEST, "DISCOVERY_REQUEST", emptyCodec)
+	RegisterMessageType(DISCOVERY_RESPONSE, "DISCOVERY_RESPONSE", nil)
+	RegisterMessageType(START_PREVIEW, "START_PREVIEW", emptyCodec)
+	RegisterMessageType(REQUEST_FILE_LIST, "REQUEST_FILE_LIST", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(FILE_LIST_CONTENT, "FILE_LIST_CONTENT", func() Codec { return &FileListPart{} })
+	RegisterMessageType(REQUEST_FIRMWARE_INFO, "REQUEST_FIRMWARE_INFO", emptyCodec)
+	RegisterMessageType(FIRMWARE_INFORMATION, "FIRMWARE_INFORMATION", func() Codec { return &FirmwareInformation{} })
+	RegisterMessageType(TAKE_PICTURE, "TAKE_PICTURE", emptyCodec)
+	RegisterMessageType(PICTURE_SAVED, "PICTURE_SAVED", emptyCodec)
+	RegisterMessageType(CONTROL_RECORDING, "CONTROL_RECORDING", func() Codec { return &RecordingControl{} })
+	RegisterMessageType(RECORD_COMMAND_ACCEPT, "RECORD_COMMAND_ACCEPT", func() Codec { return &RecordingControl{} })
+	RegisterMessageType(REQUEST_CLOCK, "REQUEST_CLOCK", emptyCodec)
+	RegisterMessageType(CLOCK_INFORMATION, "CLOCK_INFORMATION", func() Codec { return &ClockTime{} })
+	RegisterMessageType(SET_CLOCK, "SET_CLOCK", func() Codec { return &ClockTime{} })
+	RegisterMessageType(CLOCK_SET_ACCEPT, "CLOCK_SET_ACCEPT", emptyCodec)
+	RegisterMessageType(REQUEST_STATUS, "REQUEST_STATUS", emptyCodec)
+	RegisterMessageType(STATUS_INFORMATION, "STATUS_INFORMATION", func() Codec { return &CameraStatus{} })
+	RegisterMessageType(DELETE_FILE, "DELETE_FILE", func() Codec { return &FilePath{} })
+	RegisterMessageType(FILE_DELETED, "FILE_DELETED", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(FORMAT_CARD, "FORMAT_CARD", emptyCodec)
+	RegisterMessageType(CARD_FORMATTED, "CARD_FORMATTED", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(REQUEST_WIFI, "REQUEST_WIFI", emptyCodec)
+	RegisterMessageType(WIFI_INFORMATION, "WIFI_INFORMATION", func() Codec { return &WifiConfig{} })
+	RegisterMessageType(SET_WIFI, "SET_WIFI", func() Codec { return &WifiConfig{} })
+	RegisterMessageType(WIFI_SET_ACCEPT, "WIFI_SET_ACCEPT", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(SET_LOGIN, "SET_LOGIN", func() Codec { return &LoginRequest{} })
+	RegisterMessageType(LOGIN_SET_ACCEPT, "LOGIN_SET_ACCEPT", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(NOTIFY_RECORDING_STARTED, "NOTIFY_RECORDING_STARTED", emptyCodec)
+	RegisterMessageType(NOTIFY_RECORDING_STOPPED, "NOTIFY_RECORDING_STOPPED", func() Codec { return &FilePath{} })
+	RegisterMessageType(NOTIFY_PICTURE_TAKEN, "NOTIFY_PICTURE_TAKEN", func() Codec { return &FilePath{} })
+	RegisterMessageType(NOTIFY_CARD_REMOVED, "NOTIFY_CARD_REMOVED", emptyCodec)
+	RegisterMessageType(NOTIFY_CARD_FULL, "NOTIFY_CARD_FULL", emptyCodec)
+	RegisterMessageType(NOTIFY_LOW_BATTERY, "NOTIFY_LOW_BATTERY", func() Codec { return &Uint32Payload{} })
+	RegisterMessageType(NOTIFY_MODE_CHANGED, "NOTIFY_MODE_CHANGED", func() Codec { return &Uint32Payload{} })
+}
+
+var errShortPayload = errors.New("carga útil curta demais")
+
+// EmptyPayload is the codec of messages without payload.
+type EmptyPayload struct{}
+
+func emptyCodec() Codec {
+	return &EmptyPayload{}
+}
+
+func (p *EmptyPayload) MarshalBinary() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (p *EmptyPayload) UnmarshalBinary(data []byte) error {
+	return nil
+}
+
+func (p *EmptyPayload) String() string {
+	return ""
+}
+
+// Uint32Payload is a payload made of a single little endian uint32.
+type Uint32Payload struct {
+	Value uint32
+}
+
+func (p *Uint32Payload) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, p.Value)
+	return data, nil
+}
+
+func (p *Uint32Payload) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errShortPayload
+	}
+	p.Value = binary.LittleEndian.Uint32(data)
+	return nil
+}
+
+func (p *Uint32Payload) String() string {
+	return fmt.Sprintf("%d", p.Value)
+}
+
+// LoginRequest is the payload of LOGIN, two zero padded 64 byte fields.
+type LoginRequest struct {
+	Username string
+	Password string
+}
+
+// MarshalBinary truncates usernames and passwords longer than 64 bytes.
+func (p *LoginRequest) MarshalBinary() ([]byte, error) {
+	payload := make([]byte, 128)
+	copy(payload, p.Username)
+	copy(payload[64:], p.Password)
+	return payload, nil
+}
+
+func (p *LoginRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 128 {
+		return errShortPayload
+	}
+	p.Username = string(bytes.TrimRight(data[:64], "\x00"))
+	p.Password = string(bytes.TrimRight(data[64:128], "\x00"))
+	return nil
+}
+
+func (p *LoginRequest) String() string {
+	return fmt.Sprintf("username=%s password=%s", p.Username, RedactedValue)
+}
+
+// PartHeader starts every part of a multi-part reply.
+type PartHeader struct {
+	Parts uint32
+	Part  uint32
+}
+
+func (h *PartHeader) unmarshal(data []byte) error {
+	if len(data) < 8 {
+		return errShortPayload
+	}
+	h.Parts = binary.LittleEndian.Uint32(data[:4])
+	h.Part = binary.LittleEndian.Uint32(data[4:8])
+	return nil
+}
+
+func (h *PartHeader) marshal() []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, h.Parts)
+	binary.LittleEndian.PutUint32(data[4:], h.Part)
+	return data
+}
+
+// Last reports whether this is the final part of the reply.
+func (h *PartHeader) Last() bool {
+	return h.Part+1 >= h.Parts
+}
+
+// FileListPart is one part of FILE_LIST_CONTENT, a chunk of "path:size;" entries.
+type FileListPart struct {
+	PartHeader
+	Data []byte
+}
+
+func (p *FileListPart) MarshalBinary() ([]byte, error) {
+	return append(p.PartHeader.marshal(), p.Data...), nil
+}
+
+func (p *FileListPart) UnmarshalBinary(data []byte) error {
+	if err := p.PartHeader.unmarshal(data); err != nil {
+		return err
+	}
+	p.Data = append([]byte(nil), data[8:]...)
+	return nil
+}
+
+func (p *FileListPart) String() string {
+	return fmt.Sprintf("part %d/%d (%d bytes)", p.Part+1, p.Parts, len(p.Data))
+}
+
+// FirmwareInformation is the payload of FIRMWARE_INFORMATION.
+type FirmwareInformation struct {
+	Version string
+}
+
+func (p *FirmwareInformation) MarshalBinary() ([]byte, error) {
+	return []byte(p.Version), nil
+}
+
+func (p *FirmwareInformation) UnmarshalBinary(data []byte) error {
+	p.Version = string(bytes.TrimRight(data, "\x00"))
+	return nil
+}
+
+func (p *FirmwareInformation) String() string {
+	return p.Version
+}
+
+// RecordingControl is the payload of CONTROL_RECORDING and RECORD_COMMAND_ACCEPT.
+type RecordingControl struct {
+	Start bool
+}
+
+func (p *RecordingControl) MarshalBinary() ([]byte, error) {
+	payload := []byte{0x00, 0x00, 0x00, 0x00}
+	if p.Start {
+		payload[0] = 0x01
+	}
+	return payload, nil
+}
+
+func (p *RecordingControl) UnmarshalBinary(data []byte) error {
+	// Some firmwares accept recording commands without echoing the payload.
+	p.Start = len(data) > 0 && data[0] == 0x01
+	return nil
+}
+
+func (p *RecordingControl) String() string {
+	if p.Start {
+		return "start"
+	}
+	return "stop"
+}
+
+// Types of the 0xBCDE preview stream messages.
+const (
+	STREAM_FRAME_DATA = 0x0001
+	STREAM_FRAME_END  = 0x0002
+)
+
+// StreamMessageName returns the name of a preview stream message type.
+func StreamMessageName(messageType uint16) string {
+	switch messageType {
+	case STREAM_FRAME_DATA:
+		return "STREAM_FRAME_DATA"
+	case STREAM_FRAME_END:
+		return "STREAM_FRAME_END"
+	}
+	return fmt.Sprintf("0x%04X", messageType)
+}
+
+// FrameEnd is the payload of STREAM_FRAME_END. Elapsed is the presentation
+// time of the frame in milliseconds, the meaning of the leading bytes is unknown.
+type FrameEnd struct {
+	Unknown [12]byte
+	Elapsed uint32
+}
+
+func (p *FrameEnd) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 16)
+	copy(data, p.Unknown[:])
+	binary.LittleEndian.PutUint32(data[12:], p.Elapsed)
+	return data, nil
+}
+
+func (p *FrameEnd) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errShortPayload
+	}
+	copy(p.Unknown[:], data)
+	p.Elapsed = binary.LittleEndian.Uint32(data[12:])
+	return nil
+}
+
+func (p *FrameEnd) String() string {
+	return fmt.Sprintf("elapsed=%dms", p.Elapsed)
+}
+
+// ClockTime is the payload of SET_CLOCK and CLOCK_INFORMATION: the wall clock
+// as year (uint16), month, day, hour, minute and second, a padding byte and
+// the offset of the timezone from UTC in minutes (int16), all little endian.
+// The layout is a placeholder that was not observed on a real camera.
+type ClockTime struct {
+	Time time.Time
+}
+
+func (p *ClockTime) MarshalBinary() ([]byte, error) {
+	t := p.Time
+	_, offset := t.Zone()
+	data := make([]byte, 10)
+	binary.LittleEndian.PutUint16(data, uint16(t.Year()))
+	data[2] = byte(t.Month())
+	data[3] = byte(t.Day())
+	data[4] = byte(t.Hour())
+	data[5] = byte(t.Minute())
+	data[6] = byte(t.Second())
+	binary.LittleEndian.PutUint16(data[8:], uint16(int16(offset/60)))
+	return data, nil
+}
+
+func (p *ClockTime) UnmarshalBinary(data []byte) error {
+	if len(data) < 10 {
+		return errShortPayload
+	}
+	offset := int(int16(binary.LittleEndian.Uint16(data[8:]))) * 60
+	p.Time = time.Date(int(binary.LittleEndian.Uint16(data)), time.Month(data[2]), int(data[3]),
+		int(data[4]), int(data[5]), int(data[6]), 0, time.FixedZone("", offset))
+	return nil
+}
+
+func (p *ClockTime) String() string {
+	return p.Time.Format("2006-01-02 15:04:05 -07:00")
+}
+
+// FilePath is the payload of commands on a single file: the path on the SD
+// card, as listed by FILE_LIST_CONTENT, without terminator.
+type FilePath struct {
+	Path string
+}
+
+func (p *FilePath) MarshalBinary() ([]byte, error) {
+	return []byte(p.Path), nil
+}
+
+func (p *FilePath) UnmarshalBinary(data []byte) error {
+	p.Path = string(bytes.TrimRight(data, "\x00"))
+	return nil
+}
+
+func (p *FilePath) String() string {
+	return p.Path
+}
@@ -0,0 +1,280 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FirmwareVersion is the parsed FIRMWARE_INFORMATION string, e.g.
+// "SJ4000AIR HW2.1 V1.0.5 20200101": model, optional hardware revision,
+// firmware version and optional build date, separated by spaces or underscores.
+type FirmwareVersion struct {
+	Raw       string
+	Model     string
+	Hardware  string
+	Version   string
+	BuildDate time.Time
+}
+
+var (
+	firmwareVersionPattern = regexp.MustCompile(`^[vV]\d+(\.\d+)*[a-zA-Z]?$`)
+	firmwareDatePattern    = regexp.MustCompile(`^\d{8}$`)
+)
+
+// ParseFirmwareVersion parses the firmware string reported by the camera.
+// Strings without a version are returned with Raw and Model set and an error.
+func ParseFirmwareVersion(raw string) (FirmwareVersion, error) {
+	firmware := FirmwareVersion{Raw: raw}
+	fields := strings.FieldsFunc(strings.TrimSpace(raw), func(r rune) bool { return r == ' ' || r == '_' })
+	if len(fields) == 0 {
+		return firmware, fmt.Errorf("Versão de firmware vazia")
+	}
+	firmware.Model = fields[0]
+	for _, field := range fields[1:] {
+		switch {
+		case firmware.Version == "" && firmwareVersionPattern.MatchString(field):
+			firmware.Version = field[1:]
+		case firmware.Version != "" && firmware.BuildDate.IsZero() && firmwareDatePattern.MatchString(field):
+			date, err := time.Parse("20060102", field)
+			if err != nil {
+				return firmware, fmt.Errorf("Data de compilação inválida na versão de firmware %q", raw)
+			}
+			firmware.BuildDate = date
+		case firmware.Version == "" && firmware.Hardware == "":
+			firmware.Hardware = field
+		}
+	}
+	if firmware.Version == "" {
+		return firmware, fmt.Errorf("Versão de firmware não reconhecida: %q", raw)
+	}
+	return firmware, nil
+}
+
+func (f FirmwareVersion) String() string {
+	parts := []string{f.Model}
+	if f.Hardware != "" {
+		parts = append(parts, "hardware "+f.Hardware)
+	}
+	parts = append(parts, "firmware "+f.Version)
+	if !f.BuildDate.IsZero() {
+		parts = append(parts, "de "+f.BuildDate.Format("2006-01-02"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ModelProfile describes what a camera model supports.
+type ModelProfile struct {
+	// Model is matched against the model of the firmware version; the
+	// profile with the longest matching prefix is used.
+	Model string `json:"model"`
+	// Commands are the commands the model implements, empty for every
+	// command. LOGIN, keepalives and REQUEST_FIRMWARE_INFO are always allowed.
+	Commands []CommandID `json:"commands,omitempty"`
+	// PreviewCodecs are the codecs of the preview stream, e.g. "h264".
+	PreviewCodecs []string `json:"preview_codecs"`
+	// StreamPort is the local UDP port the model sends the preview stream to.
+	StreamPort int `json:"stream_port"`
+	// DiscoveryPorts are the UDP ports answering DISCOVERY_REQUEST.
+	DiscoveryPorts []int `json:"discovery_ports"`
+}
+
+// Supports reports whether the model implements command.
+func (p *ModelProfile) Supports(command uint32) bool {
+	switch command {
+	case LOGIN, ALIVE_REQUEST, ALIVE_RESPONSE, REQUEST_FIRMWARE_INFO:
+		return true
+	}
+	if len(p.Commands) == 0 {
+		return true
+	}
+	for _, supported := range p.Commands {
+		if uint32(supported) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether model belongs to the profile, that is whether the
+// profile's model is a case-insensitive prefix of it.
+func (p *ModelProfile) Matches(model string) bool {
+	return strings.HasPrefix(strings.ToUpper(model), strings.ToUpper(p.Model))
+}
+
+// GenericModelProfile is used for models without a registered profile. It
+// allows every command.
+func GenericModelProfile() ModelProfile {
+	return ModelProfile{
+		PreviewCodecs:  []string{"h264"},
+		StreamPort:     DefaultStreamPort,
+		DiscoveryPorts: []int{22600, 21600},
+	}
+}
+
+var (
+	profileMutex sync.RWMutex
+	profiles     = map[string]ModelProfile{}
+)
+
+func init() {
+	// The SJ4000AIR profile lists the commands observed in traffic of the
+	// official app.
+	profile := GenericModelProfile()
+	profile.Model = "SJ4000AIR"
+	profile.Commands = []CommandID{START_PREVIEW, REQUEST_FILE_LIST, TAKE_PICTURE, CONTROL_RECORDING}
+	RegisterModelProfile(profile)
+}
+
+// RegisterModelProfile registers profile, replacing a previous profile of the same model.
+func RegisterModelProfile(profile ModelProfile) {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	profiles[profile.Model] = profile
+}
+
+// LookupModelProfile returns the profile whose model is the longest prefix of
+// model, or GenericModelProfile and false.
+func LookupModelProfile(model string) (ModelProfile, bool) {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	var best *ModelProfile
+	for name := range profiles {
+		profile := profiles[name]
+		if profile.Matches(model) && (best == nil || len(name) > len(best.Model)) {
+			best = &profile
+		}
+	}
+	if best == nil {
+		return GenericModelProfile(), false
+	}
+	return *best, true
+}
+
+// ModelProfiles returns every registered profile ordered by model.
+func ModelProfiles() []ModelProfile {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	list := make([]ModelProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+	return list
+}
+
+// DiscoveryPorts returns the discovery ports of every registered profile
+// and of GenericModelProfile, without repetitions.
+func DiscoveryPorts() []int {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, profile := range append([]ModelProfile{GenericModelProfile()}, ModelProfiles()...) {
+		for _, port := range profile.DiscoveryPorts {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// LoadModelProfiles reads a JSON list of profiles and registers them.
+func LoadModelProfiles(r io.Reader) ([]ModelProfile, error) {
+	var list []ModelProfile
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&list); err != nil {
+		return nil, fmt.Errorf("Perfis de modelo inválidos: %w", err)
+	}
+	for _, profile := range list {
+		if profile.Model == "" {
+			return nil, fmt.Errorf("Perfis de modelo inválidos: perfil sem model")
+		}
+	}
+	for _, profile := range list {
+		RegisterModelProfile(profile)
+	}
+	return list, nil
+}
+
+// GetFirmware reads and parses the firmware version and selects the model
+// profile used for capability checks.
+func (c *Camera) GetFirmware() (FirmwareVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetFirmwareContext(ctx)
+}
+
+// GetFirmwareContext reads and parses the firmware version and selects the
+// model profile used for capability checks. The profile is left unchanged
+// when the version cannot be parsed.
+func (c *Camera) GetFirmwareContext(ctx context.Context) (FirmwareVersion, error) {
+	raw, err := c.GetFirmwareInfoContext(ctx)
+	if err != nil {
+		return FirmwareVersion{}, err
+	}
+	firmware, err := ParseFirmwareVersion(raw)
+	if err != nil {
+		return firmware, err
+	}
+	profile, known := LookupModelProfile(firmware.Model)
+	if !known {
+		c.log(LevelWarn, "Modelo sem perfil registrado, todos os comandos serão permitidos", F("model", firmware.Model))
+	}
+	c.SetModelProfile(&profile)
+	return firmware, nil
+}
+
+// SetModelProfile selects the profile used for capability checks, nil
+// disables the checks. GetFirmware selects it from the model reported by
+// the camera.
+func (c *Camera) SetModelProfile(profile *ModelProfile) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.profile = profile
+}
+
+// ModelProfile returns the profile used for capability checks, if any.
+func (c *Camera) ModelProfile() (ModelProfile, bool) {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	if c.profile == nil {
+		return ModelProfile{}, false
+	}
+	return *c.profile, true
+}
+
+// StreamPort returns the local UDP port the preview stream arrives on: the
+// StreamPort of the model profile, or DefaultStreamPort.
+func (c *Camera) StreamPort() int {
+	if profile, ok := c.ModelProfile(); ok && profile.StreamPort != 0 {
+		return profile.StreamPort
+	}
+	return DefaultStreamPort
+}
+
+// checkSupported returns an UnsupportedError when the model profile does not
+// list command. Profiles only list verified commands, so unverified commands
+// pass when experimental commands are enabled and checkVerified decides. The
+// commands of the settings table pass as well, the table describes the
+// settings of the camera.
+func (c *Camera) checkSupported(command uint32) error {
+	c.stateMutex.RLock()
+	profile := c.profile
+	experimental := c.experimental
+	c.stateMutex.RUnlock()
+	if experimental && !IsVerifiedCommand(command) {
+		return nil
+	}
+	if profile != nil && !profile.Supports(command) && !c.SettingsTable().command(command) {
+		return &UnsupportedError{Model: profile.Model, Command: command}
+	}
+	return nil
+}
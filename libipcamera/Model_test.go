@@ -0,0 +1,109 @@
+package libipcamera_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestParseFirmwareVersion(t *testing.T) {
+	cases := map[string]libipcamera.FirmwareVersion{
+		"SJ4000AIR V1.0 20200101":         {Model: "SJ4000AIR", Version: "1.0", BuildDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"SJ4000AIR_HW2.1_V1.0.5_20210315": {Model: "SJ4000AIR", Hardware: "HW2.1", Version: "1.0.5", BuildDate: time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC)},
+		"  SJ4000AIR-4K v2.3b  ":          {Model: "SJ4000AIR-4K", Version: "2.3b"},
+	}
+	for raw, expected := range cases {
+		firmware, err := libipcamera.ParseFirmwareVersion(raw)
+		expected.Raw = raw
+		if err != nil || firmware != expected {
+			t.Fatalf("ParseFirmwareVersion(%q) = %+v, %v", raw, firmware, err)
+		}
+	}
+
+	firmware, err := libipcamera.ParseFirmwareVersion("SJ4000AIR TEST")
+	if err == nil || firmware.Model != "SJ4000AIR" {
+		t.Fatalf("expected an error with the model, got %+v, %v", firmware, err)
+	}
+}
+
+func TestModelProfiles(t *testing.T) {
+	loaded, err := libipcamera.LoadModelProfiles(strings.NewReader(`[
+		{"model": "SJ4000AIR-LITE", "commands": ["0xA038", 41018], "preview_codecs": ["mjpeg"], "stream_port": 6670, "discovery_ports": [22600, 23600]}
+	]`))
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("LoadModelProfiles = %+v, %v", loaded, err)
+	}
+
+	profile, known := libipcamera.LookupModelProfile("SJ4000AIR-LITE2")
+	if !known || profile.Model != "SJ4000AIR-LITE" || profile.StreamPort != 6670 || len(profile.PreviewCodecs) != 1 || profile.PreviewCodecs[0] != "mjpeg" {
+		t.Fatalf("longest prefix not selected: %+v", profile)
+	}
+	if !profile.Supports(libipcamera.TAKE_PICTURE) || !profile.Supports(libipcamera.CONTROL_RECORDING) || !profile.Supports(libipcamera.LOGIN) || profile.Supports(libipcamera.FORMAT_CARD) {
+		t.Fatalf("unexpected capabilities %+v", profile.Commands)
+	}
+	if profile, known := libipcamera.LookupModelProfile("SJ4000AIR"); !known || !profile.Supports(libipcamera.START_PREVIEW) || profile.Supports(libipcamera.FORMAT_CARD) || profile.StreamPort != libipcamera.DefaultStreamPort {
+		t.Fatalf("built-in profile not found: %+v", profile)
+	}
+	if _, known := libipcamera.LookupModelProfile("OTHERCAM"); known {
+		t.Fatal("unknown model matched a profile")
+	}
+
+	ports := libipcamera.DiscoveryPorts()
+	if len(ports) != 3 || ports[0] != 22600 || ports[1] != 21600 || ports[2] != 23600 {
+		t.Fatalf("unexpected discovery ports %v", ports)
+	}
+}
+
+func TestModelCapabilities(t *testing.T) {
+	libipcamera.RegisterModelProfile(libipcamera.ModelProfile{Model: "TESTCAM", Commands: []libipcamera.CommandID{libipcamera.TAKE_PICTURE}, StreamPort: 7000})
+	emu := startEmulator(t, emulator.Config{Firmware: "TESTCAM HW1 V2.0 20210101"})
+	camera := connectClient(t, emu)
+
+	firmware, err := camera.GetFirmware()
+	if err != nil || firmware.Model != "TESTCAM" || firmware.Hardware != "HW1" || firmware.Version != "2.0" {
+		t.Fatalf("GetFirmware = %+v, %v", firmware, err)
+	}
+	if camera.StreamPort() != 7000 {
+		t.Fatalf("StreamPort = %d, expected the profile port", camera.StreamPort())
+	}
+	if err := camera.TakePicture(); err != nil {
+		t.Fatal(err)
+	}
+	var unsupported *libipcamera.UnsupportedError
+	if err := camera.StartPreviewStream(); !errors.As(err, &unsupported) || unsupported.Model != "TESTCAM" || !errors.Is(err, libipcamera.ErrUnsupported) {
+		t.Fatalf("expected UnsupportedError, got %v", err)
+	}
+	if err := camera.FormatCard(libipcamera.FormatConfirmationToken); !errors.Is(err, libipcamera.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if emu.Received(libipcamera.START_PREVIEW) != 0 || emu.Received(libipcamera.FORMAT_CARD) != 0 {
+		t.Fatal("unsupported command sent to the camera")
+	}
+
+	// Profiles only list verified commands; experimental mode lets the
+	// unverified ones through.
+	camera.EnableExperimentalCommands(true)
+	if err := camera.FormatCard(libipcamera.FormatConfirmationToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnparsedFirmwareKeepsProfile(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Firmware: "SJ4000AIR TEST"})
+	camera := connectClient(t, emu)
+
+	firmware, err := camera.GetFirmware()
+	if err == nil || firmware.Model != "SJ4000AIR" {
+		t.Fatalf("GetFirmware = %+v, %v", firmware, err)
+	}
+	if profile, ok := camera.ModelProfile(); ok {
+		t.Fatalf("profile %q selected from an unparsed version", profile.Model)
+	}
+	if camera.StreamPort() != libipcamera.DefaultStreamPort {
+		t.Fatalf("StreamPort = %d without a profile", camera.StreamPort())
+	}
+}
@@ -0,0 +1,219 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults of ProbeConfig.
+const (
+	DefaultProbeInterval = 200 * time.Millisecond
+	DefaultProbeWait     = 500 * time.Millisecond
+)
+
+// ProbeConfig selects the command IDs swept by Probe and how they are paced.
+type ProbeConfig struct {
+	// From and To are the first and last command IDs sent.
+	From, To uint32
+	// Payloads are sent with every command, one probe each. Empty sends a
+	// single probe without payload.
+	Payloads [][]byte
+	// Wait is how long replies are collected after each probe.
+	Wait time.Duration
+	// Interval is the pause between probes.
+	Interval time.Duration
+	// Skip lists commands never sent, nil selects DefaultProbeSkip.
+	Skip map[uint32]bool
+	// CheckCommand is sent after each probe to check that the camera still
+	// answers with CheckReply. Zero selects REQUEST_FIRMWARE_INFO.
+	CheckCommand uint32
+	CheckReply   uint32
+	// OnResult is called after each probe, before the link is checked.
+	OnResult func(result ProbeResult)
+}
+
+// ProbeReply is a message received while waiting after a probe.
+type ProbeReply struct {
+	Type    uint32
+	Payload []byte
+	// After is the time elapsed since the probe was sent.
+	After time.Duration
+}
+
+// ProbeResult lists the replies to one probe. Keepalives are left out.
+type ProbeResult struct {
+	Command uint32
+	Payload []byte
+	Replies []ProbeReply
+}
+
+type probeReplyJSON struct {
+	Type        string  `json:"type"`
+	Name        string  `json:"name"`
+	Payload     string  `json:"payload"`
+	Description string  `json:"description"`
+	AfterMs     float64 `json:"after_ms"`
+}
+
+type probeResultJSON struct {
+	Command string           `json:"command"`
+	Name    string           `json:"name"`
+	Payload string           `json:"payload"`
+	Replies []probeReplyJSON `json:"replies"`
+}
+
+// MarshalJSON encodes the result with message types in hexadecimal, their
+// registered names and the payloads in hexadecimal.
+func (r ProbeResult) MarshalJSON() ([]byte, error) {
+	result := probeResultJSON{
+		Command: fmt.Sprintf("0x%04X", r.Command),
+		Name:    MessageName(r.Command),
+		Payload: hex.EncodeToString(r.Payload),
+		Replies: make([]probeReplyJSON, len(r.Replies)),
+	}
+	for i, reply := range r.Replies {
+		result.Replies[i] = probeReplyJSON{
+			Type:        fmt.Sprintf("0x%04X", reply.Type),
+			Name:        MessageName(reply.Type),
+			Payload:     hex.EncodeToString(reply.Payload),
+			Description: reply.Message().String(),
+			AfterMs:     float64(reply.After) / float64(time.Millisecond),
+		}
+	}
+	return json.Marshal(result)
+}
+
+// Message returns the reply as a received message.
+func (r ProbeReply) Message() *Message {
+	return &Message{Header: Header{Magic: 0xABCD, Length: uint16(len(r.Payload)), MessageType: r.Type}, Payload: r.Payload}
+}
+
+// ProbeError is returned by Probe when the camera stops answering after Command.
+type ProbeError struct {
+	Command uint32
+	Err     error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("A câmera parou de responder após o comando %s: %s", MessageName(e.Command), e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultProbeSkip returns the commands Probe does not send by default: LOGIN
+// and the placeholder IDs given to DELETE_FILE, FORMAT_CARD and SET_CLOCK.
+// Those IDs are guesses, the opcodes that erase files or change settings on
+// a real camera are unknown and are still sent if they fall in the swept
+// range, so the list offers no protection against destructive commands.
+func DefaultProbeSkip() map[uint32]bool {
+	return map[uint32]bool{LOGIN: true, DELETE_FILE: true, FORMAT_CARD: true, SET_CLOCK: true}
+}
+
+// Probe sends every command ID from config.From to config.To and records the
+// messages received after each one, to map commands missing from the
+// protocol. After each probe the link is checked with config.CheckCommand; if
+// the connection is lost or the check fails, Probe stops and returns the
+// results so far with a ProbeError naming the last command sent.
+func (c *Camera) Probe(ctx context.Context, config ProbeConfig) ([]ProbeResult, error) {
+	if !c.IsLoggedIn() {
+		return nil, ErrNotLoggedIn
+	}
+	if config.Wait <= 0 {
+		config.Wait = DefaultProbeWait
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultProbeInterval
+	}
+	if config.Skip == nil {
+		config.Skip = DefaultProbeSkip()
+	}
+	if config.CheckCommand == 0 {
+		config.CheckCommand, config.CheckReply = REQUEST_FIRMWARE_INFO, FIRMWARE_INFORMATION
+	}
+	payloads := config.Payloads
+	if len(payloads) == 0 {
+		payloads = [][]byte{nil}
+	}
+
+	var mutex sync.Mutex
+	var collecting *ProbeResult
+	var sent time.Time
+	stop := c.Observe(func(message *Message) {
+		messageType := message.Header.MessageType
+		if messageType == ALIVE_REQUEST || messageType == ALIVE_RESPONSE {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if collecting != nil {
+			reply := ProbeReply{Type: messageType, Payload: append([]byte(nil), message.Payload...), After: time.Since(sent)}
+			collecting.Replies = append(collecting.Replies, reply)
+		}
+	})
+	defer stop()
+
+	var results []ProbeResult
+	for command := uint64(config.From); command <= uint64(config.To); command++ {
+		if config.Skip[uint32(command)] {
+			continue
+		}
+		for _, payload := range payloads {
+			result := ProbeResult{Command: uint32(command), Payload: payload}
+
+			mutex.Lock()
+			collecting, sent = &result, time.Now()
+			mutex.Unlock()
+			c.log(LevelDebug, "Sondando comando", F("command", MessageName(result.Command)), F("payload", len(payload)))
+			err := c.SendPacket(CreatePacket(CreateCommandHeader(result.Command), payload))
+			if err == nil {
+				err = sleepContext(ctx, config.Wait)
+			}
+			mutex.Lock()
+			collecting = nil
+			mutex.Unlock()
+
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			results = append(results, result)
+			if config.OnResult != nil {
+				config.OnResult(result)
+			}
+			if err == nil {
+				err = c.checkProbeLink(ctx, config)
+			}
+			if err != nil {
+				return results, &ProbeError{Command: result.Command, Err: err}
+			}
+			if err := sleepContext(ctx, config.Interval); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+func (c *Camera) checkProbeLink(ctx context.Context, config ProbeConfig) error {
+	if !c.IsLoggedIn() {
+		return ErrDisconnected
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+	defer cancel()
+	_, err := c.Request(checkCtx, config.CheckCommand, nil, config.CheckReply)
+	return err
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
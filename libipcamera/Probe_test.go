@@ -0,0 +1,87 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestProbe(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	emu.Handle(0xA033, func(payload []byte) (uint32, []byte) {
+		return 0xA0F0, append([]byte{0x01}, payload...)
+	})
+
+	results, err := camera.Probe(context.Background(), libipcamera.ProbeConfig{
+		From:     0xA033,
+		To:       0xA036,
+		Payloads: [][]byte{nil, {0x02}},
+		Wait:     20 * time.Millisecond,
+		Interval: time.Millisecond,
+		Skip:     map[uint32]bool{0xA035: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 6 probes, got %+v", results)
+	}
+	if emu.Received(0xA035) != 0 {
+		t.Fatal("skipped command was sent")
+	}
+	for _, result := range results {
+		switch result.Command {
+		case 0xA033:
+			if len(result.Replies) != 1 || result.Replies[0].Type != 0xA0F0 || len(result.Replies[0].Payload) != 1+len(result.Payload) {
+				t.Fatalf("unexpected replies to 0xA033 with % X: %+v", result.Payload, result.Replies)
+			}
+		case libipcamera.REQUEST_FIRMWARE_INFO:
+			if len(result.Replies) != 1 || result.Replies[0].Type != libipcamera.FIRMWARE_INFORMATION || string(result.Replies[0].Payload) != defaultFirmware {
+				t.Fatalf("unexpected replies to REQUEST_FIRMWARE_INFO: %+v", result.Replies)
+			}
+		default:
+			if len(result.Replies) != 0 {
+				t.Fatalf("unexpected replies to 0x%04X: %+v", result.Command, result.Replies)
+			}
+		}
+	}
+}
+
+func TestProbeDefaultSkip(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	results, err := camera.Probe(context.Background(), libipcamera.ProbeConfig{From: libipcamera.LOGIN, To: libipcamera.LOGIN, Wait: time.Millisecond, Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 || emu.Received(libipcamera.LOGIN) != 1 {
+		t.Fatalf("LOGIN was probed: %+v", results)
+	}
+}
+
+func TestProbeDeadLink(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	emu.Handle(0xA040, func(payload []byte) (uint32, []byte) {
+		emu.DisconnectClients()
+		return 0xA041, nil
+	})
+
+	results, err := camera.Probe(context.Background(), libipcamera.ProbeConfig{From: 0xA040, To: 0xA04F, Wait: 20 * time.Millisecond})
+	var probeError *libipcamera.ProbeError
+	if !errors.As(err, &probeError) || probeError.Command != 0xA040 {
+		t.Fatalf("expected the probe to stop at 0xA040, got %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 probe before the link died, got %d", len(results))
+	}
+	if emu.Received(0xA041) != 0 {
+		t.Fatal("probe continued after the link died")
+	}
+}
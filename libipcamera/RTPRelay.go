@@ -5,48 +5,107 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
-	"log"
 	"net"
+	"sync"
 	"time"
 )
 
 type RTPRelay struct {
-	close      bool
 	targetIP   net.IP
 	targetPort int
 	listener   net.PacketConn
 	context    context.Context
-}
 
-var close bool
+	// stop is closed by Stop, done when the relay has ended.
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// mutex guards err, logger and tracer.
+	mutex  sync.Mutex
+	err    error
+	logger Logger
+	tracer Tracer
+}
 
+// DefaultStreamPort is the local UDP port the camera sends the preview stream
+// to when the model profile does not name one.
+const DefaultStreamPort = 6669
 
-func CreateRTPRelay(ctx context.Context, targetAddress net.IP, targetPort int) *RTPRelay {
-	conn, err := net.ListenPacket("udp", ":6669")
+func CreateRTPRelay(ctx context.Context, targetAddress net.IP, targetPort int) (*RTPRelay, error) {
+	return CreateRTPRelayOnPort(ctx, DefaultStreamPort, targetAddress, targetPort)
+}
 
+// CreateRTPRelayOnPort is CreateRTPRelay receiving the preview stream on
+// streamPort, see Camera.StreamPort.
+func CreateRTPRelayOnPort(ctx context.Context, streamPort int, targetAddress net.IP, targetPort int) (*RTPRelay, error) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", streamPort))
 	if err != nil {
-		log.Printf("ERRO: %s\n", err)
+		return nil, err
 	}
-	
-	close = false
-	relay := RTPRelay{
-		close: false,
+
+	relay := newRTPRelay(ctx, conn, targetAddress, targetPort)
+	go handleCameraStream(relay, conn)
+
+	return relay, nil
+}
+
+func newRTPRelay(ctx context.Context, conn net.PacketConn, targetAddress net.IP, targetPort int) *RTPRelay {
+	return &RTPRelay{
 		targetIP:   targetAddress,
 		targetPort: targetPort,
 		listener:   conn,
 		context:    ctx,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		logger:     defaultLogger(),
 	}
-	if err != nil {
-		log.Printf("ERRO: %s\n", err)
+}
+
+// Done returns a channel that is closed when the relay has ended, because
+// Stop was called, its context is done or it failed. Err then reports why
+// it failed.
+func (r *RTPRelay) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the first error the relay ran into, if any.
+func (r *RTPRelay) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+func (r *RTPRelay) setErr(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err == nil {
+		r.err = err
 	}
+}
 
-	go handleCameraStream(relay, conn)
+// SetLogger replaces the logger of the relay. Fields carrying credentials
+// are redacted before they reach logger.
+func (r *RTPRelay) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.logger = NewRedactingLogger(logger)
+}
 
-	return &relay
+func (r *RTPRelay) log(level Level, message string, fields ...Field) {
+	r.mutex.Lock()
+	logger := r.logger
+	r.mutex.Unlock()
+	logger.Log(level, message, fields...)
 }
 
-func handleCameraStream(relay RTPRelay, conn net.PacketConn) {
+func handleCameraStream(relay *RTPRelay, conn net.PacketConn) {
+	defer close(relay.done)
 	buffer := make([]byte, 2048)
 	packetReader := bytes.NewReader(buffer)
 
@@ -60,7 +119,10 @@ func handleCameraStream(relay RTPRelay, conn net.PacketConn) {
 	rtpSource, _ := net.ResolveUDPAddr("udp", "127.0.0.1")
 	rtpConn, err := net.DialUDP("udp", rtpSource, &rtpTarget)
 	if err != nil {
-		log.Printf("ERRO ao criar remetente RTP: %s\n", err)
+		relay.log(LevelError, "ERRO ao criar remetente RTP", F("error", err))
+		relay.setErr(err)
+		relay.listener.Close()
+		return
 	}
 
 	var sequenceNumber uint16
@@ -68,71 +130,79 @@ func handleCameraStream(relay RTPRelay, conn net.PacketConn) {
 
 	frameBuffer := bytes.Buffer{}
 	packetBuffer := bytes.Buffer{}
-	T:
-		for {
-			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-			
-			select {
-			case <-relay.context.Done():
-				log.Println("Contexto Feito")
-				rtpConn.Close()
-				relay.listener.Close()
-				break T
-			default:
-				if close {
-					rtpConn.Close()
-					relay.listener.Close()
-					break T
-				}
-				
-				conn.ReadFrom(buffer)
-				packetReader.Reset(buffer)
+T:
+	for {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		select {
+		case <-relay.context.Done():
+			relay.log(LevelDebug, "Contexto Feito")
+			rtpConn.Close()
+			relay.listener.Close()
+			break T
+		case <-relay.stop:
+			rtpConn.Close()
+			break T
+		default:
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil || n == 0 {
+				break
+			}
+			relay.trace(buffer[:n])
+			packetReader.Reset(buffer[:n])
 
-				binary.Read(packetReader, binary.BigEndian, &header)
+			binary.Read(packetReader, binary.BigEndian, &header)
 
-				if header.Magic != 0xBCDE {
-					log.Printf("Mensagem recebida como inválida (%x).", header.Magic)
-					break
-				}
+			if header.Magic != 0xBCDE {
+				relay.log(LevelError, "Mensagem recebida como inválida", F("magic", fmt.Sprintf("0x%X", header.Magic)))
+				relay.setErr(&ProtocolError{Header: header.toHeader(), Reason: "magic inválido no fluxo de visualização"})
+				break
+			}
 
-				if header.Length > 0 {
-					payload = make([]byte, header.Length)
-					_, err := io.ReadFull(packetReader, payload)
-					if err != nil {
-						log.Printf("Erro de leitura: %s\n", err)
-						break
-					}
-				} else {
-					payload = []byte{}
+			if header.Length > 0 {
+				payload = make([]byte, header.Length)
+				_, err := io.ReadFull(packetReader, payload)
+				if err != nil {
+					relay.log(LevelError, "Erro de leitura", F("error", err))
+					relay.setErr(&ProtocolError{Header: header.toHeader(), Reason: "carga útil incompleta no fluxo de visualização"})
+					break
 				}
+			} else {
+				payload = []byte{}
+			}
 
-				switch header.MessageType {
-				case 0x0001: 
-					frameBuffer.Write(payload)
-				case 0x0002: 
-					
-					packetBuffer.Write(frameBuffer.Bytes())
-					rtpConn.Write(packetBuffer.Bytes())
-					packetBuffer.Reset()
-					packetBuffer.Write([]byte{0x80, 0x63})
-					binary.Write(&packetBuffer, binary.BigEndian, sequenceNumber+1)
-					binary.Write(&packetBuffer, binary.BigEndian, (uint32)(elapsed)*90)
-					binary.Write(&packetBuffer, binary.BigEndian, (uint64(0)))
-					frameBuffer.Reset()
-					sequenceNumber++
-
-					elapsed = binary.LittleEndian.Uint32(payload[12:])
-				default:
-					log.Printf("Mensagem desconhecida recebida: %+v\n", header)
-					log.Printf("Carga útil:\n%s\n", hex.Dump(payload))
+			switch header.MessageType {
+			case STREAM_FRAME_DATA:
+				frameBuffer.Write(payload)
+			case STREAM_FRAME_END:
+
+				packetBuffer.Write(frameBuffer.Bytes())
+				rtpConn.Write(packetBuffer.Bytes())
+				packetBuffer.Reset()
+				packetBuffer.Write([]byte{0x80, 0x63})
+				binary.Write(&packetBuffer, binary.BigEndian, sequenceNumber+1)
+				binary.Write(&packetBuffer, binary.BigEndian, (uint32)(elapsed)*90)
+				binary.Write(&packetBuffer, binary.BigEndian, (uint64(0)))
+				frameBuffer.Reset()
+				sequenceNumber++
+
+				frameEnd := FrameEnd{}
+				if err := frameEnd.UnmarshalBinary(payload); err != nil {
+					relay.log(LevelWarn, "Fim de quadro inválido", F("error", err))
+					break
 				}
+				elapsed = frameEnd.Elapsed
+			default:
+				relay.log(LevelWarn, "Mensagem desconhecida recebida", F("type", StreamMessageName(header.MessageType)), F("header", fmt.Sprintf("%+v", header)), F("payload", "\n"+hex.Dump(payload)))
 			}
 		}
+	}
 }
 
-
+// Stop ends the relay and closes its socket.
 func (r *RTPRelay) Stop() {
-	close = true
-	r.close = true
-	r.listener.Close()
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.listener.Close()
+	})
 }
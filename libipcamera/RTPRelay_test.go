@@ -0,0 +1,116 @@
+package libipcamera
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestRelay starts a relay on a local port forwarding to a local receiver.
+func startTestRelay(t *testing.T, ctx context.Context) (*RTPRelay, net.Addr, *net.UDPConn) {
+	t.Helper()
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { receiver.Close() })
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := receiver.LocalAddr().(*net.UDPAddr)
+	relay := newRTPRelay(ctx, conn, target.IP, target.Port)
+	relay.SetLogger(NopLogger())
+	go handleCameraStream(relay, conn)
+	t.Cleanup(relay.Stop)
+	return relay, conn.LocalAddr(), receiver
+}
+
+// relayFrame sends a frame to the relay and reports whether it reached the receiver.
+func relayFrame(t *testing.T, relay net.Addr, receiver *net.UDPConn) bool {
+	t.Helper()
+	sender, err := net.Dial("udp", relay.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	end, _ := (&FrameEnd{Elapsed: 40}).MarshalBinary()
+	sender.Write(StreamPacket(1, STREAM_FRAME_DATA, []byte{0, 0, 0, 1, 0x65}))
+	sender.Write(StreamPacket(2, STREAM_FRAME_END, end))
+
+	receiver.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buffer := make([]byte, 2048)
+	n, err := receiver.Read(buffer)
+	return err == nil && n > 0
+}
+
+func waitDone(t *testing.T, relay *RTPRelay) {
+	t.Helper()
+	select {
+	case <-relay.Done():
+	case <-time.After(time.Second):
+		t.Fatal("relay did not end")
+	}
+}
+
+func TestRelayStopIsPerRelay(t *testing.T) {
+	first, _, _ := startTestRelay(t, context.Background())
+	second, address, receiver := startTestRelay(t, context.Background())
+
+	first.Stop()
+	first.Stop()
+	waitDone(t, first)
+	if !relayFrame(t, address, receiver) {
+		t.Fatal("stopping one relay stopped the other")
+	}
+	select {
+	case <-second.Done():
+		t.Fatal("second relay ended")
+	default:
+	}
+	if err := first.Err(); err != nil {
+		t.Fatalf("stopped relay reported %v", err)
+	}
+}
+
+func TestRelayReportsProtocolErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	relay, address, _ := startTestRelay(t, ctx)
+
+	sender, err := net.Dial("udp", address.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	packet := StreamPacket(1, STREAM_FRAME_DATA, nil)
+	packet[0] = 0
+	sender.Write(packet)
+
+	WaitFor(t, func() bool { return relay.Err() != nil })
+	if _, ok := relay.Err().(*ProtocolError); !ok {
+		t.Fatalf("expected *ProtocolError, got %v", relay.Err())
+	}
+	cancel()
+	relay.Stop()
+	waitDone(t, relay)
+}
+
+type recordingTracer struct {
+	records []TraceRecord
+}
+
+func (t *recordingTracer) Trace(record TraceRecord) {
+	t.records = append(t.records, record)
+}
+
+func TestRelayTap(t *testing.T) {
+	relay := &RTPRelay{logger: NopLogger()}
+	tracer := &recordingTracer{}
+	relay.SetTracer(tracer)
+	relay.trace(StreamPacket(1, STREAM_FRAME_DATA, []byte{1, 2, 3}))
+
+	if len(tracer.records) != 1 || tracer.records[0].Protocol != TraceStream || tracer.records[0].Direction != TraceReceived {
+		t.Fatalf("unexpected records %+v", tracer.records)
+	}
+}
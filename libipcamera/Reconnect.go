@@ -0,0 +1,240 @@
+package libipcamera
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReconnectPolicy configures the supervisor enabled with Camera.EnableReconnect.
+// Zero values are replaced by the values of DefaultReconnectPolicy.
+type ReconnectPolicy struct {
+	InitialDelay   time.Duration
+	MaxDelay       time.Duration
+	Multiplier     float64
+	AttemptTimeout time.Duration
+
+	// MaxAttempts limits the attempts per outage, zero retries forever.
+	MaxAttempts int
+
+	// OnEvent is called from the supervisor goroutine for every transition.
+	OnEvent func(event ReconnectEvent)
+}
+
+// DefaultReconnectPolicy returns the policy used for unset ReconnectPolicy fields.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay:   500 * time.Millisecond,
+		MaxDelay:       30 * time.Second,
+		Multiplier:     2,
+		AttemptTimeout: 10 * time.Second,
+	}
+}
+
+type ReconnectEventType int
+
+const (
+	ReconnectLost ReconnectEventType = iota
+	ReconnectAttempt
+	ReconnectFailed
+	ReconnectRestored
+	ReconnectGaveUp
+)
+
+func (t ReconnectEventType) String() string {
+	switch t {
+	case ReconnectLost:
+		return "conexão perdida"
+	case ReconnectAttempt:
+		return "tentando reconectar"
+	case ReconnectFailed:
+		return "falha ao reconectar"
+	case ReconnectRestored:
+		return "conexão restabelecida"
+	case ReconnectGaveUp:
+		return "reconexão abandonada"
+	}
+	return fmt.Sprintf("ReconnectEventType(%d)", int(t))
+}
+
+// ReconnectEvent describes a transition of the reconnect supervisor.
+type ReconnectEvent struct {
+	Type    ReconnectEventType
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+func (e ReconnectEvent) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (tentativa %d): %s", e.Type, e.Attempt, e.Err)
+	}
+	return fmt.Sprintf("%s (tentativa %d)", e.Type, e.Attempt)
+}
+
+// EnableReconnect starts supervising the connection. When the link to the
+// camera drops, the camera is dialed again with exponential backoff, logged in
+// if it was logged in before and the preview stream is restarted if it was active.
+func (c *Camera) EnableReconnect(policy ReconnectPolicy) {
+	defaults := DefaultReconnectPolicy()
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = defaults.InitialDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaults.MaxDelay
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = defaults.Multiplier
+	}
+	if policy.AttemptTimeout <= 0 {
+		policy.AttemptTimeout = defaults.AttemptTimeout
+	}
+
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.reconnectPolicy = &policy
+}
+
+// DisableReconnect stops the supervisor, aborting a reconnect in progress.
+func (c *Camera) DisableReconnect() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.reconnectPolicy = nil
+	c.endReconnect()
+}
+
+// beginReconnect makes a new run the owner of the reconnection and returns
+// its context, cancelled by endReconnect. stateMutex must be held.
+func (c *Camera) beginReconnect(parent context.Context) (context.Context, uint64) {
+	ctx, cancel := context.WithCancel(parent)
+	c.reconnectCancel = cancel
+	c.reconnectRun++
+	c.reconnectDone = make(chan struct{})
+	return ctx, c.reconnectRun
+}
+
+// endReconnect cancels the reconnect in progress, if any, and wakes those
+// waiting for it to end. stateMutex must be held.
+func (c *Camera) endReconnect() {
+	if c.reconnectCancel == nil {
+		return
+	}
+	c.reconnectCancel()
+	c.reconnectCancel = nil
+	close(c.reconnectDone)
+}
+
+// connectionLost starts the supervisor when reconnecting is enabled and the
+// connection was not closed on purpose.
+func (c *Camera) connectionLost(cause error) {
+	c.stateMutex.Lock()
+	policy := c.reconnectPolicy
+	if policy == nil || c.disconnect || c.reconnectCancel != nil {
+		c.stateMutex.Unlock()
+		return
+	}
+	wasLoggedIn := c.wasLoggedIn
+	ctx, run := c.beginReconnect(context.Background())
+	c.stateMutex.Unlock()
+
+	go c.superviseReconnect(ctx, run, *policy, wasLoggedIn, cause)
+}
+
+// finishReconnect hands the supervision back to the read loop of the restored
+// connection. It reports false when that connection was already lost again,
+// as its loss was not supervised while run was still in progress.
+func (c *Camera) finishReconnect(run uint64) bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if !c.connected {
+		return false
+	}
+	if c.reconnectRun == run {
+		c.endReconnect()
+	}
+	return true
+}
+
+func (c *Camera) superviseReconnect(ctx context.Context, run uint64, policy ReconnectPolicy, login bool, cause error) {
+	defer func() {
+		c.stateMutex.Lock()
+		if c.reconnectRun == run {
+			c.endReconnect()
+		}
+		c.stateMutex.Unlock()
+	}()
+
+	report := func(event ReconnectEvent) {
+		c.log(LevelInfo, "Reconexão", F("event", event.Type), F("attempt", event.Attempt), F("error", event.Err))
+		if policy.OnEvent != nil {
+			policy.OnEvent(event)
+		}
+	}
+
+	report(ReconnectEvent{Type: ReconnectLost, Err: cause})
+
+	delay := policy.InitialDelay
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		report(ReconnectEvent{Type: ReconnectAttempt, Attempt: attempt, Delay: delay})
+		err := c.reestablish(ctx, policy.AttemptTimeout, login)
+		if err == nil {
+			if c.finishReconnect(run) {
+				report(ReconnectEvent{Type: ReconnectRestored, Attempt: attempt})
+				return
+			}
+			err = ErrDisconnected
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		report(ReconnectEvent{Type: ReconnectFailed, Attempt: attempt, Delay: delay, Err: err})
+
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+	c.log(LevelError, "Não foi possível reconectar à câmera", F("address", c.ipAddress), F("port", c.port))
+	report(ReconnectEvent{Type: ReconnectGaveUp, Attempt: policy.MaxAttempts, Err: cause})
+}
+
+// reestablish dials the camera again and restores the session state.
+func (c *Camera) reestablish(ctx context.Context, timeout time.Duration, login bool) error {
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := c.ConnectContext(attemptCtx)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		// Disconnect was called while dialing.
+		c.Disconnect()
+		return ctx.Err()
+	}
+
+	if login {
+		err = c.LoginContext(attemptCtx)
+		if err != nil {
+			c.closeConnection()
+			return err
+		}
+	}
+
+	c.stateMutex.RLock()
+	previewActive := c.previewActive
+	c.stateMutex.RUnlock()
+	if previewActive {
+		if err := c.StartPreviewStream(); err != nil {
+			c.closeConnection()
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package libipcamera_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestReconnectRestoresSession(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	events := make(chan libipcamera.ReconnectEvent, 16)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			events <- event
+		},
+	})
+	if err := camera.StartPreviewStream(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return emu.Received(libipcamera.START_PREVIEW) == 1 })
+
+	emu.DisconnectClients()
+
+	deadline := time.After(2 * time.Second)
+	for restored := false; !restored; {
+		select {
+		case event := <-events:
+			restored = event.Type == libipcamera.ReconnectRestored
+		case <-deadline:
+			t.Fatal("camera did not reconnect")
+		}
+	}
+
+	if !camera.IsConnected() || !camera.IsLoggedIn() {
+		t.Fatal("session not restored after reconnect")
+	}
+	if logins := emu.Received(libipcamera.LOGIN); logins != 2 {
+		t.Errorf("expected 2 logins, got %d", logins)
+	}
+	waitFor(t, func() bool { return emu.Received(libipcamera.START_PREVIEW) == 2 })
+	if err := camera.TakePicture(); err != nil {
+		t.Errorf("TakePicture after reconnect: %s", err)
+	}
+
+	aliveHandlers := libipcamera.HandlerCount(camera, libipcamera.ALIVE_REQUEST)
+	if aliveHandlers != 1 {
+		t.Errorf("expected a single ALIVE_REQUEST handler, got %d", aliveHandlers)
+	}
+}
+
+func TestDisconnectStopsReconnect(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	events := make(chan libipcamera.ReconnectEvent, 16)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			events <- event
+		},
+	})
+	camera.Disconnect()
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected reconnect event after Disconnect: %s", event)
+	default:
+	}
+}
+
+func TestReconnectSupervisesLossRightAfterRestore(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond})
+
+	// The first reconnect logs in and loses the connection at once.
+	emu.SetFaults(emulator.Faults{DisconnectAfterMessages: 1})
+	emu.DisconnectClients()
+	waitFor(t, func() bool { return emu.Received(libipcamera.LOGIN) >= 3 })
+	emu.SetFaults(emulator.Faults{})
+
+	waitFor(t, camera.IsLoggedIn)
+	if err := camera.TakePicture(); err != nil {
+		t.Fatalf("TakePicture after reconnect: %s", err)
+	}
+}
+
+func TestReconnectClosesConnectionWhenPreviewFails(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	if err := camera.StartPreviewStream(); err != nil {
+		t.Fatal(err)
+	}
+	// The preview can no longer be restarted after reconnecting.
+	camera.SetModelProfile(&libipcamera.ModelProfile{Model: "SJ4000AIR", Commands: []libipcamera.CommandID{libipcamera.TAKE_PICTURE}})
+
+	gaveUp := make(chan struct{})
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		MaxAttempts:  3,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			if event.Type == libipcamera.ReconnectGaveUp {
+				close(gaveUp)
+			}
+		},
+	})
+	emu.DisconnectClients()
+
+	select {
+	case <-gaveUp:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconnect did not give up")
+	}
+	if logins := emu.Received(libipcamera.LOGIN); logins != 4 {
+		t.Fatalf("expected 4 logins, got %d", logins)
+	}
+	waitFor(t, func() bool { return emu.Clients() == 0 })
+}
+
+func TestConnectClosesPreviousConnection(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	waitFor(t, func() bool { return emu.Clients() == 1 })
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return emu.Clients() == 1 })
+	if !camera.IsConnected() {
+		t.Fatal("camera disconnected by the end of the previous connection")
+	}
+}
@@ -0,0 +1,197 @@
+package libipcamera
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// pendingRequest is a command waiting for its reply. Replies are matched to
+// the oldest pending request expecting that message type, as the protocol
+// carries no request identifiers.
+type pendingRequest struct {
+	command    uint32
+	replyTypes []uint32
+	multipart  bool
+	parts      []*Message
+	sent       time.Time
+	done       chan struct{}
+	err        error
+}
+
+func (p *pendingRequest) accepts(messageType uint32) bool {
+	for _, replyType := range p.replyTypes {
+		if replyType == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// Request sends command with payload and waits for the first message of replyType.
+// It returns a *TimeoutError when ctx expires before the reply arrives.
+//
+// As replies carry no request identifier, they are matched first in, first
+// out: concurrent requests expecting the same reply type get the replies in
+// the order the commands were sent. A reply arriving after its request timed
+// out or was cancelled cannot be told apart from a reply to the next request
+// of the same type, so it is delivered to that request, or reported as an
+// unknown message when there is none. A reply matched before the cancellation
+// is noticed is returned instead of the cancellation error.
+func (c *Camera) Request(ctx context.Context, command uint32, payload []byte, replyType uint32) (*Message, error) {
+	parts, err := c.request(ctx, command, payload, []uint32{replyType}, false)
+	if err != nil {
+		return nil, err
+	}
+	return parts[0], nil
+}
+
+// RequestMultipart sends command with payload and collects every part of a
+// reply of replyType. Each part starts with two little endian uint32 values,
+// the number of parts and the index of the current part.
+func (c *Camera) RequestMultipart(ctx context.Context, command uint32, payload []byte, replyType uint32) ([]*Message, error) {
+	return c.request(ctx, command, payload, []uint32{replyType}, true)
+}
+
+func (c *Camera) request(ctx context.Context, command uint32, payload []byte, replyTypes []uint32, multipart bool) ([]*Message, error) {
+	if ctx.Value(skipModelCheckKey{}) == nil {
+		if err := c.checkSupported(command); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkVerified(command); err != nil {
+		return nil, err
+	}
+	pending := &pendingRequest{
+		command:    command,
+		replyTypes: replyTypes,
+		multipart:  multipart,
+		done:       make(chan struct{}),
+	}
+
+	if payload == nil {
+		payload = []byte{}
+	}
+
+	// Registering and sending under one lock keeps the order of the pending
+	// queue identical to the order the camera receives the commands in.
+	c.sendMutex.Lock()
+	pending.sent = time.Now()
+	c.addPending(pending)
+	err := c.SendPacket(CreatePacket(CreateCommandHeader(command), payload))
+	written := time.Now()
+	c.sendMutex.Unlock()
+	if err != nil {
+		c.removePending(pending)
+		return nil, &RequestError{Command: command, ReplyType: replyTypes[0], Err: err}
+	}
+	if hook, ok := ctx.Value(sentHookKey{}).(func(time.Time)); ok {
+		hook(written)
+	}
+
+	select {
+	case <-pending.done:
+		if pending.err != nil {
+			var protocolError *ProtocolError
+			if errors.As(pending.err, &protocolError) {
+				return nil, pending.err
+			}
+			return nil, &RequestError{Command: command, ReplyType: replyTypes[0], Err: pending.err}
+		}
+		return pending.parts, nil
+	case <-ctx.Done():
+		if !c.removePending(pending) {
+			// The reply raced the cancellation and has already been delivered.
+			<-pending.done
+			if pending.err == nil {
+				return pending.parts, nil
+			}
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &TimeoutError{Command: command, ReplyType: replyTypes[0]}
+		}
+		return nil, &RequestError{Command: command, ReplyType: replyTypes[0], Err: ctx.Err()}
+	}
+}
+
+type sentHookKey struct{}
+
+// withSentHook returns a copy of ctx making every request using it call hook
+// with the time its command was written to the connection.
+func withSentHook(ctx context.Context, hook func(written time.Time)) context.Context {
+	return context.WithValue(ctx, sentHookKey{}, hook)
+}
+
+type skipModelCheckKey struct{}
+
+// WithoutModelCheck returns a copy of ctx whose requests are sent whatever
+// the model profile lists, for exploring the protocol with raw command IDs.
+// Unverified placeholder commands still require experimental commands.
+func WithoutModelCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipModelCheckKey{}, true)
+}
+
+func (c *Camera) addPending(pending *pendingRequest) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	c.pending = append(c.pending, pending)
+}
+
+// removePending drops pending from the queue and reports whether it was still waiting.
+func (c *Camera) removePending(pending *pendingRequest) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	for i, p := range c.pending {
+		if p == pending {
+			c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// deliverPending hands message to the oldest request waiting for its type and
+// reports whether a request consumed it.
+func (c *Camera) deliverPending(message *Message) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	for i, pending := range c.pending {
+		if !pending.accepts(message.Header.MessageType) {
+			continue
+		}
+
+		if len(pending.parts) == 0 {
+			c.recordRoundTrip(time.Since(pending.sent))
+		}
+		pending.parts = append(pending.parts, message)
+		complete := true
+		if pending.multipart {
+			part := PartHeader{}
+			if err := part.unmarshal(message.Payload); err != nil {
+				pending.err = &ProtocolError{Header: message.Header, Reason: "parte sem contador de partes"}
+			} else {
+				complete = part.Last()
+			}
+		}
+
+		if complete || pending.err != nil {
+			c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+			close(pending.done)
+		}
+		return true
+	}
+	return false
+}
+
+// failPending aborts every request that is still waiting for a reply.
+func (c *Camera) failPending(err error) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	for _, pending := range c.pending {
+		pending.err = err
+		close(pending.done)
+	}
+	c.pending = nil
+}
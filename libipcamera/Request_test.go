@@ -0,0 +1,224 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Commands unknown to the camera, used to exercise the correlation engine.
+const (
+	testCommand      = 0xB000
+	testReply        = 0xB001
+	testOtherCommand = 0xB002
+	testOtherReply   = 0xB003
+)
+
+// pipePeer is the camera end of a net.Pipe, driven by the test.
+type pipePeer struct {
+	conn     net.Conn
+	received chan *Message
+}
+
+// pipeCamera returns a connected camera whose replies are sent by the test.
+func pipeCamera(t *testing.T) (*Camera, *pipePeer) {
+	t.Helper()
+	client, server := net.Pipe()
+	peer := &pipePeer{conn: server, received: make(chan *Message, 16)}
+	go func() {
+		defer close(peer.received)
+		for {
+			header := Header{}
+			if err := binary.Read(server, binary.BigEndian, &header); err != nil {
+				return
+			}
+			payload := make([]byte, header.Length)
+			if _, err := io.ReadFull(server, payload); err != nil {
+				return
+			}
+			peer.received <- &Message{Header: header, Payload: payload}
+		}
+	}()
+
+	camera, err := CreateCameraWithConn(client, "admin", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera.SetLogger(NopLogger())
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		camera.Disconnect()
+		server.Close()
+	})
+	return camera, peer
+}
+
+// next returns the next message sent by the camera.
+func (p *pipePeer) next(t *testing.T) *Message {
+	t.Helper()
+	select {
+	case message, ok := <-p.received:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("no message sent by the camera")
+	}
+	return nil
+}
+
+func (p *pipePeer) send(messageType uint32, payload []byte) {
+	p.conn.Write(CreatePacket(CreateCommandHeader(messageType), payload))
+}
+
+type requestResult struct {
+	message *Message
+	err     error
+}
+
+// startRequest sends command in the background once the peer received the
+// previous commands, so the order of the pending queue is known.
+func startRequest(t *testing.T, camera *Camera, peer *pipePeer, ctx context.Context, command, replyType uint32) <-chan requestResult {
+	t.Helper()
+	result := make(chan requestResult, 1)
+	go func() {
+		message, err := camera.Request(ctx, command, nil, replyType)
+		result <- requestResult{message, err}
+	}()
+	if received := peer.next(t); received.Header.MessageType != command {
+		t.Fatalf("camera sent 0x%04X, expected 0x%04X", received.Header.MessageType, command)
+	}
+	return result
+}
+
+func awaitResult(t *testing.T, result <-chan requestResult) requestResult {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("request did not return")
+	}
+	return requestResult{}
+}
+
+func TestRequestFIFOMatching(t *testing.T) {
+	camera, peer := pipeCamera(t)
+	ctx := context.Background()
+
+	first := startRequest(t, camera, peer, ctx, testCommand, testReply)
+	other := startRequest(t, camera, peer, ctx, testOtherCommand, testOtherReply)
+	second := startRequest(t, camera, peer, ctx, testCommand, testReply)
+
+	peer.send(testOtherReply, []byte("other"))
+	peer.send(testReply, []byte("1"))
+	peer.send(testReply, []byte("2"))
+
+	for i, expected := range []struct {
+		result  <-chan requestResult
+		payload string
+	}{{first, "1"}, {other, "other"}, {second, "2"}} {
+		r := awaitResult(t, expected.result)
+		if r.err != nil || string(r.message.Payload) != expected.payload {
+			t.Fatalf("request %d got %v, %v, expected %q", i, r.message, r.err, expected.payload)
+		}
+	}
+}
+
+func TestRequestMultipartReply(t *testing.T) {
+	camera, peer := pipeCamera(t)
+
+	result := make(chan []*Message, 1)
+	go func() {
+		parts, err := camera.RequestMultipart(context.Background(), testCommand, nil, testReply)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- parts
+	}()
+	peer.next(t)
+	for i := uint32(0); i < 3; i++ {
+		part := make([]byte, 8)
+		binary.LittleEndian.PutUint32(part, 3)
+		binary.LittleEndian.PutUint32(part[4:], i)
+		peer.send(testReply, part)
+	}
+
+	select {
+	case parts := <-result:
+		if len(parts) != 3 {
+			t.Fatalf("expected 3 parts, got %d", len(parts))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("multipart request did not complete")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	camera, peer := pipeCamera(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := awaitResult(t, startRequest(t, camera, peer, ctx, testCommand, testReply))
+
+	var timeout *TimeoutError
+	if !errors.As(r.err, &timeout) || timeout.Command != testCommand || timeout.ReplyType != testReply {
+		t.Fatalf("expected *TimeoutError for 0x%04X, got %v", testCommand, r.err)
+	}
+	if PendingCount(camera) != 0 {
+		t.Fatal("timed out request still pending")
+	}
+}
+
+func TestRequestStaleReply(t *testing.T) {
+	camera, peer := pipeCamera(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if r := awaitResult(t, startRequest(t, camera, peer, ctx, testCommand, testReply)); !errors.Is(r.err, ErrTimeout) {
+		t.Fatalf("expected a timeout, got %v", r.err)
+	}
+
+	// The late reply to the first request is taken by the next one.
+	next := startRequest(t, camera, peer, context.Background(), testCommand, testReply)
+	peer.send(testReply, []byte("late"))
+	peer.send(testReply, []byte("fresh"))
+	if r := awaitResult(t, next); r.err != nil || string(r.message.Payload) != "late" {
+		t.Fatalf("expected the late reply, got %v, %v", r.message, r.err)
+	}
+}
+
+func TestRequestCancellationRace(t *testing.T) {
+	camera, peer := pipeCamera(t)
+	reply := &Message{Header: CreateCommandHeader(testReply), Payload: []byte("reply")}
+
+	// A reply matched before the cancellation is noticed is returned.
+	ctx, cancel := context.WithCancel(context.Background())
+	result := startRequest(t, camera, peer, ctx, testCommand, testReply)
+	WaitFor(t, func() bool { return PendingCount(camera) == 1 })
+	if !camera.deliverPending(reply) {
+		t.Fatal("reply not matched to the pending request")
+	}
+	cancel()
+	if r := awaitResult(t, result); r.err != nil || string(r.message.Payload) != "reply" {
+		t.Fatalf("expected the reply, got %v, %v", r.message, r.err)
+	}
+
+	// A reply arriving after the request gave up matches nothing.
+	ctx, cancel = context.WithCancel(context.Background())
+	result = startRequest(t, camera, peer, ctx, testCommand, testReply)
+	cancel()
+	if r := awaitResult(t, result); !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", r.err)
+	}
+	if camera.deliverPending(reply) {
+		t.Fatal("reply matched to a cancelled request")
+	}
+}
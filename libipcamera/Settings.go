@@ -0,0 +1,473 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names of the settings in the default table.
+const (
+	SettingVideoResolution = "video_resolution"
+	SettingFrameRate       = "frame_rate"
+	SettingExposure        = "exposure"
+	SettingWhiteBalance    = "white_balance"
+	SettingLoopRecording   = "loop_recording"
+	SettingAudio           = "audio"
+	SettingDateStamp       = "date_stamp"
+)
+
+type VideoResolution string
+
+const (
+	Resolution4K    VideoResolution = "4k"
+	Resolution2K    VideoResolution = "2.7k"
+	Resolution1080p VideoResolution = "1080p"
+	Resolution720p  VideoResolution = "720p"
+)
+
+type FrameRate string
+
+const (
+	FrameRate24  FrameRate = "24fps"
+	FrameRate30  FrameRate = "30fps"
+	FrameRate60  FrameRate = "60fps"
+	FrameRate120 FrameRate = "120fps"
+)
+
+type Exposure string
+
+const (
+	ExposureMinus2 Exposure = "-2"
+	ExposureMinus1 Exposure = "-1"
+	Exposure0      Exposure = "0"
+	ExposurePlus1  Exposure = "+1"
+	ExposurePlus2  Exposure = "+2"
+)
+
+type WhiteBalance string
+
+const (
+	WhiteBalanceAuto        WhiteBalance = "auto"
+	WhiteBalanceDaylight    WhiteBalance = "daylight"
+	WhiteBalanceCloudy      WhiteBalance = "cloudy"
+	WhiteBalanceTungsten    WhiteBalance = "tungsten"
+	WhiteBalanceFluorescent WhiteBalance = "fluorescent"
+)
+
+type LoopRecording string
+
+const (
+	LoopRecordingOff LoopRecording = "off"
+	LoopRecording1   LoopRecording = "1min"
+	LoopRecording3   LoopRecording = "3min"
+	LoopRecording5   LoopRecording = "5min"
+)
+
+// CommandID is a message type in a settings table. In JSON it is either a
+// number or a string such as "0xA050".
+type CommandID uint32
+
+func (id CommandID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("0x%04X", uint32(id)))
+}
+
+func (id *CommandID) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number uint32
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("ID de comando inválido %s", data)
+		}
+		*id = CommandID(number)
+		return nil
+	}
+	value, err := strconv.ParseUint(text, 0, 32)
+	if err != nil {
+		return fmt.Errorf("ID de comando inválido %q", text)
+	}
+	*id = CommandID(value)
+	return nil
+}
+
+// SettingValue is one of the enumerated values of a setting.
+type SettingValue struct {
+	Name  string `json:"name"`
+	Value uint32 `json:"value"`
+}
+
+// Setting describes how a setting is read and written. The current value is
+// read by sending Get, answered by GetReply with the value as little endian
+// uint32. Set carries the new value and is answered by SetReply echoing the
+// value the camera applied. Replies default to the command ID plus one.
+type Setting struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Get         CommandID      `json:"get"`
+	GetReply    CommandID      `json:"get_reply,omitempty"`
+	Set         CommandID      `json:"set"`
+	SetReply    CommandID      `json:"set_reply,omitempty"`
+	Values      []SettingValue `json:"values"`
+}
+
+func (s *Setting) getReply() uint32 {
+	if s.GetReply != 0 {
+		return uint32(s.GetReply)
+	}
+	return uint32(s.Get) + 1
+}
+
+func (s *Setting) setReply() uint32 {
+	if s.SetReply != 0 {
+		return uint32(s.SetReply)
+	}
+	return uint32(s.Set) + 1
+}
+
+// Value returns the enumerated value called name.
+func (s *Setting) Value(name string) (SettingValue, bool) {
+	for _, value := range s.Values {
+		if strings.EqualFold(value.Name, name) {
+			return value, true
+		}
+	}
+	return SettingValue{}, false
+}
+
+// ValueOf returns the enumerated value of the raw value reported by the camera.
+// Values missing from the table are named after their number.
+func (s *Setting) ValueOf(raw uint32) SettingValue {
+	for _, value := range s.Values {
+		if value.Value == raw {
+			return value
+		}
+	}
+	return SettingValue{Name: strconv.FormatUint(uint64(raw), 10), Value: raw}
+}
+
+// ValueNames returns the names of the enumerated values.
+func (s *Setting) ValueNames() []string {
+	names := make([]string, len(s.Values))
+	for i, value := range s.Values {
+		names[i] = value.Name
+	}
+	return names
+}
+
+// SettingsTable maps setting names to their command IDs and values, so newly
+// reverse engineered settings can be added with LoadSettingsTable instead of
+// changing the library.
+type SettingsTable struct {
+	Settings []Setting `json:"settings"`
+}
+
+// Lookup returns the setting called name.
+func (t *SettingsTable) Lookup(name string) (*Setting, bool) {
+	for i := range t.Settings {
+		if strings.EqualFold(t.Settings[i].Name, name) {
+			return &t.Settings[i], true
+		}
+	}
+	return nil, false
+}
+
+// command reports whether command is the get or set command of a setting.
+func (t *SettingsTable) command(command uint32) bool {
+	for i := range t.Settings {
+		if uint32(t.Settings[i].Get) == command || uint32(t.Settings[i].Set) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns a copy of the table with the settings of other added,
+// replacing settings of the same name.
+func (t *SettingsTable) Merge(other *SettingsTable) *SettingsTable {
+	merged := &SettingsTable{Settings: append([]Setting(nil), t.Settings...)}
+	for _, setting := range other.Settings {
+		if existing, ok := merged.Lookup(setting.Name); ok {
+			*existing = setting
+		} else {
+			merged.Settings = append(merged.Settings, setting)
+		}
+	}
+	return merged
+}
+
+// registerMessageTypes registers the commands of every setting, so they are
+// named and decoded in logs and traces. Only the built-in table is
+// registered; the names of a loaded table stay in the table, so they cannot
+// rename other message types.
+func (t *SettingsTable) registerMessageTypes() {
+	factory := func() Codec { return &Uint32Payload{} }
+	for i := range t.Settings {
+		setting := &t.Settings[i]
+		name := strings.ToUpper(setting.Name)
+		RegisterMessageType(uint32(setting.Get), "GET_"+name, emptyCodec)
+		RegisterMessageType(setting.getReply(), name+"_VALUE", factory)
+		RegisterMessageType(uint32(setting.Set), "SET_"+name, factory)
+		RegisterMessageType(setting.setReply(), name+"_APPLIED", factory)
+	}
+}
+
+func (t *SettingsTable) validate() error {
+	seen := make(map[string]bool)
+	for _, setting := range t.Settings {
+		name := strings.ToLower(setting.Name)
+		switch {
+		case name == "":
+			return fmt.Errorf("Configuração sem nome na tabela")
+		case seen[name]:
+			return fmt.Errorf("Configuração %s repetida na tabela", setting.Name)
+		case setting.Get == 0 || setting.Set == 0:
+			return fmt.Errorf("Configuração %s sem IDs de comando", setting.Name)
+		case len(setting.Values) == 0:
+			return fmt.Errorf("Configuração %s sem valores permitidos", setting.Name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// LoadSettingsTable reads a settings table in JSON, for example:
+//
+//	{"settings": [{"name": "video_resolution", "get": "0xA050", "set": "0xA052",
+//	  "values": [{"name": "4k", "value": 0}, {"name": "1080p", "value": 2}]}]}
+func LoadSettingsTable(r io.Reader) (*SettingsTable, error) {
+	table := &SettingsTable{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(table); err != nil {
+		return nil, fmt.Errorf("Tabela de configurações inválida: %w", err)
+	}
+	if err := table.validate(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func onOff() []SettingValue {
+	return []SettingValue{{Name: "off", Value: 0}, {Name: "on", Value: 1}}
+}
+
+var (
+	defaultSettingsOnce sync.Once
+	defaultSettings     *SettingsTable
+)
+
+// DefaultSettingsTable returns a copy of the built-in table of the settings
+// offered by the SJ4000 app. Its command IDs (0xA050 to 0xA06B) and values are
+// placeholders that were not observed in traffic of a real camera; load a
+// table with the IDs captured from the app, e.g. with a Tracer, through
+// LoadSettingsTable before changing settings on a camera.
+func DefaultSettingsTable() *SettingsTable {
+	defaultSettingsOnce.Do(func() {
+		defaultSettings = &SettingsTable{Settings: []Setting{
+			{Name: SettingVideoResolution, Description: "Resolução de vídeo", Get: 0xA050, Set: 0xA052, Values: []SettingValue{
+				{Name: string(Resolution4K), Value: 0}, {Name: string(Resolution2K), Value: 1},
+				{Name: string(Resolution1080p), Value: 2}, {Name: string(Resolution720p), Value: 3},
+			}},
+			{Name: SettingFrameRate, Description: "Taxa de quadros", Get: 0xA054, Set: 0xA056, Values: []SettingValue{
+				{Name: string(FrameRate24), Value: 0}, {Name: string(FrameRate30), Value: 1},
+				{Name: string(FrameRate60), Value: 2}, {Name: string(FrameRate120), Value: 3},
+			}},
+			{Name: SettingExposure, Description: "Compensação de exposição", Get: 0xA058, Set: 0xA05A, Values: []SettingValue{
+				{Name: string(ExposureMinus2), Value: 0}, {Name: string(ExposureMinus1), Value: 1}, {Name: string(Exposure0), Value: 2},
+				{Name: string(ExposurePlus1), Value: 3}, {Name: string(ExposurePlus2), Value: 4},
+			}},
+			{Name: SettingWhiteBalance, Description: "Balanço de branco", Get: 0xA05C, Set: 0xA05E, Values: []SettingValue{
+				{Name: string(WhiteBalanceAuto), Value: 0}, {Name: string(WhiteBalanceDaylight), Value: 1}, {Name: string(WhiteBalanceCloudy), Value: 2},
+				{Name: string(WhiteBalanceTungsten), Value: 3}, {Name: string(WhiteBalanceFluorescent), Value: 4},
+			}},
+			{Name: SettingLoopRecording, Description: "Gravação em loop", Get: 0xA060, Set: 0xA062, Values: []SettingValue{
+				{Name: string(LoopRecordingOff), Value: 0}, {Name: string(LoopRecording1), Value: 1},
+				{Name: string(LoopRecording3), Value: 2}, {Name: string(LoopRecording5), Value: 3},
+			}},
+			{Name: SettingAudio, Description: "Gravação de áudio", Get: 0xA064, Set: 0xA066, Values: onOff()},
+			{Name: SettingDateStamp, Description: "Carimbo de data no vídeo", Get: 0xA068, Set: 0xA06A, Values: onOff()},
+		}}
+	})
+	return defaultSettings.Merge(&SettingsTable{})
+}
+
+// The commands of the built-in table are placeholders, sent only with
+// experimental commands enabled.
+func init() {
+	table := DefaultSettingsTable()
+	table.registerMessageTypes()
+	for _, setting := range table.Settings {
+		unverifiedCommands[uint32(setting.Get)] = true
+		unverifiedCommands[uint32(setting.Set)] = true
+	}
+}
+
+// SetSettingsTable replaces the settings table of the camera, which defaults
+// to DefaultSettingsTable. nil restores the default table. The commands of
+// the table are sent whatever the model profile lists.
+func (c *Camera) SetSettingsTable(table *SettingsTable) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.settings = table
+}
+
+// SettingsTable returns the settings table of the camera.
+func (c *Camera) SettingsTable() *SettingsTable {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	if c.settings == nil {
+		return DefaultSettingsTable()
+	}
+	return c.settings
+}
+
+func (c *Camera) lookupSetting(name string) (*Setting, error) {
+	setting, ok := c.SettingsTable().Lookup(name)
+	if !ok {
+		return nil, &SettingError{Setting: name}
+	}
+	return setting, nil
+}
+
+// GetSetting reads the current value of the setting called name.
+func (c *Camera) GetSetting(name string) (SettingValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetSettingContext(ctx, name)
+}
+
+// GetSettingContext reads the current value of the setting called name.
+func (c *Camera) GetSettingContext(ctx context.Context, name string) (SettingValue, error) {
+	if !c.IsLoggedIn() {
+		return SettingValue{}, ErrNotLoggedIn
+	}
+	setting, err := c.lookupSetting(name)
+	if err != nil {
+		return SettingValue{}, err
+	}
+
+	reply, err := c.Request(ctx, uint32(setting.Get), nil, setting.getReply())
+	if err != nil {
+		return SettingValue{}, err
+	}
+	value := Uint32Payload{}
+	if err := value.UnmarshalBinary(reply.Payload); err != nil {
+		return SettingValue{}, &ProtocolError{Header: reply.Header, Reason: err.Error()}
+	}
+	return setting.ValueOf(value.Value), nil
+}
+
+// SetSetting changes the setting called name to one of its enumerated values.
+func (c *Camera) SetSetting(name, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.SetSettingContext(ctx, name, value)
+}
+
+// SetSettingContext changes the setting called name to one of its enumerated
+// values. Values outside the enumeration are refused without contacting the camera.
+func (c *Camera) SetSettingContext(ctx context.Context, name, value string) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	setting, err := c.lookupSetting(name)
+	if err != nil {
+		return err
+	}
+	selected, ok := setting.Value(value)
+	if !ok {
+		return &SettingError{Setting: setting.Name, Value: value, Allowed: setting.ValueNames()}
+	}
+
+	payload, _ := (&Uint32Payload{Value: selected.Value}).MarshalBinary()
+	reply, err := c.Request(ctx, uint32(setting.Set), payload, setting.setReply())
+	if err != nil {
+		return err
+	}
+	applied := Uint32Payload{}
+	if applied.UnmarshalBinary(reply.Payload) == nil && applied.Value != selected.Value {
+		return &SettingError{Setting: setting.Name, Value: value, Rejected: true}
+	}
+	c.log(LevelDebug, "Configuração alterada", F("setting", setting.Name), F("value", selected.Name))
+	return nil
+}
+
+func (c *Camera) VideoResolution() (VideoResolution, error) {
+	value, err := c.GetSetting(SettingVideoResolution)
+	return VideoResolution(value.Name), err
+}
+
+func (c *Camera) SetVideoResolution(resolution VideoResolution) error {
+	return c.SetSetting(SettingVideoResolution, string(resolution))
+}
+
+func (c *Camera) FrameRate() (FrameRate, error) {
+	value, err := c.GetSetting(SettingFrameRate)
+	return FrameRate(value.Name), err
+}
+
+func (c *Camera) SetFrameRate(frameRate FrameRate) error {
+	return c.SetSetting(SettingFrameRate, string(frameRate))
+}
+
+func (c *Camera) Exposure() (Exposure, error) {
+	value, err := c.GetSetting(SettingExposure)
+	return Exposure(value.Name), err
+}
+
+func (c *Camera) SetExposure(exposure Exposure) error {
+	return c.SetSetting(SettingExposure, string(exposure))
+}
+
+func (c *Camera) WhiteBalance() (WhiteBalance, error) {
+	value, err := c.GetSetting(SettingWhiteBalance)
+	return WhiteBalance(value.Name), err
+}
+
+func (c *Camera) SetWhiteBalance(whiteBalance WhiteBalance) error {
+	return c.SetSetting(SettingWhiteBalance, string(whiteBalance))
+}
+
+func (c *Camera) LoopRecording() (LoopRecording, error) {
+	value, err := c.GetSetting(SettingLoopRecording)
+	return LoopRecording(value.Name), err
+}
+
+func (c *Camera) SetLoopRecording(loop LoopRecording) error {
+	return c.SetSetting(SettingLoopRecording, string(loop))
+}
+
+func (c *Camera) AudioEnabled() (bool, error) {
+	return c.getSwitch(SettingAudio)
+}
+
+func (c *Camera) SetAudioEnabled(enabled bool) error {
+	return c.setSwitch(SettingAudio, enabled)
+}
+
+func (c *Camera) DateStampEnabled() (bool, error) {
+	return c.getSwitch(SettingDateStamp)
+}
+
+func (c *Camera) SetDateStampEnabled(enabled bool) error {
+	return c.setSwitch(SettingDateStamp, enabled)
+}
+
+func (c *Camera) getSwitch(name string) (bool, error) {
+	value, err := c.GetSetting(name)
+	if err != nil {
+		return false, err
+	}
+	return value.Value != 0, nil
+}
+
+func (c *Camera) setSwitch(name string, enabled bool) error {
+	if enabled {
+		return c.SetSetting(name, "on")
+	}
+	return c.SetSetting(name, "off")
+}
@@ -0,0 +1,128 @@
+package libipcamera_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestLoadSettingsTable(t *testing.T) {
+	table, err := libipcamera.LoadSettingsTable(strings.NewReader(`{"settings": [
+		{"name": "video_resolution", "get": "0xB000", "set": 45058, "set_reply": "0xB010",
+		 "values": [{"name": "4k", "value": 0}, {"name": "1440p", "value": 7}]},
+		{"name": "gyro", "get": "0xB004", "set": "0xB006", "values": [{"name": "off", "value": 0}, {"name": "on", "value": 1}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := libipcamera.DefaultSettingsTable().Merge(table)
+	if len(merged.Settings) != len(libipcamera.DefaultSettingsTable().Settings)+1 {
+		t.Fatalf("expected one additional setting, got %d", len(merged.Settings))
+	}
+	resolution, _ := merged.Lookup(libipcamera.SettingVideoResolution)
+	getReply, setReply := resolution.ReplyTypes()
+	if resolution.Get != 0xB000 || getReply != 0xB001 || resolution.Set != 0xB002 || setReply != 0xB010 {
+		t.Fatalf("unexpected command IDs %+v", resolution)
+	}
+	if value, ok := resolution.Value("1440P"); !ok || value.Value != 7 {
+		t.Fatalf("value lookup returned %+v, %v", value, ok)
+	}
+	if value := resolution.ValueOf(9); value.Name != "9" {
+		t.Fatalf("unknown raw value named %q", value.Name)
+	}
+
+	if original, _ := libipcamera.DefaultSettingsTable().Lookup(libipcamera.SettingVideoResolution); original.Get != 0xA050 {
+		t.Fatal("Merge modified the default table")
+	}
+	if libipcamera.MessageName(0xB004) != "0xB004" {
+		t.Fatal("loading a table must not register its commands")
+	}
+}
+
+func TestLoadSettingsTableRejectsInvalidTables(t *testing.T) {
+	tables := []string{
+		`{"settings": [{"name": "audio", "get": "0xB000", "set": "0xB002", "values": []}]}`,
+		`{"settings": [{"name": "audio", "get": "bogus", "set": "0xB002", "values": [{"name": "on", "value": 1}]}]}`,
+		`{"settings": [{"name": "audio", "set": "0xB002", "values": [{"name": "on", "value": 1}]}]}`,
+		`{"settings": [{"name": "a", "get": 1, "set": 2, "values": [{"name": "x"}]}, {"name": "A", "get": 3, "set": 4, "values": [{"name": "x"}]}]}`,
+		`{"settings": [], "unknown": true}`,
+	}
+	for _, table := range tables {
+		if _, err := libipcamera.LoadSettingsTable(strings.NewReader(table)); err == nil {
+			t.Fatalf("expected error for %s", table)
+		}
+	}
+}
+
+func TestSetSettingValidatesValues(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	err := camera.SetSetting(libipcamera.SettingWhiteBalance, "purple")
+	var settingError *libipcamera.SettingError
+	if !errors.As(err, &settingError) || !errors.Is(err, libipcamera.ErrInvalidSetting) || len(settingError.Allowed) == 0 {
+		t.Fatalf("expected SettingError with allowed values, got %v", err)
+	}
+	if _, err := camera.GetSetting("iso"); !errors.Is(err, libipcamera.ErrInvalidSetting) {
+		t.Fatalf("expected ErrInvalidSetting for unknown setting, got %v", err)
+	}
+	if emu.Received(0xA05E) != 0 {
+		t.Fatal("invalid value was sent to the camera")
+	}
+}
+
+func TestSettingsOnModelProfile(t *testing.T) {
+	loaded, err := libipcamera.LoadSettingsTable(strings.NewReader(`{"settings": [
+		{"name": "login", "get": "0xB004", "set": "0xB006", "values": [{"name": "off", "value": 0}, {"name": "on", "value": 1}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := libipcamera.DefaultSettingsTable().Merge(loaded)
+	emu := startEmulator(t, emulator.Config{Settings: table})
+	camera := connectClient(t, emu)
+	if _, err := camera.GetFirmware(); err != nil {
+		t.Fatal(err)
+	}
+	if profile, ok := camera.ModelProfile(); !ok || profile.Model != "SJ4000AIR" {
+		t.Fatalf("SJ4000AIR profile not selected: %+v", profile)
+	}
+
+	// The IDs of the built-in table are placeholders.
+	if _, err := camera.GetSetting(libipcamera.SettingAudio); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("GetSetting = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(0xA064) != 0 {
+		t.Fatal("placeholder setting command was sent")
+	}
+
+	// A loaded table extends what the profile allows, without renaming
+	// other message types.
+	camera.SetSettingsTable(table)
+	if err := camera.SetSetting("login", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := camera.GetSetting("login"); err != nil || value.Name != "on" {
+		t.Fatalf("GetSetting = %+v, %v", value, err)
+	}
+	if libipcamera.MessageName(libipcamera.LOGIN) != "LOGIN" || libipcamera.MessageName(0xB004) != "0xB004" {
+		t.Fatal("the settings table renamed message types")
+	}
+}
+
+func TestSetSettingsTableNilRestoresDefault(t *testing.T) {
+	camera, _ := libipcamera.CreateCamera(net.ParseIP("192.0.2.1"), 6666, "admin", "12345")
+	camera.SetSettingsTable(&libipcamera.SettingsTable{})
+	if _, ok := camera.SettingsTable().Lookup(libipcamera.SettingAudio); ok {
+		t.Fatal("empty table still has the default settings")
+	}
+	camera.SetSettingsTable(nil)
+	if _, ok := camera.SettingsTable().Lookup(libipcamera.SettingAudio); !ok {
+		t.Fatal("nil did not restore the default table")
+	}
+}
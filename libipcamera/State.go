@@ -0,0 +1,106 @@
+package libipcamera
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConnectionState is the lifecycle state of a Camera.
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateLoggedIn
+	StateStreaming
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateLoggedIn:
+		return "LoggedIn"
+	case StateStreaming:
+		return "Streaming"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// StateEvent describes a state transition. Err holds the error that caused
+// it, if any. A failed login is reported as a transition from Connected to
+// Connected carrying the error.
+type StateEvent struct {
+	From ConnectionState
+	To   ConnectionState
+	Err  error
+	Time time.Time
+}
+
+func (e StateEvent) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s -> %s: %s", e.From, e.To, e.Err)
+	}
+	return fmt.Sprintf("%s -> %s", e.From, e.To)
+}
+
+// stateSubscriberBuffer is the number of events buffered per subscriber.
+// Events are dropped for subscribers that fall further behind.
+const stateSubscriberBuffer = 32
+
+// State returns the current lifecycle state.
+func (c *Camera) State() ConnectionState {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	return c.state
+}
+
+// Subscribe returns a channel receiving every state transition from now on
+// and a function that cancels the subscription and closes the channel.
+func (c *Camera) Subscribe() (<-chan StateEvent, func()) {
+	events := make(chan StateEvent, stateSubscriberBuffer)
+
+	c.eventMutex.Lock()
+	c.nextSubscriberID++
+	id := c.nextSubscriberID
+	if c.subscribers == nil {
+		c.subscribers = make(map[uint64]chan StateEvent)
+	}
+	c.subscribers[id] = events
+	c.eventMutex.Unlock()
+
+	unsubscribe := func() {
+		c.eventMutex.Lock()
+		defer c.eventMutex.Unlock()
+		if _, ok := c.subscribers[id]; ok {
+			delete(c.subscribers, id)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+// setState moves the camera to state and notifies subscribers. Transitions
+// to the current state are only published when they carry an error.
+func (c *Camera) setState(state ConnectionState, err error) {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+
+	if c.state == state && err == nil {
+		return
+	}
+	event := StateEvent{From: c.state, To: state, Err: err, Time: time.Now()}
+	c.state = state
+
+	for _, subscriber := range c.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package libipcamera_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func nextEvent(t *testing.T, events <-chan libipcamera.StateEvent) libipcamera.StateEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no state event received")
+	}
+	return libipcamera.StateEvent{}
+}
+
+func TestStateTransitions(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	camera.SetVerbose(false)
+
+	events, unsubscribe := camera.Subscribe()
+	defer unsubscribe()
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.StartPreviewStream(); err != nil {
+		t.Fatal(err)
+	}
+	camera.Disconnect()
+
+	expected := []libipcamera.ConnectionState{libipcamera.StateConnecting, libipcamera.StateConnected, libipcamera.StateLoggedIn, libipcamera.StateStreaming, libipcamera.StateDisconnected}
+	from := libipcamera.StateDisconnected
+	for _, state := range expected {
+		event := nextEvent(t, events)
+		if event.From != from || event.To != state || event.Err != nil {
+			t.Fatalf("expected %s -> %s, got %s", from, state, event)
+		}
+		from = state
+	}
+	if camera.State() != libipcamera.StateDisconnected {
+		t.Fatalf("expected Disconnected, got %s", camera.State())
+	}
+}
+
+func TestStateLoginRejected(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	emu.SetFaults(emulator.Faults{RejectLogin: true})
+	camera, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	camera.SetVerbose(false)
+	defer camera.Disconnect()
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := camera.Subscribe()
+	defer unsubscribe()
+
+	if err := camera.Login(); err == nil {
+		t.Fatal("expected login to be rejected")
+	}
+	event := nextEvent(t, events)
+	if event.From != libipcamera.StateConnected || event.To != libipcamera.StateConnected || event.Err == nil {
+		t.Fatalf("expected rejected login event, got %s", event)
+	}
+}
+
+func TestStateConnectionLost(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	events, unsubscribe := camera.Subscribe()
+	defer unsubscribe()
+
+	emu.DisconnectClients()
+
+	event := nextEvent(t, events)
+	if event.To != libipcamera.StateDisconnected || event.Err == nil {
+		t.Fatalf("expected Disconnected with cause, got %s", event)
+	}
+}
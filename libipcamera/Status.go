@@ -0,0 +1,229 @@
+package libipcamera
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// CameraStatus is the payload of STATUS_INFORMATION: battery level, charging
+// and card presence and recording flags as single bytes, followed by the
+// capacity and free space of the SD card in bytes (uint64) and the seconds
+// recorded so far (uint32), all little endian. The layout is a placeholder
+// that was not observed on a real camera.
+type CameraStatus struct {
+	BatteryPercent    int
+	Charging          bool
+	CardPresent       bool
+	CardCapacity      uint64
+	CardFree          uint64
+	Recording         bool
+	RecordingDuration time.Duration
+}
+
+func (s *CameraStatus) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 24)
+	data[0] = byte(s.BatteryPercent)
+	data[1] = flag(s.Charging)
+	data[2] = flag(s.CardPresent)
+	data[3] = flag(s.Recording)
+	binary.LittleEndian.PutUint64(data[4:], s.CardCapacity)
+	binary.LittleEndian.PutUint64(data[12:], s.CardFree)
+	binary.LittleEndian.PutUint32(data[20:], uint32(s.RecordingDuration/time.Second))
+	return data, nil
+}
+
+func (s *CameraStatus) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return errShortPayload
+	}
+	s.BatteryPercent = int(data[0])
+	s.Charging = data[1] != 0
+	s.CardPresent = data[2] != 0
+	s.Recording = data[3] != 0
+	s.CardCapacity = binary.LittleEndian.Uint64(data[4:])
+	s.CardFree = binary.LittleEndian.Uint64(data[12:])
+	s.RecordingDuration = time.Duration(binary.LittleEndian.Uint32(data[20:])) * time.Second
+	return nil
+}
+
+func (s *CameraStatus) String() string {
+	battery := fmt.Sprintf("bateria %d%%", s.BatteryPercent)
+	if s.Charging {
+		battery += " (carregando)"
+	}
+	card := "sem cartão"
+	if s.CardPresent {
+		card = fmt.Sprintf("cartão %d/%d MiB livres", s.CardFree>>20, s.CardCapacity>>20)
+	}
+	recording := "parada"
+	if s.Recording {
+		recording = "gravando há " + s.RecordingDuration.String()
+	}
+	return battery + ", " + card + ", " + recording
+}
+
+func flag(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// StatusEvent is published by the status poller when the status changes or a
+// poll fails. Previous is nil for the first status.
+type StatusEvent struct {
+	Status   CameraStatus
+	Previous *CameraStatus
+	Err      error
+	Time     time.Time
+}
+
+// GetStatus queries battery, SD card and recording status.
+func (c *Camera) GetStatus() (CameraStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetStatusContext(ctx)
+}
+
+// GetStatusContext queries battery, SD card and recording status.
+func (c *Camera) GetStatusContext(ctx context.Context) (CameraStatus, error) {
+	if !c.IsLoggedIn() {
+		return CameraStatus{}, ErrNotLoggedIn
+	}
+	reply, err := c.Request(ctx, REQUEST_STATUS, nil, STATUS_INFORMATION)
+	if err != nil {
+		return CameraStatus{}, err
+	}
+	status := CameraStatus{}
+	if err := status.UnmarshalBinary(reply.Payload); err != nil {
+		return CameraStatus{}, &ProtocolError{Header: reply.Header, Reason: err.Error()}
+	}
+	return status, nil
+}
+
+// StartStatusPoller queries the status every interval until StopStatusPoller
+// or Disconnect is called, publishing changes to SubscribeStatus. Polls are
+// skipped while the camera is not logged in. Starting the poller again
+// replaces the running one.
+func (c *Camera) StartStatusPoller(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.stateMutex.Lock()
+	if c.statusCancel != nil {
+		c.statusCancel()
+	}
+	c.statusCancel = cancel
+	c.stateMutex.Unlock()
+
+	go c.pollStatus(ctx, interval)
+}
+
+// StopStatusPoller stops the poller started with StartStatusPoller.
+func (c *Camera) StopStatusPoller() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.statusCancel != nil {
+		c.statusCancel()
+		c.statusCancel = nil
+	}
+}
+
+func (c *Camera) pollStatus(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if c.IsLoggedIn() {
+			requestCtx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+			status, err := c.GetStatusContext(requestCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			c.publishStatus(status, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastStatus returns the status last received by the poller.
+func (c *Camera) LastStatus() (CameraStatus, bool) {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	if c.status == nil {
+		return CameraStatus{}, false
+	}
+	return *c.status, true
+}
+
+// SubscribeStatus returns a channel receiving the events of the status poller
+// and a function that cancels the subscription and closes the channel.
+func (c *Camera) SubscribeStatus() (<-chan StatusEvent, func()) {
+	events := make(chan StatusEvent, stateSubscriberBuffer)
+
+	c.eventMutex.Lock()
+	c.nextSubscriberID++
+	id := c.nextSubscriberID
+	if c.statusSubscribers == nil {
+		c.statusSubscribers = make(map[uint64]chan StatusEvent)
+	}
+	c.statusSubscribers[id] = events
+	c.eventMutex.Unlock()
+
+	unsubscribe := func() {
+		c.eventMutex.Lock()
+		defer c.eventMutex.Unlock()
+		if _, ok := c.statusSubscribers[id]; ok {
+			delete(c.statusSubscribers, id)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+// publishStatus notifies subscribers when the status differs from the last
+// one, or when a poll fails for another reason than the previous one. The
+// recording duration alone does not count as a change, as it grows with every
+// poll while recording; LastStatus still reports the latest one.
+func (c *Camera) publishStatus(status CameraStatus, err error) {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+
+	event := StatusEvent{Status: status, Previous: c.status, Err: err, Time: time.Now()}
+	if err != nil {
+		if c.statusErr != nil && c.statusErr.Error() == err.Error() {
+			return
+		}
+		c.statusErr = err
+		if c.status != nil {
+			event.Status = *c.status
+		}
+	} else {
+		unchanged := c.status != nil && sameStatus(*c.status, status) && c.statusErr == nil
+		c.statusErr = nil
+		c.status = &status
+		if unchanged {
+			return
+		}
+	}
+
+	for _, subscriber := range c.statusSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// sameStatus compares two statuses ignoring the recording duration.
+func sameStatus(a, b CameraStatus) bool {
+	a.RecordingDuration = 0
+	b.RecordingDuration = 0
+	return a == b
+}
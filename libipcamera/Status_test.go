@@ -0,0 +1,160 @@
+package libipcamera_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// handleStatus makes emu answer REQUEST_STATUS with the status last passed to
+// the returned function.
+func handleStatus(emu *emulator.Emulator, status libipcamera.CameraStatus) func(libipcamera.CameraStatus) {
+	var mutex sync.Mutex
+	emu.Handle(libipcamera.REQUEST_STATUS, func(payload []byte) (uint32, []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reply, _ := status.MarshalBinary()
+		return libipcamera.STATUS_INFORMATION, reply
+	})
+	return func(next libipcamera.CameraStatus) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		status = next
+	}
+}
+
+func TestStatusRequiresExperimental(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	if _, err := camera.GetStatus(); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("GetStatus = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(libipcamera.REQUEST_STATUS) != 0 {
+		t.Fatal("placeholder status command was sent")
+	}
+}
+
+func TestStatusPayload(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	emu.Handle(libipcamera.REQUEST_STATUS, func(payload []byte) (uint32, []byte) {
+		return libipcamera.STATUS_INFORMATION, []byte{
+			57, 1, 1, 1,
+			0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x5A, 0x00, 0x00, 0x00,
+		}
+	})
+	status, err := camera.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := libipcamera.CameraStatus{
+		BatteryPercent:    57,
+		Charging:          true,
+		CardPresent:       true,
+		CardCapacity:      1 << 30,
+		CardFree:          1 << 20,
+		Recording:         true,
+		RecordingDuration: 90 * time.Second,
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Fatalf("GetStatus = %+v, expected %+v", status, expected)
+	}
+
+	emu.Handle(libipcamera.REQUEST_STATUS, func(payload []byte) (uint32, []byte) {
+		return libipcamera.STATUS_INFORMATION, []byte{57, 1, 1}
+	})
+	if _, err := camera.GetStatus(); !errors.Is(err, libipcamera.ErrProtocol) {
+		t.Fatalf("short status accepted: %v", err)
+	}
+}
+
+func TestStatusPoller(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	status := libipcamera.CameraStatus{BatteryPercent: 100, CardPresent: true, CardCapacity: 1 << 30, CardFree: 1 << 29}
+	setStatus := handleStatus(emu, status)
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	events, unsubscribe := camera.SubscribeStatus()
+	defer unsubscribe()
+	camera.StartStatusPoller(5 * time.Millisecond)
+	defer camera.StopStatusPoller()
+
+	receive := func() libipcamera.StatusEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no status event")
+		}
+		return libipcamera.StatusEvent{}
+	}
+	if event := receive(); event.Previous != nil || event.Status != status {
+		t.Fatalf("unexpected first event %+v", event)
+	}
+
+	status.Recording = true
+	setStatus(status)
+	if event := receive(); !event.Status.Recording || event.Previous == nil || event.Previous.Recording {
+		t.Fatalf("unexpected recording event %+v", event)
+	}
+
+	// A growing recording duration is not a change.
+	for seconds := 1; seconds <= 5; seconds++ {
+		status.RecordingDuration = time.Duration(seconds) * time.Second
+		setStatus(status)
+		polls := emu.Received(libipcamera.REQUEST_STATUS)
+		waitFor(t, func() bool { return emu.Received(libipcamera.REQUEST_STATUS) > polls+1 })
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("event for a recording duration change: %+v", event)
+	default:
+	}
+	if last, ok := camera.LastStatus(); !ok || last.RecordingDuration != 5*time.Second {
+		t.Fatalf("LastStatus = %+v, %v", last, ok)
+	}
+
+	status.BatteryPercent = 9
+	setStatus(status)
+	event := receive()
+	if event.Status.BatteryPercent != 9 || event.Previous == nil || event.Previous.BatteryPercent != 100 {
+		t.Fatalf("unexpected battery event %+v", event)
+	}
+}
+
+func TestStatusPollerReportsErrorsOnce(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	events, unsubscribe := camera.SubscribeStatus()
+	defer unsubscribe()
+	camera.StartStatusPoller(5 * time.Millisecond)
+	defer camera.StopStatusPoller()
+
+	select {
+	case event := <-events:
+		if !errors.Is(event.Err, libipcamera.ErrUnverifiedCommand) {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error event")
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case event := <-events:
+		t.Fatalf("repeated error event %+v", event)
+	default:
+	}
+}
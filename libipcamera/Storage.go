@@ -0,0 +1,106 @@
+package libipcamera
+
+import (
+	"context"
+)
+
+// FormatConfirmationToken must be passed to FormatCard to confirm that every
+// file on the SD card is to be erased.
+const FormatConfirmationToken = "FORMAT"
+
+// resultRequest sends command and turns a non-zero result code in the reply into a ResultError.
+func (c *Camera) resultRequest(ctx context.Context, command uint32, payload []byte, replyType uint32) error {
+	reply, err := c.Request(ctx, command, payload, replyType)
+	if err != nil {
+		return err
+	}
+	result := Uint32Payload{}
+	if err := result.UnmarshalBinary(reply.Payload); err != nil {
+		return &ProtocolError{Header: reply.Header, Reason: err.Error()}
+	}
+	if result.Value != ResultOK {
+		return &ResultError{Command: command, Code: result.Value}
+	}
+	return nil
+}
+
+// DeleteFile deletes the file at path, as listed by GetFileList, from the SD card.
+func (c *Camera) DeleteFile(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.DeleteFileContext(ctx, path)
+}
+
+// DeleteFileContext deletes the file at path, as listed by GetFileList, from the SD card.
+func (c *Camera) DeleteFileContext(ctx context.Context, path string) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	payload, _ := (&FilePath{Path: path}).MarshalBinary()
+	err := c.resultRequest(ctx, DELETE_FILE, payload, FILE_DELETED)
+	if err != nil {
+		return err
+	}
+	c.log(LevelDebug, "Arquivo apagado", F("path", path))
+	return nil
+}
+
+// DeleteFiles deletes every file in paths, each with DefaultRequestTimeout.
+func (c *Camera) DeleteFiles(paths []string) ([]string, error) {
+	return c.DeleteFilesContext(context.Background(), paths)
+}
+
+// DeleteFilesContext deletes every file in paths and returns the paths
+// deleted. Files that cannot be deleted are skipped and reported in a
+// DeleteError; a cancelled ctx or a lost connection stops the deletion.
+func (c *Camera) DeleteFilesContext(ctx context.Context, paths []string) ([]string, error) {
+	if err := c.checkVerified(DELETE_FILE); err != nil {
+		return nil, err
+	}
+	var deleted []string
+	failed := make(map[string]error)
+	for _, path := range paths {
+		requestCtx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+		err := c.DeleteFileContext(requestCtx, path)
+		cancel()
+		if err == nil {
+			deleted = append(deleted, path)
+			continue
+		}
+		if ctx.Err() != nil || !c.IsLoggedIn() {
+			return deleted, err
+		}
+		failed[path] = err
+	}
+	if len(failed) > 0 {
+		return deleted, &DeleteError{Failed: failed}
+	}
+	return deleted, nil
+}
+
+// FormatCard erases every file on the SD card. confirmation must be
+// FormatConfirmationToken, otherwise ErrNotConfirmed is returned without
+// contacting the camera.
+func (c *Camera) FormatCard(confirmation string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultFormatTimeout)
+	defer cancel()
+	return c.FormatCardContext(ctx, confirmation)
+}
+
+// FormatCardContext erases every file on the SD card. confirmation must be
+// FormatConfirmationToken, otherwise ErrNotConfirmed is returned without
+// contacting the camera.
+func (c *Camera) FormatCardContext(ctx context.Context, confirmation string) error {
+	if confirmation != FormatConfirmationToken {
+		return ErrNotConfirmed
+	}
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	err := c.resultRequest(ctx, FORMAT_CARD, nil, CARD_FORMATTED)
+	if err != nil {
+		return err
+	}
+	c.log(LevelDebug, "Cartão SD formatado")
+	return nil
+}
@@ -0,0 +1,87 @@
+package libipcamera_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestStorageCommandsRequireExperimental(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	if err := camera.DeleteFile("/DCIM/MOVIE/A.MP4"); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("DeleteFile = %v, expected ErrUnverifiedCommand", err)
+	}
+	deleted, err := camera.DeleteFiles([]string{"/DCIM/MOVIE/A.MP4", "/DCIM/MOVIE/B.MP4"})
+	if len(deleted) != 0 || !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("DeleteFiles = %v, %v, expected ErrUnverifiedCommand", deleted, err)
+	}
+	if err := camera.FormatCard(libipcamera.FormatConfirmationToken); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("FormatCard = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(libipcamera.DELETE_FILE) != 0 || emu.Received(libipcamera.FORMAT_CARD) != 0 {
+		t.Fatal("placeholder storage commands were sent")
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Files: []emulator.File{
+		{Path: "/DCIM/MOVIE/A.MP4", Size: 100}, {Path: "/DCIM/MOVIE/B.MP4", Size: 200}, {Path: "/DCIM/MOVIE/C.MP4", Size: 300},
+	}})
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	if err := camera.DeleteFile("/DCIM/MOVIE/A.MP4"); err != nil {
+		t.Fatal(err)
+	}
+	if files := emu.Files(); len(files) != 2 || files[0].Path != "/DCIM/MOVIE/B.MP4" {
+		t.Fatalf("files left after deleting A.MP4: %+v", files)
+	}
+	if err := camera.DeleteFile("/DCIM/MOVIE/X.MP4"); !errors.Is(err, libipcamera.ErrFileNotFound) {
+		t.Fatalf("expected ErrFileNotFound, got %v", err)
+	}
+
+	deleted, err := camera.DeleteFiles([]string{"/DCIM/MOVIE/B.MP4", "/DCIM/MOVIE/X.MP4", "/DCIM/MOVIE/C.MP4"})
+	var deleteError *libipcamera.DeleteError
+	if !errors.As(err, &deleteError) || len(deleteError.Failed) != 1 || deleteError.Failed["/DCIM/MOVIE/X.MP4"] == nil {
+		t.Fatalf("DeleteFiles error %v", err)
+	}
+	if len(deleted) != 2 || deleted[0] != "/DCIM/MOVIE/B.MP4" || deleted[1] != "/DCIM/MOVIE/C.MP4" {
+		t.Fatalf("DeleteFiles deleted %v", deleted)
+	}
+}
+
+func TestFormatCard(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	camera.EnableExperimentalCommands(true)
+
+	if err := camera.FormatCard("yes"); !errors.Is(err, libipcamera.ErrNotConfirmed) {
+		t.Fatalf("expected ErrNotConfirmed, got %v", err)
+	}
+	if emu.Received(libipcamera.FORMAT_CARD) != 0 {
+		t.Fatal("unconfirmed format was sent to the camera")
+	}
+
+	// The camera refuses to format while recording.
+	if err := camera.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	var resultError *libipcamera.ResultError
+	if err := camera.FormatCard(libipcamera.FormatConfirmationToken); !errors.As(err, &resultError) || resultError.Code != libipcamera.ResultDenied {
+		t.Fatalf("expected a denied format, got %v", err)
+	}
+
+	if err := camera.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.FormatCard(libipcamera.FormatConfirmationToken); err != nil {
+		t.Fatal(err)
+	}
+	if files := emu.Files(); len(files) != 0 {
+		t.Fatalf("files left after formatting: %+v", files)
+	}
+}
@@ -0,0 +1,165 @@
+package libipcamera
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type TraceDirection string
+
+const (
+	TraceSent     TraceDirection = "sent"
+	TraceReceived TraceDirection = "received"
+)
+
+type TraceProtocol string
+
+const (
+	// TraceControl records carry one 0xABCD message, header included.
+	TraceControl TraceProtocol = "control"
+	// TraceStream records carry one 0xBCDE datagram of the preview stream.
+	TraceStream TraceProtocol = "stream"
+)
+
+// TraceRecord is a message captured by the trace tap or read from a capture file.
+type TraceRecord struct {
+	Time      time.Time
+	Direction TraceDirection
+	Protocol  TraceProtocol
+	Data      []byte
+}
+
+type traceRecordJSON struct {
+	Time      time.Time      `json:"time"`
+	Direction TraceDirection `json:"direction"`
+	Protocol  TraceProtocol  `json:"protocol"`
+	Data      string         `json:"data"`
+}
+
+// MarshalJSON encodes the record with Data in hexadecimal.
+func (r TraceRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(traceRecordJSON{Time: r.Time, Direction: r.Direction, Protocol: r.Protocol, Data: hex.EncodeToString(r.Data)})
+}
+
+func (r *TraceRecord) UnmarshalJSON(data []byte) error {
+	record := traceRecordJSON{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(record.Data)
+	if err != nil {
+		return err
+	}
+	*r = TraceRecord{Time: record.Time, Direction: record.Direction, Protocol: record.Protocol, Data: decoded}
+	return nil
+}
+
+// Tracer receives every message passing the tap. Trace is called from the
+// connection goroutines and must not block for long.
+type Tracer interface {
+	Trace(record TraceRecord)
+}
+
+// TraceWriter writes records as JSON lines. The passwords in LOGIN, SET_LOGIN
+// and the Wi-Fi configuration messages are blanked unless IncludeCredentials is set.
+type TraceWriter struct {
+	IncludeCredentials bool
+
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{encoder: json.NewEncoder(w)}
+}
+
+func (t *TraceWriter) Trace(record TraceRecord) {
+	if !t.IncludeCredentials {
+		record.Data = blankLoginPassword(record)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.err == nil {
+		t.err = t.encoder.Encode(record)
+	}
+}
+
+// Err returns the first write error.
+func (t *TraceWriter) Err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.err
+}
+
+func blankLoginPassword(record TraceRecord) []byte {
+	if record.Protocol != TraceControl {
+		return record.Data
+	}
+	message, err := parseControlRecord(record.Data)
+	if err != nil {
+		return record.Data
+	}
+	var start, end int
+	switch message.Header.MessageType {
+	case LOGIN, SET_LOGIN:
+		start, end = 64, 128
+	case WIFI_INFORMATION, SET_WIFI:
+		start, end = 32, 96
+	default:
+		return record.Data
+	}
+	if len(message.Payload) < end {
+		return record.Data
+	}
+	data := append([]byte(nil), record.Data...)
+	for i := 8 + start; i < 8+end; i++ {
+		data[i] = 0
+	}
+	return data
+}
+
+// encodeHeader encodes header as received, without fixing up the length.
+func encodeHeader(header Header) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint16(data, header.Magic)
+	binary.BigEndian.PutUint16(data[2:], header.Length)
+	binary.BigEndian.PutUint32(data[4:], header.MessageType)
+	return data
+}
+
+// SetTracer taps the control connection, nil removes the tap.
+func (c *Camera) SetTracer(tracer Tracer) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.tracer = tracer
+}
+
+func (c *Camera) trace(direction TraceDirection, packet []byte) {
+	c.stateMutex.RLock()
+	tracer := c.tracer
+	c.stateMutex.RUnlock()
+	if tracer != nil {
+		tracer.Trace(TraceRecord{Time: time.Now(), Direction: direction, Protocol: TraceControl, Data: packet})
+	}
+}
+
+// SetTracer taps the preview stream received by the relay, nil removes the tap.
+func (r *RTPRelay) SetTracer(tracer Tracer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tracer = tracer
+}
+
+func (r *RTPRelay) trace(packet []byte) {
+	r.mutex.Lock()
+	tracer := r.tracer
+	r.mutex.Unlock()
+	if tracer != nil {
+		tracer.Trace(TraceRecord{Time: time.Now(), Direction: TraceReceived, Protocol: TraceStream, Data: append([]byte(nil), packet...)})
+	}
+}
@@ -0,0 +1,118 @@
+package libipcamera
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseControlRecord splits a 0xABCD packet into header and payload.
+func parseControlRecord(data []byte) (*Message, error) {
+	if len(data) < 8 {
+		return nil, errShortPayload
+	}
+	header := Header{
+		Magic:       binary.BigEndian.Uint16(data),
+		Length:      binary.BigEndian.Uint16(data[2:]),
+		MessageType: binary.BigEndian.Uint32(data[4:]),
+	}
+	if header.Magic != 0xABCD {
+		return nil, &ProtocolError{Header: header, Reason: "magic inválido"}
+	}
+	if len(data) < 8+int(header.Length) {
+		return nil, &ProtocolError{Header: header, Reason: "carga útil incompleta"}
+	}
+	return &Message{Header: header, Payload: data[8 : 8+int(header.Length)]}, nil
+}
+
+// TraceDecoder describes trace records in text. It keeps the state needed to
+// assemble multipart file lists and the frames of the preview stream, so
+// records must be decoded in order.
+type TraceDecoder struct {
+	fileList     strings.Builder
+	frames       int
+	frameBytes   int
+	framePackets int
+	sequence     uint16
+	sequenceSeen bool
+}
+
+// Decode returns the lines describing record.
+func (d *TraceDecoder) Decode(record TraceRecord) []string {
+	arrow := "->"
+	if record.Direction == TraceReceived {
+		arrow = "<-"
+	}
+	prefix := record.Time.Format("15:04:05.000000") + " " + arrow + " "
+
+	if record.Protocol == TraceStream {
+		lines := d.decodeStream(record.Data)
+		for i := range lines {
+			lines[i] = prefix + lines[i]
+		}
+		return lines
+	}
+
+	message, err := parseControlRecord(record.Data)
+	if err != nil {
+		return []string{prefix + fmt.Sprintf("pacote inválido: %s\n%s", err, hex.Dump(record.Data))}
+	}
+	lines := []string{prefix + message.String()}
+
+	if message.Header.MessageType == FILE_LIST_CONTENT {
+		part := FileListPart{}
+		if part.UnmarshalBinary(message.Payload) == nil {
+			if part.Part == 0 {
+				d.fileList.Reset()
+			}
+			d.fileList.Write(part.Data)
+			if part.Last() {
+				files := parseFileList(d.fileList.String())
+				lines = append(lines, fmt.Sprintf("    lista de arquivos com %d arquivo(s):", len(files)))
+				for _, file := range files {
+					lines = append(lines, fmt.Sprintf("    %s\t%d", file.Path, file.Size))
+				}
+				d.fileList.Reset()
+			}
+		}
+	}
+	return lines
+}
+
+func (d *TraceDecoder) decodeStream(data []byte) []string {
+	if len(data) < 8 || binary.BigEndian.Uint16(data) != 0xBCDE {
+		return []string{fmt.Sprintf("datagrama inválido do fluxo\n%s", hex.Dump(data))}
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	sequence := binary.BigEndian.Uint16(data[4:])
+	messageType := binary.BigEndian.Uint16(data[6:])
+	payload := data[8:]
+	if length < len(payload) {
+		payload = payload[:length]
+	}
+
+	var lines []string
+	if d.sequenceSeen && sequence != d.sequence+1 {
+		lines = append(lines, fmt.Sprintf("%d pacote(s) do fluxo perdido(s) antes da sequência %d", uint16(sequence-d.sequence-1), sequence))
+	}
+	d.sequence, d.sequenceSeen = sequence, true
+
+	switch messageType {
+	case STREAM_FRAME_DATA:
+		d.frameBytes += len(payload)
+		d.framePackets++
+	case STREAM_FRAME_END:
+		end := FrameEnd{}
+		elapsed := "?"
+		if end.UnmarshalBinary(payload) == nil {
+			elapsed = fmt.Sprintf("%dms", end.Elapsed)
+		}
+		d.frames++
+		lines = append(lines, fmt.Sprintf("quadro %d: %d bytes em %d pacote(s), tempo %s", d.frames, d.frameBytes, d.framePackets, elapsed))
+		d.frameBytes, d.framePackets = 0, 0
+	default:
+		lines = append(lines, fmt.Sprintf("%s seq=%d (%d bytes)", StreamMessageName(messageType), sequence, len(payload)))
+	}
+	return lines
+}
@@ -0,0 +1,408 @@
+package libipcamera
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link types of pcap and pcapng captures understood by ReadTrace.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// Captured packets longer than maxCaptureLength are rejected rather than
+// allocated, since a corrupt length would otherwise claim up to 4 GiB. A
+// pcapng block may add maxBlockOverhead bytes of headers and options.
+const (
+	maxCaptureLength = 64 * 1024
+	maxBlockOverhead = 64 * 1024
+)
+
+var errUnknownTraceFormat = errors.New("Formato de rastreamento desconhecido, esperado JSONL, pcap ou pcapng")
+
+// ReadTrace reads the records of a trace written by TraceWriter, or of a pcap
+// or pcapng capture such as those of tcpdump and Wireshark. In captures, the
+// TCP streams are reassembled into 0xABCD messages and UDP datagrams carrying
+// 0xABCD or 0xBCDE messages are kept. Messages sent from controlPort or from
+// a discovery port are taken as received from the camera.
+func ReadTrace(r io.Reader, controlPort int) ([]TraceRecord, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte{0x0A, 0x0D, 0x0D, 0x0A}):
+		return readPcapng(reader, newCaptureAssembler(controlPort))
+	case isPcapMagic(magic):
+		return readPcap(reader, newCaptureAssembler(controlPort))
+	case magic[0] == '{':
+		return readTraceJSON(reader)
+	}
+	return nil, errUnknownTraceFormat
+}
+
+func readTraceJSON(r io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+	decoder := json.NewDecoder(r)
+	for {
+		record := TraceRecord{}
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("Registro %d inválido: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func isPcapMagic(magic []byte) bool {
+	value := binary.LittleEndian.Uint32(magic)
+	switch value {
+	case 0xA1B2C3D4, 0xD4C3B2A1, 0xA1B23C4D, 0x4D3CB2A1:
+		return true
+	}
+	return false
+}
+
+func readPcap(r io.Reader, assembler *captureAssembler) ([]TraceRecord, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(header)
+	if magic == 0xD4C3B2A1 || magic == 0x4D3CB2A1 {
+		order = binary.BigEndian
+		magic = order.Uint32(header)
+	}
+	nanoseconds := magic == 0xA1B23C4D
+	linkType := order.Uint32(header[20:]) & 0x0FFFFFFF
+	snapLength := order.Uint32(header[16:])
+	if snapLength == 0 || snapLength > maxCaptureLength {
+		snapLength = maxCaptureLength
+	}
+
+	packetHeader := make([]byte, 16)
+	for {
+		_, err := io.ReadFull(r, packetHeader)
+		if err == io.EOF {
+			return assembler.records, nil
+		}
+		if err != nil {
+			return assembler.records, err
+		}
+		seconds := int64(order.Uint32(packetHeader))
+		fraction := int64(order.Uint32(packetHeader[4:]))
+		if !nanoseconds {
+			fraction *= 1000
+		}
+		length := order.Uint32(packetHeader[8:])
+		if length > snapLength {
+			return assembler.records, fmt.Errorf("Registro de captura com %d bytes excede o limite de %d", length, snapLength)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return assembler.records, err
+		}
+		assembler.addFrame(time.Unix(seconds, fraction), linkType, data)
+	}
+}
+
+func readPcapng(r io.Reader, assembler *captureAssembler) ([]TraceRecord, error) {
+	type captureInterface struct {
+		linkType   uint32
+		resolution time.Duration
+	}
+	var interfaces []captureInterface
+	var order binary.ByteOrder = binary.LittleEndian
+
+	blockHeader := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, blockHeader)
+		if err == io.EOF {
+			return assembler.records, nil
+		}
+		if err != nil {
+			return assembler.records, err
+		}
+		blockType := binary.LittleEndian.Uint32(blockHeader)
+		if blockType == 0x0A0D0D0A {
+			// The byte order magic follows the length of the section header.
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(r, magic); err != nil {
+				return assembler.records, err
+			}
+			order = binary.LittleEndian
+			if binary.LittleEndian.Uint32(magic) != 0x1A2B3C4D {
+				order = binary.BigEndian
+			}
+			length := order.Uint32(blockHeader[4:])
+			if length < 16 {
+				return assembler.records, errUnknownTraceFormat
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(length-12)); err != nil {
+				return assembler.records, err
+			}
+			interfaces = nil
+			continue
+		}
+
+		blockType = order.Uint32(blockHeader)
+		length := order.Uint32(blockHeader[4:])
+		if length < 12 {
+			return assembler.records, errUnknownTraceFormat
+		}
+		if length > maxCaptureLength+maxBlockOverhead {
+			return assembler.records, fmt.Errorf("Bloco de captura com %d bytes excede o limite de %d", length, maxCaptureLength+maxBlockOverhead)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return assembler.records, err
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case 1: // Interface Description Block
+			if len(body) < 8 {
+				continue
+			}
+			iface := captureInterface{linkType: uint32(order.Uint16(body)), resolution: time.Microsecond}
+			options := body[8:]
+			for len(options) >= 4 {
+				code, size := order.Uint16(options), int(order.Uint16(options[2:]))
+				if code == 0 || 4+size > len(options) {
+					break
+				}
+				if code == 9 && size >= 1 {
+					iface.resolution = pcapngResolution(options[4])
+				}
+				options = options[4+(size+3)&^3:]
+			}
+			interfaces = append(interfaces, iface)
+		case 6: // Enhanced Packet Block
+			if len(body) < 20 {
+				continue
+			}
+			id := int(order.Uint32(body))
+			if id >= len(interfaces) {
+				continue
+			}
+			timestamp := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			captured := int(order.Uint32(body[12:]))
+			if 20+captured > len(body) {
+				continue
+			}
+			resolution := interfaces[id].resolution
+			ts := time.Unix(0, 0).Add(time.Duration(timestamp) * resolution)
+			assembler.addFrame(ts, interfaces[id].linkType, body[20:20+captured])
+		}
+	}
+}
+
+// pcapngResolution decodes the if_tsresol option.
+func pcapngResolution(value byte) time.Duration {
+	if value&0x80 != 0 {
+		return time.Second >> (value & 0x7F)
+	}
+	resolution := time.Second
+	for i := byte(0); i < value && resolution > 1; i++ {
+		resolution /= 10
+	}
+	return resolution
+}
+
+// captureAssembler turns captured link layer frames into trace records.
+type captureAssembler struct {
+	cameraPorts map[uint16]bool
+	streams     map[string]*tcpStream
+	records     []TraceRecord
+}
+
+type tcpStream struct {
+	started bool
+	next    uint32
+	data    []byte
+}
+
+func newCaptureAssembler(controlPort int) *captureAssembler {
+	assembler := &captureAssembler{cameraPorts: map[uint16]bool{uint16(controlPort): true}, streams: make(map[string]*tcpStream)}
+	for _, port := range DiscoveryPorts() {
+		assembler.cameraPorts[uint16(port)] = true
+	}
+	return assembler
+}
+
+func (a *captureAssembler) addFrame(ts time.Time, linkType uint32, frame []byte) {
+	var packet []byte
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return
+		}
+		etherType, packet = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		if etherType == 0x8100 && len(packet) >= 4 {
+			etherType, packet = binary.BigEndian.Uint16(packet[2:]), packet[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return
+		}
+		etherType, packet = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return
+		}
+		packet = frame[4:]
+	case linkTypeRaw:
+		packet = frame
+	default:
+		return
+	}
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86DD {
+		return
+	}
+	a.addIP(ts, packet)
+}
+
+func (a *captureAssembler) addIP(ts time.Time, packet []byte) {
+	if len(packet) < 1 {
+		return
+	}
+	var protocol byte
+	var source, destination net.IP
+	var transport []byte
+	switch packet[0] >> 4 {
+	case 4:
+		headerLength := int(packet[0]&0x0F) * 4
+		if len(packet) < 20 || headerLength < 20 || len(packet) < headerLength {
+			return
+		}
+		// Fragments other than the first cannot be decoded on their own.
+		if binary.BigEndian.Uint16(packet[6:])&0x1FFF != 0 {
+			return
+		}
+		total := int(binary.BigEndian.Uint16(packet[2:]))
+		if total >= headerLength && total < len(packet) {
+			packet = packet[:total]
+		}
+		protocol, source, destination, transport = packet[9], net.IP(packet[12:16]), net.IP(packet[16:20]), packet[headerLength:]
+	case 6:
+		if len(packet) < 40 {
+			return
+		}
+		protocol, source, destination, transport = packet[6], net.IP(packet[8:24]), net.IP(packet[24:40]), packet[40:]
+	default:
+		return
+	}
+
+	switch protocol {
+	case 6:
+		if len(transport) < 20 {
+			return
+		}
+		offset := int(transport[12]>>4) * 4
+		if offset < 20 || offset > len(transport) {
+			return
+		}
+		sourcePort, destinationPort := binary.BigEndian.Uint16(transport), binary.BigEndian.Uint16(transport[2:])
+		key := fmt.Sprintf("%s:%d>%s:%d", source, sourcePort, destination, destinationPort)
+		a.addSegment(ts, key, a.direction(sourcePort), binary.BigEndian.Uint32(transport[4:]), transport[offset:])
+	case 17:
+		if len(transport) < 8 {
+			return
+		}
+		sourcePort := binary.BigEndian.Uint16(transport)
+		a.addDatagram(ts, a.direction(sourcePort), transport[8:])
+	}
+}
+
+func (a *captureAssembler) direction(sourcePort uint16) TraceDirection {
+	if a.cameraPorts[sourcePort] {
+		return TraceReceived
+	}
+	return TraceSent
+}
+
+func (a *captureAssembler) addDatagram(ts time.Time, direction TraceDirection, payload []byte) {
+	if len(payload) < 2 {
+		return
+	}
+	switch binary.BigEndian.Uint16(payload) {
+	case 0xABCD:
+		a.records = append(a.records, TraceRecord{Time: ts, Direction: direction, Protocol: TraceControl, Data: append([]byte(nil), payload...)})
+	case 0xBCDE:
+		a.records = append(a.records, TraceRecord{Time: ts, Direction: TraceReceived, Protocol: TraceStream, Data: append([]byte(nil), payload...)})
+	}
+}
+
+// addSegment appends a TCP segment to its stream, skipping retransmitted
+// data, and emits every complete 0xABCD message. After a gap in the capture
+// the stream restarts at the next message boundary.
+func (a *captureAssembler) addSegment(ts time.Time, key string, direction TraceDirection, sequence uint32, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	stream := a.streams[key]
+	if stream == nil {
+		stream = &tcpStream{}
+		a.streams[key] = stream
+	}
+	if !stream.started {
+		stream.started = true
+		stream.next = sequence
+	}
+
+	switch distance := int32(sequence - stream.next); {
+	case distance > 0:
+		stream.data = nil
+		stream.next = sequence
+	case distance < 0:
+		if -int(distance) >= len(payload) {
+			return
+		}
+		payload = payload[-distance:]
+	}
+	stream.data = append(stream.data, payload...)
+	stream.next += uint32(len(payload))
+
+	for {
+		start := bytes.Index(stream.data, []byte{0xAB, 0xCD})
+		if start < 0 {
+			// Keep a trailing 0xAB, the magic may continue in the next segment.
+			if n := len(stream.data); n > 0 && stream.data[n-1] == 0xAB {
+				stream.data = stream.data[n-1:]
+			} else {
+				stream.data = stream.data[:0]
+			}
+			return
+		}
+		stream.data = stream.data[start:]
+		if len(stream.data) < 8 {
+			return
+		}
+		length := 8 + int(binary.BigEndian.Uint16(stream.data[2:]))
+		if len(stream.data) < length {
+			return
+		}
+		a.records = append(a.records, TraceRecord{Time: ts, Direction: direction, Protocol: TraceControl, Data: append([]byte(nil), stream.data[:length]...)})
+		stream.data = stream.data[length:]
+	}
+}
@@ -0,0 +1,228 @@
+package libipcamera_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+// lockedBuffer is a buffer written by the connection goroutine while the
+// test reads it.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestTraceTap(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera, _ := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	camera.SetLogger(libipcamera.NopLogger())
+	output := &lockedBuffer{}
+	camera.SetTracer(libipcamera.NewTraceWriter(output))
+	defer camera.Disconnect()
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := camera.GetFirmwareInfo(); err != nil {
+		t.Fatal(err)
+	}
+	camera.SetTracer(nil)
+
+	// Keepalives may still be written by the connection goroutine.
+	trace := output.String()
+	if strings.Contains(trace, "3132333435") {
+		t.Fatal("password written to the trace")
+	}
+	records, err := libipcamera.ReadTrace(strings.NewReader(trace), 6666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	decoder := libipcamera.TraceDecoder{}
+	for _, record := range records {
+		lines = append(lines, decoder.Decode(record)...)
+	}
+	text := strings.Join(lines, "\n")
+	for _, expected := range []string{"-> LOGIN username=admin", "<- LOGIN_ACCEPT", "-> REQUEST_FIRMWARE_INFO", "<- FIRMWARE_INFORMATION " + defaultFirmware} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("%q missing from decoded trace:\n%s", expected, text)
+		}
+	}
+}
+
+func TestTraceBlanksWifiPassword(t *testing.T) {
+	output := &bytes.Buffer{}
+	writer := libipcamera.NewTraceWriter(output)
+	packet, _ := libipcamera.CreateMessagePacket(libipcamera.SET_WIFI, &libipcamera.WifiConfig{SSID: "RIG1-A", Password: "unidade-0042", Channel: 11})
+	writer.Trace(libipcamera.TraceRecord{Time: time.Now(), Direction: libipcamera.TraceSent, Protocol: libipcamera.TraceControl, Data: packet})
+
+	if strings.Contains(output.String(), "756e6964616465") {
+		t.Fatalf("Wi-Fi password written to the trace: %s", output)
+	}
+	if !strings.Contains(output.String(), "52494731") {
+		t.Fatalf("SSID missing from the trace: %s", output)
+	}
+}
+
+// Packets of a capture between a client at 10.0.0.2 and a camera at 10.0.0.1.
+func capturedTCP(sourcePort, destinationPort uint16, sequence uint32, payload []byte) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp, sourcePort)
+	binary.BigEndian.PutUint16(tcp[2:], destinationPort)
+	binary.BigEndian.PutUint32(tcp[4:], sequence)
+	tcp[12] = 5 << 4
+	return capturedIP(6, sourcePort == 6666, append(tcp, payload...))
+}
+
+func capturedUDP(sourcePort, destinationPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp, sourcePort)
+	binary.BigEndian.PutUint16(udp[2:], destinationPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	return capturedIP(17, true, append(udp, payload...))
+}
+
+func capturedIP(protocol byte, fromCamera bool, transport []byte) []byte {
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(transport)))
+	ip[9] = protocol
+	client, camera := []byte{10, 0, 0, 2}, []byte{10, 0, 0, 1}
+	if fromCamera {
+		client, camera = camera, client
+	}
+	copy(ip[12:], client)
+	copy(ip[16:], camera)
+	ethernet := make([]byte, 14)
+	binary.BigEndian.PutUint16(ethernet[12:], 0x0800)
+	return append(append(ethernet, ip...), transport...)
+}
+
+func capturedSession() [][]byte {
+	request, _ := libipcamera.CreateMessagePacket(libipcamera.REQUEST_FILE_LIST, &libipcamera.Uint32Payload{Value: 1})
+	list, _ := (&libipcamera.FileListPart{PartHeader: libipcamera.PartHeader{Parts: 1}, Data: []byte("/DCIM/A.MP4:100;/DCIM/B.JPG:200;")}).MarshalBinary()
+	reply := libipcamera.CreatePacket(libipcamera.CreateCommandHeader(libipcamera.FILE_LIST_CONTENT), list)
+	frameEnd, _ := (&libipcamera.FrameEnd{Elapsed: 33}).MarshalBinary()
+
+	return [][]byte{
+		capturedTCP(50000, 6666, 1000, request),
+		// The reply is split over two segments and the first one is retransmitted.
+		capturedTCP(6666, 50000, 5000, reply[:10]),
+		capturedTCP(6666, 50000, 5000, reply[:10]),
+		capturedTCP(6666, 50000, 5010, reply[10:]),
+		capturedUDP(40000, 6669, libipcamera.StreamPacket(1, libipcamera.STREAM_FRAME_DATA, make([]byte, 100))),
+		capturedUDP(40000, 6669, libipcamera.StreamPacket(2, libipcamera.STREAM_FRAME_DATA, make([]byte, 50))),
+		capturedUDP(40000, 6669, libipcamera.StreamPacket(3, libipcamera.STREAM_FRAME_END, frameEnd)),
+		capturedUDP(40000, 6669, libipcamera.StreamPacket(5, libipcamera.STREAM_FRAME_DATA, make([]byte, 10))),
+	}
+}
+
+func pcapFile(frames [][]byte) []byte {
+	file := &bytes.Buffer{}
+	binary.Write(file, binary.LittleEndian, []uint32{0xA1B2C3D4, 0x00040002, 0, 0, 65535, 1})
+	for i, frame := range frames {
+		binary.Write(file, binary.LittleEndian, []uint32{1700000000, uint32(i), uint32(len(frame)), uint32(len(frame))})
+		file.Write(frame)
+	}
+	return file.Bytes()
+}
+
+func pcapngFile(frames [][]byte) []byte {
+	file := &bytes.Buffer{}
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		binary.Write(file, binary.LittleEndian, []uint32{blockType, uint32(12 + len(body))})
+		file.Write(body)
+		binary.Write(file, binary.LittleEndian, uint32(12+len(body)))
+	}
+	block(0x0A0D0D0A, []byte{0x4D, 0x3C, 0x2B, 0x1A, 1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	// Ethernet with nanosecond timestamps (if_tsresol 9).
+	block(1, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0})
+	for i, frame := range frames {
+		timestamp := uint64(1700000000*time.Second) + uint64(i)
+		body := &bytes.Buffer{}
+		binary.Write(body, binary.LittleEndian, []uint32{0, uint32(timestamp >> 32), uint32(timestamp), uint32(len(frame)), uint32(len(frame))})
+		body.Write(frame)
+		block(6, body.Bytes())
+	}
+	return file.Bytes()
+}
+
+func TestReadCaptures(t *testing.T) {
+	captures := map[string][]byte{
+		"pcap":   pcapFile(capturedSession()),
+		"pcapng": pcapngFile(capturedSession()),
+	}
+	for format, capture := range captures {
+		records, err := libipcamera.ReadTrace(bytes.NewReader(capture), 6666)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if len(records) != 6 {
+			t.Fatalf("%s: expected 6 records, got %d", format, len(records))
+		}
+		if records[0].Direction != libipcamera.TraceSent || records[1].Direction != libipcamera.TraceReceived {
+			t.Fatalf("%s: wrong directions %s, %s", format, records[0].Direction, records[1].Direction)
+		}
+		if records[0].Time.Unix() != 1700000000 {
+			t.Fatalf("%s: wrong timestamp %s", format, records[0].Time)
+		}
+
+		var lines []string
+		decoder := libipcamera.TraceDecoder{}
+		for _, record := range records {
+			lines = append(lines, decoder.Decode(record)...)
+		}
+		text := strings.Join(lines, "\n")
+		for _, expected := range []string{
+			"-> REQUEST_FILE_LIST",
+			"<- FILE_LIST_CONTENT part 1/1",
+			"lista de arquivos com 2 arquivo(s)",
+			"/DCIM/B.JPG\t200",
+			"quadro 1: 150 bytes em 2 pacote(s), tempo 33ms",
+			"1 pacote(s) do fluxo perdido(s) antes da sequência 5",
+		} {
+			if !strings.Contains(text, expected) {
+				t.Fatalf("%s: %q missing from decoded capture:\n%s", format, expected, text)
+			}
+		}
+	}
+}
+
+func TestReadCaptureRejectsCorruptLengths(t *testing.T) {
+	pcap := pcapFile(capturedSession())
+	// The length of the first record claims 4 GiB.
+	binary.LittleEndian.PutUint32(pcap[24+8:], 0xFFFFFFFF)
+	pcapng := pcapngFile(capturedSession())
+	// The first packet block follows the 28 byte section and 32 byte interface blocks.
+	binary.LittleEndian.PutUint32(pcapng[28+32+4:], 0xFFFFFFF0)
+
+	for format, capture := range map[string][]byte{"pcap": pcap, "pcapng": pcapng} {
+		if _, err := libipcamera.ReadTrace(bytes.NewReader(capture), 6666); err == nil || !strings.Contains(err.Error(), "excede o limite") {
+			t.Fatalf("%s: expected a length error, got %v", format, err)
+		}
+	}
+}
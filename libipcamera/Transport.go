@@ -0,0 +1,81 @@
+package libipcamera
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Dialer opens the control connection to a camera. *net.Dialer implements it,
+// as do SOCKS dialers and dialers bound to a specific local address.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc adapts a function to the Dialer interface.
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// InterfaceDialer returns a Dialer that binds the connection to the address
+// of the network interface with the given name, e.g. the Wi-Fi adapter
+// connected to the camera's access point.
+func InterfaceDialer(name string) (Dialer, error) {
+	networks, err := interfaceNetworks(name)
+	if err != nil {
+		return nil, err
+	}
+	return &net.Dialer{LocalAddr: &net.TCPAddr{IP: networks[0].IP}}, nil
+}
+
+// interfaceNetworks returns the IPv4 networks of the network interface with
+// the given name, or an error when it has none.
+func interfaceNetworks(name string) ([]*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	networks, err := ipv4Networks(iface)
+	if err != nil {
+		return nil, err
+	}
+	if len(networks) == 0 {
+		return nil, errors.New("A interface " + name + " não possui um endereço IPv4")
+	}
+	return networks, nil
+}
+
+// ipv4Networks returns the IPv4 networks assigned to iface.
+func ipv4Networks(iface *net.Interface) ([]*net.IPNet, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			networks = append(networks, ipNet)
+		}
+	}
+	return networks, nil
+}
+
+// connDialer hands out a caller supplied connection exactly once.
+type connDialer struct {
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func (d *connDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.conn == nil {
+		return nil, errors.New("A conexão fornecida já foi usada e não pode ser reaberta")
+	}
+	conn := d.conn
+	d.conn = nil
+	return conn, nil
+}
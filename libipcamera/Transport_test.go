@@ -0,0 +1,64 @@
+package libipcamera_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestCameraWithConn(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	conn, err := net.Dial("tcp", emu.ControlAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	camera, err := libipcamera.CreateCameraWithConn(conn, "admin", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera.SetLogger(libipcamera.NopLogger())
+	defer camera.Disconnect()
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatal(err)
+	}
+	firmware, err := camera.GetFirmwareInfo()
+	if err != nil || firmware != defaultFirmware {
+		t.Fatalf("GetFirmwareInfo = %q, %v", firmware, err)
+	}
+
+	if err := camera.Connect(); err == nil {
+		t.Fatal("expected a supplied connection to be usable only once")
+	}
+}
+
+func TestCustomDialer(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera, _ := libipcamera.CreateCamera(net.ParseIP("192.0.2.1"), 6666, "admin", "12345")
+	camera.SetLogger(libipcamera.NopLogger())
+	defer camera.Disconnect()
+
+	var dialed string
+	camera.SetDialer(libipcamera.DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, emu.ControlAddr().String())
+	}))
+
+	if err := camera.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if dialed != "192.0.2.1:6666" {
+		t.Fatalf("dialer called with %q", dialed)
+	}
+	if err := camera.Login(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package libipcamera
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// UpgradeConfirmationToken must be passed in UpgradeConfig to confirm that
+// the firmware of the camera is to be replaced.
+const UpgradeConfirmationToken = "UPGRADE"
+
+// DefaultUpgradeMinBattery is the battery level in percent required by
+// UpgradeFirmware when UpgradeConfig.MinBattery is zero.
+const DefaultUpgradeMinBattery = 30
+
+// MaxFirmwareImageSize bounds the firmware files accepted by CheckFirmwareImage.
+const MaxFirmwareImageSize = 64 << 20
+
+// UpgradeConfig configures UpgradeFirmware.
+type UpgradeConfig struct {
+	// Confirmation must be UpgradeConfirmationToken.
+	Confirmation string
+	// MinBattery is the battery level in percent required unless the camera
+	// is charging, zero selects DefaultUpgradeMinBattery.
+	MinBattery int
+}
+
+// CheckFirmwareImage checks image, the content of a firmware file, against
+// model. The layout of the firmware files is unknown, so only what does not
+// depend on it is checked: the size, and that the image holds the model
+// name, as the firmware embeds the version string it reports. Problems are
+// reported as a FirmwareImageError.
+func CheckFirmwareImage(image []byte, model string) error {
+	if len(image) == 0 || len(image) > MaxFirmwareImageSize {
+		return &FirmwareImageError{Reason: fmt.Sprintf("arquivo de %d bytes, esperado de 1 a %d", len(image), MaxFirmwareImageSize)}
+	}
+	if model == "" || !bytes.Contains(image, []byte(model)) {
+		return &FirmwareImageError{Reason: fmt.Sprintf("o arquivo não menciona o modelo %q da câmera", model)}
+	}
+	return nil
+}
+
+// UpgradeFirmware would install image, the content of a firmware file, but
+// the commands uploading and applying a firmware image were never observed
+// on a real camera, so it cannot. It still runs the checks an upgrade
+// requires, without sending the image: the confirmation, the image against
+// the model reported by the camera with CheckFirmwareImage and the battery
+// against config.MinBattery. When they pass it returns an UnsupportedError;
+// copy the file to the SD card to upgrade instead.
+//
+// The battery is read with GetStatus, which requires experimental commands.
+func (c *Camera) UpgradeFirmware(ctx context.Context, image []byte, config UpgradeConfig) error {
+	if config.Confirmation != UpgradeConfirmationToken {
+		return ErrNotConfirmed
+	}
+	if config.MinBattery <= 0 {
+		config.MinBattery = DefaultUpgradeMinBattery
+	}
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+	current, err := c.GetFirmwareContext(requestCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if err := CheckFirmwareImage(image, current.Model); err != nil {
+		return err
+	}
+
+	requestCtx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+	status, err := c.GetStatusContext(requestCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if status.BatteryPercent < config.MinBattery && !status.Charging {
+		return &BatteryError{Percent: status.BatteryPercent, Required: config.MinBattery}
+	}
+
+	return &UnsupportedError{Reason: "os comandos de envio e instalação de firmware não foram observados em uma câmera real, copie o arquivo para o cartão SD"}
+}
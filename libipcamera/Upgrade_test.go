@@ -0,0 +1,53 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestUpgradeFirmwareChecksThenRefuses(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Firmware: defaultFirmware, Battery: 80})
+	camera := connectClient(t, emu)
+	image := append([]byte("\x00\x01SJ4000AIR V1.1 20210301\x00"), make([]byte, 1024)...)
+	confirmed := libipcamera.UpgradeConfig{Confirmation: libipcamera.UpgradeConfirmationToken}
+	ctx := context.Background()
+
+	if err := camera.UpgradeFirmware(ctx, image, libipcamera.UpgradeConfig{}); !errors.Is(err, libipcamera.ErrNotConfirmed) {
+		t.Fatalf("expected ErrNotConfirmed, got %v", err)
+	}
+	if err := camera.UpgradeFirmware(ctx, []byte("OTHERCAM V2.0"), confirmed); !errors.Is(err, libipcamera.ErrInvalidFirmwareImage) {
+		t.Fatalf("expected ErrInvalidFirmwareImage, got %v", err)
+	}
+	// The battery is read with a placeholder command the profile refuses.
+	var unsupported *libipcamera.UnsupportedError
+	if err := camera.UpgradeFirmware(ctx, image, confirmed); !errors.As(err, &unsupported) || unsupported.Command != libipcamera.REQUEST_STATUS {
+		t.Fatalf("expected REQUEST_STATUS to be refused, got %v", err)
+	}
+
+	camera.EnableExperimentalCommands(true)
+	emu.SetBattery(10, false)
+	var battery *libipcamera.BatteryError
+	if err := camera.UpgradeFirmware(ctx, image, confirmed); !errors.As(err, &battery) || battery.Percent != 10 || !errors.Is(err, libipcamera.ErrLowBattery) {
+		t.Fatalf("expected a BatteryError, got %v", err)
+	}
+
+	emu.SetBattery(10, true)
+	if err := camera.UpgradeFirmware(ctx, image, confirmed); !errors.As(err, &unsupported) || unsupported.Reason == "" {
+		t.Fatalf("expected an UnsupportedError with a reason, got %v", err)
+	}
+}
+
+func TestCheckFirmwareImage(t *testing.T) {
+	if err := libipcamera.CheckFirmwareImage([]byte("..SJ4000AIR V1.1.."), "SJ4000AIR"); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range [][]byte{nil, []byte("SJ5000X V1.0")} {
+		if err := libipcamera.CheckFirmwareImage(image, "SJ4000AIR"); !errors.Is(err, libipcamera.ErrInvalidFirmwareImage) {
+			t.Fatalf("image %q accepted: %v", image, err)
+		}
+	}
+}
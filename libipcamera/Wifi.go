@@ -0,0 +1,286 @@
+package libipcamera
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Limits of the access point configuration, those of WPA2-PSK and the 2.4 GHz band.
+const (
+	MaxWifiSSIDLength     = 32
+	MinWifiPasswordLength = 8
+	MaxWifiPasswordLength = 63
+	MaxWifiChannel        = 13
+)
+
+// WifiConfig is the configuration of the camera's access point and the
+// payload of WIFI_INFORMATION: a zero padded 32 byte SSID, a zero padded 64
+// byte password and the channel as uint32. The layout is a placeholder that
+// was not observed on a real camera.
+type WifiConfig struct {
+	SSID     string
+	Password string
+	Channel  int
+}
+
+func (p *WifiConfig) MarshalBinary() ([]byte, error) {
+	payload := make([]byte, 100)
+	copy(payload, p.SSID)
+	copy(payload[32:96], p.Password)
+	binary.LittleEndian.PutUint32(payload[96:], uint32(p.Channel))
+	return payload, nil
+}
+
+func (p *WifiConfig) UnmarshalBinary(data []byte) error {
+	if len(data) < 100 {
+		return errShortPayload
+	}
+	p.SSID = string(bytes.TrimRight(data[:32], "\x00"))
+	p.Password = string(bytes.TrimRight(data[32:96], "\x00"))
+	p.Channel = int(binary.LittleEndian.Uint32(data[96:]))
+	return nil
+}
+
+func (p *WifiConfig) String() string {
+	return fmt.Sprintf("ssid=%s password=%s channel=%d", p.SSID, RedactedValue, p.Channel)
+}
+
+// Validate checks the SSID, password and channel against the limits of the
+// access point. Invalid fields are reported as a SettingError.
+func (p *WifiConfig) Validate() error {
+	if len(p.SSID) == 0 || len(p.SSID) > MaxWifiSSIDLength {
+		return &SettingError{Setting: "wifi_ssid", Value: p.SSID, Allowed: []string{fmt.Sprintf("1 a %d bytes", MaxWifiSSIDLength)}}
+	}
+	if len(p.Password) < MinWifiPasswordLength || len(p.Password) > MaxWifiPasswordLength {
+		return &SettingError{Setting: "wifi_password", Value: fmt.Sprintf("de %d caracteres", len(p.Password)), Allowed: []string{fmt.Sprintf("%d a %d caracteres", MinWifiPasswordLength, MaxWifiPasswordLength)}}
+	}
+	if p.Channel < 1 || p.Channel > MaxWifiChannel {
+		return &SettingError{Setting: "wifi_channel", Value: fmt.Sprint(p.Channel), Allowed: []string{fmt.Sprintf("1 a %d", MaxWifiChannel)}}
+	}
+	return nil
+}
+
+// GetWifi reads the configuration of the camera's access point.
+func (c *Camera) GetWifi() (WifiConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.GetWifiContext(ctx)
+}
+
+// GetWifiContext reads the configuration of the camera's access point.
+func (c *Camera) GetWifiContext(ctx context.Context) (WifiConfig, error) {
+	if !c.IsLoggedIn() {
+		return WifiConfig{}, ErrNotLoggedIn
+	}
+	reply, err := c.Request(ctx, REQUEST_WIFI, nil, WIFI_INFORMATION)
+	if err != nil {
+		return WifiConfig{}, err
+	}
+	config := WifiConfig{}
+	if err := config.UnmarshalBinary(reply.Payload); err != nil {
+		return WifiConfig{}, &ProtocolError{Header: reply.Header, Reason: err.Error()}
+	}
+	return config, nil
+}
+
+// SetWifi changes the configuration of the camera's access point. Empty
+// fields of config keep their current value. The camera restarts its access
+// point after accepting the change, dropping the connection; rejoin the
+// network and call VerifyWifi to check that the change was applied.
+//
+// SET_WIFI is a placeholder that was not observed on a real camera, so
+// SetWifi fails with ErrUnverifiedCommand unless experimental commands are
+// enabled.
+func (c *Camera) SetWifi(config WifiConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.SetWifiContext(ctx, config)
+}
+
+// SetWifiContext changes the configuration of the camera's access point,
+// see SetWifi.
+func (c *Camera) SetWifiContext(ctx context.Context, config WifiConfig) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	if err := c.checkVerified(SET_WIFI); err != nil {
+		return err
+	}
+	if config.SSID == "" || config.Password == "" || config.Channel == 0 {
+		current, err := c.GetWifiContext(ctx)
+		if err != nil {
+			return err
+		}
+		config = mergeWifiConfig(current, config)
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	payload, _ := config.MarshalBinary()
+	if err := c.resultRequest(ctx, SET_WIFI, payload, WIFI_SET_ACCEPT); err != nil {
+		return err
+	}
+	c.log(LevelInfo, "Configuração Wi-Fi alterada", F("ssid", config.SSID), F("channel", config.Channel))
+	return nil
+}
+
+func mergeWifiConfig(current, changes WifiConfig) WifiConfig {
+	if changes.SSID != "" {
+		current.SSID = changes.SSID
+	}
+	if changes.Password != "" {
+		current.Password = changes.Password
+	}
+	if changes.Channel != 0 {
+		current.Channel = changes.Channel
+	}
+	return current
+}
+
+// SetCredentials changes the username and password the camera accepts in
+// LOGIN. On success they replace the credentials of this Camera, so the next
+// Login, including those of the reconnect supervisor, uses them.
+//
+// SET_LOGIN is a placeholder that was not observed on a real camera, so
+// SetCredentials fails with ErrUnverifiedCommand unless experimental
+// commands are enabled.
+func (c *Camera) SetCredentials(username, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return c.SetCredentialsContext(ctx, username, password)
+}
+
+// SetCredentialsContext changes the login credentials, see SetCredentials.
+func (c *Camera) SetCredentialsContext(ctx context.Context, username, password string) error {
+	if !c.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	if username == "" || len(username) > 64 {
+		return &SettingError{Setting: "username", Value: username, Allowed: []string{"1 a 64 bytes"}}
+	}
+	if password == "" || len(password) > 64 {
+		return &SettingError{Setting: "password", Value: fmt.Sprintf("de %d caracteres", len(password)), Allowed: []string{"1 a 64 bytes"}}
+	}
+	payload, _ := (&LoginRequest{Username: username, Password: password}).MarshalBinary()
+	if err := c.resultRequest(ctx, SET_LOGIN, payload, LOGIN_SET_ACCEPT); err != nil {
+		return err
+	}
+
+	c.stateMutex.Lock()
+	c.username, c.password = username, password
+	c.stateMutex.Unlock()
+	c.log(LevelInfo, "Credenciais de login alteradas", F("username", username))
+	return nil
+}
+
+func (c *Camera) credentials() (string, string) {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.username, c.password
+}
+
+// DefaultReloginTimeout bounds Relogin when its context has no deadline.
+const DefaultReloginTimeout = 2 * time.Minute
+
+// Relogin closes the connection and connects and logs in again with the
+// current credentials, retrying with the delays of DefaultReconnectPolicy
+// until ctx is done, or for DefaultReloginTimeout when ctx has no deadline.
+// It returns the error of the last attempt.
+//
+// Unlike Disconnect it keeps the status poller, the health monitor and the
+// reconnect supervisor running. A reconnect in progress is awaited first;
+// while Relogin runs the supervisor does not start, and when Relogin fails it
+// takes over the lost connection.
+func (c *Camera) Relogin(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultReloginTimeout)
+		defer cancel()
+	}
+	ctx, run, err := c.claimReconnect(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.relogin(ctx, run)
+	c.stateMutex.Lock()
+	if c.reconnectRun == run {
+		c.endReconnect()
+	}
+	c.stateMutex.Unlock()
+	if err != nil && !c.IsConnected() {
+		c.connectionLost(err)
+	}
+	return err
+}
+
+// claimReconnect waits for a reconnect in progress to end and makes the
+// caller the owner of the reconnection, so the supervisor does not start
+// while it runs. The returned context is cancelled by Disconnect.
+func (c *Camera) claimReconnect(ctx context.Context) (context.Context, uint64, error) {
+	for {
+		c.stateMutex.Lock()
+		if c.reconnectCancel == nil {
+			ctx, run := c.beginReconnect(ctx)
+			c.stateMutex.Unlock()
+			return ctx, run, nil
+		}
+		done := c.reconnectDone
+		c.stateMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-done:
+		}
+	}
+}
+
+func (c *Camera) relogin(ctx context.Context, run uint64) error {
+	c.closeConnection()
+	policy := DefaultReconnectPolicy()
+	delay := policy.InitialDelay
+	for {
+		err := c.reestablish(ctx, policy.AttemptTimeout, true)
+		if err == nil {
+			if c.finishReconnect(run) {
+				return nil
+			}
+			err = ErrDisconnected
+		}
+		c.log(LevelDebug, "Nova tentativa de login", F("delay", delay), F("error", err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// VerifyWifi reconnects with Relogin and checks that the camera reports the
+// SSID and channel of expected, and its password when not empty. A mismatch
+// is reported as a SettingError with Rejected set.
+func (c *Camera) VerifyWifi(ctx context.Context, expected WifiConfig) error {
+	if err := c.Relogin(ctx); err != nil {
+		return err
+	}
+	actual, err := c.GetWifiContext(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case expected.SSID != "" && actual.SSID != expected.SSID:
+		return &SettingError{Setting: "wifi_ssid", Value: expected.SSID, Rejected: true}
+	case expected.Password != "" && actual.Password != expected.Password:
+		return &SettingError{Setting: "wifi_password", Value: RedactedValue, Rejected: true}
+	case expected.Channel != 0 && actual.Channel != expected.Channel:
+		return &SettingError{Setting: "wifi_channel", Value: fmt.Sprint(expected.Channel), Rejected: true}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package libipcamera_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestWifiRequiresExperimental(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+
+	if _, err := camera.GetWifi(); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("GetWifi = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(libipcamera.REQUEST_WIFI) != 0 {
+		t.Fatal("placeholder Wi-Fi command was sent")
+	}
+
+	camera.EnableExperimentalCommands(true)
+	emu.Handle(libipcamera.REQUEST_WIFI, func(payload []byte) (uint32, []byte) {
+		reply := make([]byte, 100)
+		copy(reply, "RIG1-A")
+		copy(reply[32:], "12345678")
+		reply[96] = 6
+		return libipcamera.WIFI_INFORMATION, reply
+	})
+	wifi, err := camera.GetWifi()
+	if err != nil || wifi != (libipcamera.WifiConfig{SSID: "RIG1-A", Password: "12345678", Channel: 6}) {
+		t.Fatalf("GetWifi = %+v, %v", wifi, err)
+	}
+}
+
+func TestCredentialChangesRequireExperimental(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{Name: "RIG1-A", Firmware: defaultFirmware})
+	camera := connectClient(t, emu)
+
+	changes := libipcamera.WifiConfig{Password: "unidade-0042", Channel: 11}
+	if err := camera.SetWifi(changes); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("SetWifi = %v, expected ErrUnverifiedCommand", err)
+	}
+	if err := camera.SetCredentials("operador", "s3nha"); !errors.Is(err, libipcamera.ErrUnverifiedCommand) {
+		t.Fatalf("SetCredentials = %v, expected ErrUnverifiedCommand", err)
+	}
+	if emu.Received(libipcamera.REQUEST_WIFI) != 0 || emu.Received(libipcamera.SET_WIFI) != 0 || emu.Received(libipcamera.SET_LOGIN) != 0 {
+		t.Fatal("placeholder credential command sent to the camera")
+	}
+
+	camera.EnableExperimentalCommands(true)
+	if err := camera.SetWifi(libipcamera.WifiConfig{Password: "curta"}); !errors.Is(err, libipcamera.ErrInvalidSetting) {
+		t.Fatalf("expected ErrInvalidSetting, got %v", err)
+	}
+	if emu.Received(libipcamera.SET_WIFI) != 0 {
+		t.Fatal("invalid configuration sent to the camera")
+	}
+	if err := camera.SetWifi(changes); err != nil {
+		t.Fatal(err)
+	}
+	if wifi := emu.Wifi(); wifi.SSID != "RIG1-A" || wifi.Password != "unidade-0042" || wifi.Channel != 11 {
+		t.Fatalf("configuration not applied: %+v", wifi)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := camera.VerifyWifi(ctx, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := camera.SetCredentials("operador", "s3nha"); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Relogin(ctx); err != nil {
+		t.Fatalf("login with the new credentials: %s", err)
+	}
+	camera.Disconnect()
+	waitFor(t, func() bool { return emu.Clients() == 0 })
+	old := createClient(t, emu)
+	defer old.Disconnect()
+	if err := old.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Login(); err == nil {
+		t.Fatal("old credentials still accepted")
+	}
+}
+
+func TestReloginKeepsSupervisors(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	restored := make(chan struct{}, 1)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			if event.Type == libipcamera.ReconnectRestored {
+				restored <- struct{}{}
+			}
+		},
+	})
+	camera.EnableHealthMonitor(libipcamera.HealthPolicy{})
+	camera.StartStatusPoller(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := camera.Relogin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !camera.IsLoggedIn() || emu.Received(libipcamera.LOGIN) != 2 {
+		t.Fatalf("logged in %v after %d logins", camera.IsLoggedIn(), emu.Received(libipcamera.LOGIN))
+	}
+	waitFor(t, func() bool { return emu.Clients() == 1 })
+	select {
+	case <-restored:
+		t.Fatal("the supervisor ran during Relogin")
+	default:
+	}
+
+	monitoring, polling := libipcamera.Supervisors(camera)
+	if !monitoring || !polling {
+		t.Fatalf("Relogin stopped the health monitor (%v) or the status poller (%v)", monitoring, polling)
+	}
+
+	// The supervisor still restores a lost connection.
+	emu.DisconnectClients()
+	select {
+	case <-restored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not restored after Relogin")
+	}
+}
+
+func TestReloginAwaitsReconnect(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	restored := make(chan struct{}, 1)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 100 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			if event.Type == libipcamera.ReconnectRestored {
+				restored <- struct{}{}
+			}
+		},
+	})
+	emu.DisconnectClients()
+	waitFor(t, func() bool { return !camera.IsConnected() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := camera.Relogin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-restored:
+	default:
+		t.Fatal("Relogin did not wait for the reconnect in progress")
+	}
+	if !camera.IsLoggedIn() || emu.Received(libipcamera.LOGIN) != 3 {
+		t.Fatalf("logged in %v after %d logins", camera.IsLoggedIn(), emu.Received(libipcamera.LOGIN))
+	}
+}
+
+func TestReloginFailureHandsOverToSupervisor(t *testing.T) {
+	emu := startEmulator(t, emulator.Config{})
+	camera := connectClient(t, emu)
+	events := make(chan libipcamera.ReconnectEvent, 16)
+	camera.EnableReconnect(libipcamera.ReconnectPolicy{
+		InitialDelay: 10 * time.Millisecond,
+		OnEvent: func(event libipcamera.ReconnectEvent) {
+			select {
+			case events <- event:
+			default:
+			}
+		},
+	})
+
+	dropReplies(emu, libipcamera.LOGIN)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := camera.Relogin(ctx); err == nil {
+		t.Fatal("Relogin succeeded without a login reply")
+	}
+	select {
+	case event := <-events:
+		if event.Type != libipcamera.ReconnectLost {
+			t.Fatalf("unexpected first event %s", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the supervisor did not take over after Relogin failed")
+	}
+	camera.DisableReconnect()
+}
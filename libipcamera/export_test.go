@@ -0,0 +1,97 @@
+package libipcamera
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// The tests of the package run against the emulator from the external
+// libipcamera_test package. These helpers expose the internals they check.
+
+// HandlerCount returns the number of handlers registered for messageTypes,
+// for every message type if none is given.
+func HandlerCount(c *Camera, messageTypes ...uint32) int {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+	if len(messageTypes) == 0 {
+		count := 0
+		for _, handlers := range c.messageHandlers {
+			count += len(handlers)
+		}
+		return count
+	}
+	count := 0
+	for _, messageType := range messageTypes {
+		count += len(c.messageHandlers[messageType])
+	}
+	return count
+}
+
+// PendingCount returns the number of requests waiting for a reply.
+func PendingCount(c *Camera) int {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	return len(c.pending)
+}
+
+// LockSend blocks every write of c until the returned function is called.
+func LockSend(c *Camera) (unlock func()) {
+	c.sendMutex.Lock()
+	return c.sendMutex.Unlock
+}
+
+// Supervisors reports whether the health monitor and the status poller of c run.
+func Supervisors(c *Camera) (monitoring, polling bool) {
+	c.healthMutex.Lock()
+	monitoring = c.healthCancel != nil
+	c.healthMutex.Unlock()
+	c.stateMutex.RLock()
+	polling = c.statusCancel != nil
+	c.stateMutex.RUnlock()
+	return monitoring, polling
+}
+
+// Endpoint returns the address and credentials c connects with.
+func Endpoint(c *Camera) (ip net.IP, port int, username, password string) {
+	return c.ipAddress, c.port, c.username, c.password
+}
+
+// UnregisterMessageType removes messageType from the registry and the
+// notifications.
+func UnregisterMessageType(messageType uint32) {
+	notificationMutex.Lock()
+	delete(notificationEvents, messageType)
+	notificationMutex.Unlock()
+	registryMutex.Lock()
+	delete(registry, messageType)
+	registryMutex.Unlock()
+}
+
+// ReplyTypes returns the reply types of the get and set commands of s.
+func (s *Setting) ReplyTypes() (get, set uint32) {
+	return s.getReply(), s.setReply()
+}
+
+// StreamPacket returns a message of the preview stream.
+func StreamPacket(sequence, messageType uint16, payload []byte) []byte {
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint16(packet, 0xBCDE)
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(payload)))
+	binary.BigEndian.PutUint16(packet[4:], sequence)
+	binary.BigEndian.PutUint16(packet[6:], messageType)
+	return append(packet, payload...)
+}
+
+// WaitFor fails the test unless condition becomes true within 2s.
+func WaitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/icza/bitio"
 )
 
-
 type Header struct {
 	Magic       uint16
 	Length      uint16
@@ -16,20 +15,28 @@ type Header struct {
 }
 
 func (h *Header) String() string {
-	return fmt.Sprintf("{ Header Magic=0x%X, Length=%d, MessageType=0x%X }", h.Magic, h.Length, h.MessageType)
+	return fmt.Sprintf("{ Header Magic=0x%X, Length=%d, MessageType=0x%X (%s) }", h.Magic, h.Length, h.MessageType, MessageName(h.MessageType))
 }
 
-
 type Message struct {
 	Header  Header
 	Payload []byte
 }
 
+// String describes the message using the codec registered for its type, e.g.
+// "FILE_LIST_CONTENT part 2/5 (1024 bytes)", falling back to a hex dump.
 func (m *Message) String() string {
-	return fmt.Sprintf("{ Message\n\tHeader=%s,\n\tPayload=\n%s\n}", m.Header.String(), hex.Dump(m.Payload))
+	codec, err := m.Decode()
+	if err != nil {
+		return fmt.Sprintf("%s carga útil inválida (%d bytes)\n%s", MessageName(m.Header.MessageType), len(m.Payload), hex.Dump(m.Payload))
+	}
+	description := codec.String()
+	if description == "" {
+		return MessageName(m.Header.MessageType)
+	}
+	return MessageName(m.Header.MessageType) + " " + description
 }
 
-
 type streamHeader struct {
 	Magic          uint16
 	Length         uint16
@@ -37,6 +44,9 @@ type streamHeader struct {
 	MessageType    uint16
 }
 
+func (h *streamHeader) toHeader() Header {
+	return Header{Magic: h.Magic, Length: h.Length, MessageType: uint32(h.MessageType)}
+}
 
 func CreatePacket(header Header, payload []byte) []byte {
 	header.Length = (uint16)(len(payload))
@@ -50,7 +60,6 @@ func CreatePacket(header Header, payload []byte) []byte {
 	return buf.Bytes()
 }
 
-
 func CreateCommandHeader(command uint32) Header {
 	return Header{
 		Magic:       0xABCD,
@@ -59,17 +68,12 @@ func CreateCommandHeader(command uint32) Header {
 	}
 }
 
-
 func CreateLoginPacket(username, password string) []byte {
 	header := CreateCommandHeader(LOGIN) // Login
-	payload := make([]byte, 128)
-	copy(payload, []byte(username))
-	copy(payload[64:], []byte(password))
-
+	payload, _ := (&LoginRequest{Username: username, Password: password}).MarshalBinary()
 	return CreatePacket(header, payload)
 }
 
-
 func CreateCommandPacket(command uint32) []byte {
 	header := CreateCommandHeader(command)
 	return CreatePacket(header, []byte{})
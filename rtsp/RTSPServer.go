@@ -5,7 +5,6 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
 	"strings"
@@ -20,36 +19,60 @@ type Server struct {
 	listener      net.Listener
 	remoteRTPPort int
 	remoteIP      string
+	streamPort    int
 	rtpRelay      *libipcamera.RTPRelay
-	camera        *libipcamera.Camera
+	camera        libipcamera.CaptureController
 	sdp           string
 	context       context.Context
+	logger        libipcamera.Logger
+	tracer        libipcamera.Tracer
 }
 
 // CreateServer creates a new Server instance
-func CreateServer(ctx context.Context, localIP string, port int, camera *libipcamera.Camera) *Server {
+func CreateServer(ctx context.Context, localIP string, port int, camera libipcamera.CaptureController) *Server {
 	server := &Server{
 		localIP:       localIP,
 		localPort:     port,
 		camera:        camera,
 		remoteRTPPort: 0,
 		remoteIP:      "",
+		streamPort:    libipcamera.DefaultStreamPort,
 		sdp:           "v=0\r\ns=ActionCamera\r\nm=video 0 RTP/AVP 99\r\na=rtpmap:99 H264/90000",
 		context:       ctx,
+		logger:        libipcamera.NewRedactingLogger(libipcamera.NewStdLogger(nil, libipcamera.LevelInfo)),
 	}
 	return server
 }
 
+// SetLogger replaces the logger of the server and of the RTP relays it creates.
+func (s *Server) SetLogger(logger libipcamera.Logger) {
+	if logger == nil {
+		logger = libipcamera.NopLogger()
+	}
+	s.logger = libipcamera.NewRedactingLogger(logger)
+}
+
+// SetStreamPort sets the local UDP port the camera sends the preview stream
+// to, see Camera.StreamPort.
+func (s *Server) SetStreamPort(port int) {
+	s.streamPort = port
+}
+
+// SetTracer taps the preview stream of the RTP relays the server creates.
+func (s *Server) SetTracer(tracer libipcamera.Tracer) {
+	s.tracer = tracer
+}
+
 // ListenAndServe starts listening for connections and handles them
 func (s *Server) ListenAndServe() error {
-	log.Printf("%+v\n", *s)
+	s.logger.Log(libipcamera.LevelDebug, "Starting RTSP server", libipcamera.F("address", s.localIP), libipcamera.F("port", s.localPort))
 	listener, err := net.Listen("tcp4", fmt.Sprintf("%s:%d", s.localIP, s.localPort))
 	if err != nil {
 		return err
 	}
 	s.listener = listener
 
-	log.Printf("RTSP Server waiting for connections on %s:%d\n", s.localIP, s.localPort)
+	s.logger.Log(libipcamera.LevelInfo, "RTSP Server waiting for connections", libipcamera.F("address", s.localIP), libipcamera.F("port", s.localPort))
 
 	for {
 		select {
@@ -59,10 +82,10 @@ func (s *Server) ListenAndServe() error {
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Printf("ERROR accepting connection: %s\n", err)
+				s.logger.Log(libipcamera.LevelError, "ERROR accepting connection", libipcamera.F("error", err))
 			}
 
-			log.Printf("Accepted new RTSP Client %s\n", conn.RemoteAddr().String())
+			s.logger.Log(libipcamera.LevelInfo, "Accepted new RTSP Client", libipcamera.F("client", conn.RemoteAddr().String()))
 
 			go s.handleClient(conn)
 		}
@@ -85,11 +108,11 @@ func (s *Server) handleClient(conn net.Conn) error {
 }
 
 func (s *Server) handleRequest(packet []string, conn net.Conn) {
-	fmt.Printf("C->S:\n%s\n", packet)
+	s.logger.Log(libipcamera.LevelDebug, "C->S", libipcamera.F("request", packet))
 
 	request := strings.Split(packet[0], " ")
 	if len(request) != 3 {
-		log.Printf("Received invalid request")
+		s.logger.Log(libipcamera.LevelWarn, "Received invalid request", libipcamera.F("request", packet[0]))
 		return
 	}
 
@@ -120,13 +143,13 @@ func (s *Server) handleRequest(packet []string, conn net.Conn) {
 		rtpDescription := transportDescription[len(transportDescription)-1]
 		remoteRTPPort, err := strconv.ParseInt(strings.Split(strings.Split(rtpDescription, "=")[1], "-")[0], 10, 32)
 		if err != nil {
-			log.Printf("ERROR Parsing RTP description: %s\n", err)
+			s.logger.Log(libipcamera.LevelError, "ERROR Parsing RTP description", libipcamera.F("error", err))
 			return
 		}
 		s.remoteRTPPort = int(remoteRTPPort)
 		s.remoteIP = (conn.RemoteAddr().(*net.TCPAddr)).IP.String()
 
-		log.Printf("Preparing to Stream to %s:%d\n", s.remoteIP, s.remoteRTPPort)
+		s.logger.Log(libipcamera.LevelInfo, "Preparing to Stream", libipcamera.F("address", s.remoteIP), libipcamera.F("port", s.remoteRTPPort))
 
 		writeStatus(conn, 200, "OK")
 		replyCSeq(conn, headers)
@@ -135,7 +158,25 @@ func (s *Server) handleRequest(packet []string, conn net.Conn) {
 		conn.Write([]byte("\r\n"))
 
 	case "PLAY":
-		s.rtpRelay = libipcamera.CreateRTPRelay(s.context, net.ParseIP(s.remoteIP), s.remoteRTPPort)
+		rtpRelay, err := libipcamera.CreateRTPRelayOnPort(s.context, s.streamPort, net.ParseIP(s.remoteIP), s.remoteRTPPort)
+		if err != nil {
+			s.logger.Log(libipcamera.LevelError, "ERROR creating RTP relay", libipcamera.F("error", err))
+			writeStatus(conn, 500, "Internal Server Error")
+			replyCSeq(conn, headers)
+			conn.Write([]byte("\r\n"))
+			return
+		}
+		rtpRelay.SetLogger(s.logger)
+		if s.tracer != nil {
+			rtpRelay.SetTracer(s.tracer)
+		}
+		s.rtpRelay = rtpRelay
+		go func() {
+			<-rtpRelay.Done()
+			if err := rtpRelay.Err(); err != nil {
+				s.logger.Log(libipcamera.LevelError, "RTP relay failed", libipcamera.F("error", err))
+			}
+		}()
 		s.camera.StartPreviewStream()
 
 		writeStatus(conn, 200, "OK")
@@ -144,7 +185,9 @@ func (s *Server) handleRequest(packet []string, conn net.Conn) {
 		writeHeader(conn, "RTP-Info", "url="+request[1]+";seq=10;rtptime=10")
 		conn.Write([]byte("\r\n"))
 	case "TEARDOWN":
-		s.rtpRelay.Stop()
+		if s.rtpRelay != nil {
+			s.rtpRelay.Stop()
+		}
 		writeStatus(conn, 200, "OK")
 		replyCSeq(conn, headers)
 		conn.Write([]byte("\r\n"))
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+const shellHelp = `Comandos:
+  send TIPO [CARGA]             envia TIPO com a carga útil em hex
+  request TIPO RESPOSTA [CARGA] envia TIPO e aguarda RESPOSTA
+  wait TIPO [TEMPO]             aguarda uma mensagem TIPO recebida após o último send
+  sleep TEMPO                   pausa, ex.: 500ms
+  timeout [TEMPO]               mostra ou altera o tempo de espera padrão
+  names [FILTRO]                lista os tipos de mensagem conhecidos
+  health                        mostra os keepalives recebidos da câmera
+  history                       lista os comandos anteriores
+  !N, !!, !PREFIXO              repete o comando N, o último ou o último iniciado por PREFIXO
+  help                          mostra esta ajuda
+  quit                          sai
+TIPO é um nome (REQUEST_FIRMWARE_INFO) ou um valor hex de até 32 bits (A034, 0xA034).
+CARGA pode ser dividida em vários argumentos (0100 0000). Linhas iniciadas por # são ignoradas.
+O console não edita a linha; para usar as setas, execute-o com rlwrap (rlwrap actioncam cmd).`
+
+var errShellQuit = errors.New("quit")
+
+// shellCamera is what the shell needs of the camera.
+type shellCamera interface {
+	libipcamera.ProtocolController
+	Health() libipcamera.HealthStats
+}
+
+// shell is the interactive protocol console of the cmd command. Every message
+// received from the camera is printed decoded, keepalives excepted.
+type shell struct {
+	camera      shellCamera
+	output      io.Writer
+	interactive bool
+	timeout     time.Duration
+	history     []string
+	historyFile string
+
+	outputMutex sync.Mutex
+
+	// receivedMutex guards the messages received since the last send and
+	// the channel closed when another one arrives.
+	receivedMutex sync.Mutex
+	received      []*libipcamera.Message
+	arrived       chan struct{}
+}
+
+func newShell(camera shellCamera, output io.Writer, interactive bool) *shell {
+	return &shell{camera: camera, output: output, interactive: interactive, timeout: libipcamera.DefaultRequestTimeout, arrived: make(chan struct{})}
+}
+
+func (s *shell) printf(format string, args ...interface{}) {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+	fmt.Fprintf(s.output, format, args...)
+}
+
+func (s *shell) observe(message *libipcamera.Message) {
+	messageType := message.Header.MessageType
+	if messageType == libipcamera.ALIVE_REQUEST || messageType == libipcamera.ALIVE_RESPONSE {
+		return
+	}
+	s.printf("<- %s\n", message)
+
+	s.receivedMutex.Lock()
+	defer s.receivedMutex.Unlock()
+	s.received = append(s.received, message)
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+}
+
+// loadHistory reads the history of previous interactive sessions.
+func (s *shell) loadHistory(path string) {
+	s.historyFile = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+}
+
+func (s *shell) addHistory(line string) {
+	s.history = append(s.history, line)
+	if s.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(s.historyFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// expandHistory returns the command of the history referenced by line: !N
+// for command N, !! for the last command and !PREFIX for the last command
+// starting with PREFIX.
+func (s *shell) expandHistory(line string) (string, error) {
+	reference := line[1:]
+	if reference == "!" && len(s.history) > 0 {
+		return s.history[len(s.history)-1], nil
+	}
+	if index, err := strconv.Atoi(reference); err == nil {
+		if index >= 1 && index <= len(s.history) {
+			return s.history[index-1], nil
+		}
+	} else if reference != "" && reference != "!" {
+		for i := len(s.history) - 1; i >= 0; i-- {
+			if strings.HasPrefix(s.history[i], reference) {
+				return s.history[i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("comando %s não está no histórico", line)
+}
+
+// run executes the commands read from input until quit, the end of input or
+// a cancelled ctx. Outside interactive mode the first failing command stops
+// the script and its error is returned.
+func (s *shell) run(ctx context.Context, input io.Reader) error {
+	stop := s.camera.Observe(s.observe)
+	defer stop()
+
+	scanner := bufio.NewScanner(input)
+	lineNumber := 0
+	for {
+		if s.interactive {
+			s.printf("> ")
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Scripts echo every line, the terminal only the expanded ones.
+		if strings.HasPrefix(line, "!") {
+			expanded, err := s.expandHistory(line)
+			if err != nil {
+				if !s.interactive {
+					s.printf("> %s\n", line)
+				}
+				s.printf("ERRO: %s\n", err)
+				continue
+			}
+			line = expanded
+			s.printf("> %s\n", line)
+		} else if !s.interactive {
+			s.printf("> %s\n", line)
+		}
+		if line != "history" {
+			s.addHistory(line)
+		}
+
+		err := s.execute(ctx, line)
+		if err == errShellQuit {
+			return nil
+		}
+		if err != nil {
+			if !s.interactive {
+				return fmt.Errorf("linha %d: %s", lineNumber, err)
+			}
+			s.printf("ERRO: %s\n", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *shell) execute(ctx context.Context, line string) error {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+
+	switch command {
+	case "send":
+		if len(args) < 1 {
+			return errors.New("uso: send TIPO [CARGA]")
+		}
+		messageType, payload, err := parseShellMessage(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		s.receivedMutex.Lock()
+		s.received = nil
+		s.receivedMutex.Unlock()
+		s.printf("-> %s\n", &libipcamera.Message{Header: libipcamera.CreateCommandHeader(messageType), Payload: payload})
+		return s.camera.SendPacket(libipcamera.CreatePacket(libipcamera.CreateCommandHeader(messageType), payload))
+	case "request":
+		if len(args) < 2 {
+			return errors.New("uso: request TIPO RESPOSTA [CARGA]")
+		}
+		messageType, payload, err := parseShellMessage(args[0], args[2:])
+		if err != nil {
+			return err
+		}
+		replyType, err := parseMessageType(args[1])
+		if err != nil {
+			return err
+		}
+		// Raw IDs are sent like those of send, whatever the model profile lists.
+		requestCtx, cancel := context.WithTimeout(libipcamera.WithoutModelCheck(ctx), s.timeout)
+		defer cancel()
+		s.printf("-> %s\n", &libipcamera.Message{Header: libipcamera.CreateCommandHeader(messageType), Payload: payload})
+		_, err = s.camera.Request(requestCtx, messageType, payload, replyType)
+		return err
+	case "wait":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("uso: wait TIPO [TEMPO]")
+		}
+		messageType, err := parseMessageType(args[0])
+		if err != nil {
+			return err
+		}
+		timeout := s.timeout
+		if len(args) == 2 {
+			if timeout, err = time.ParseDuration(args[1]); err != nil {
+				return err
+			}
+		}
+		return s.wait(ctx, messageType, timeout)
+	case "sleep":
+		if len(args) != 1 {
+			return errors.New("uso: sleep TEMPO")
+		}
+		duration, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(duration):
+			return nil
+		}
+	case "timeout":
+		if len(args) == 0 {
+			s.printf("%s\n", s.timeout)
+			return nil
+		}
+		timeout, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		s.timeout = timeout
+	case "names":
+		for _, messageType := range libipcamera.MessageTypes() {
+			name := libipcamera.MessageName(messageType)
+			if len(args) == 0 || strings.Contains(name, strings.ToUpper(args[0])) {
+				s.printf("0x%04X %s\n", messageType, name)
+			}
+		}
+	case "health":
+		health := s.camera.Health()
+		s.printf("última mensagem há %s, último keepalive há %s, intervalo entre keepalives %s, ida e volta %s, intervalos perdidos %d\n",
+			time.Since(health.LastSeen).Round(time.Millisecond), time.Since(health.LastKeepalive).Round(time.Millisecond), health.KeepaliveInterval.Round(time.Millisecond), health.RoundTrip.Round(time.Millisecond), health.Missed)
+	case "history":
+		for i, entry := range s.history {
+			s.printf("%4d  %s\n", i+1, entry)
+		}
+	case "help":
+		s.printf("%s\n", shellHelp)
+	case "quit", "exit":
+		return errShellQuit
+	default:
+		return fmt.Errorf("comando desconhecido %s, digite help", command)
+	}
+	return nil
+}
+
+// wait returns when a message of messageType, received since the last send,
+// is found. The message is consumed so the next wait looks for another one.
+func (s *shell) wait(ctx context.Context, messageType uint32, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		s.receivedMutex.Lock()
+		arrived := s.arrived
+		for i, message := range s.received {
+			if message.Header.MessageType == messageType {
+				s.received = append(s.received[:i], s.received[i+1:]...)
+				s.receivedMutex.Unlock()
+				return nil
+			}
+		}
+		s.receivedMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("nenhuma mensagem %s recebida em %s", libipcamera.MessageName(messageType), timeout)
+		case <-arrived:
+		}
+	}
+}
+
+// parseMessageType accepts a registered message name or a hexadecimal value.
+func parseMessageType(text string) (uint32, error) {
+	if messageType, ok := libipcamera.MessageTypeByName(strings.ToUpper(text)); ok {
+		return messageType, nil
+	}
+	messageType, err := parseCommandID(text)
+	if err != nil {
+		return 0, fmt.Errorf("tipo de mensagem desconhecido: %s", text)
+	}
+	return messageType, nil
+}
+
+func parseShellMessage(messageType string, payload []string) (uint32, []byte, error) {
+	parsedType, err := parseMessageType(messageType)
+	if err != nil {
+		return 0, nil, err
+	}
+	parsedPayload, err := hex.DecodeString(strings.Join(payload, ""))
+	if err != nil {
+		return 0, nil, fmt.Errorf("carga útil inválida: %s", err)
+	}
+	return parsedType, parsedPayload, nil
+}
+
+// shellHistoryPath returns the file keeping the history of interactive sessions.
+func shellHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".actioncam_history")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thxssio/CamOpen/emulator"
+	"github.com/thxssio/CamOpen/libipcamera"
+)
+
+func TestParseShellMessage(t *testing.T) {
+	cases := []struct {
+		messageType string
+		payload     []string
+		expected    uint32
+		data        []byte
+		fails       bool
+	}{
+		{messageType: "REQUEST_FIRMWARE_INFO", expected: libipcamera.REQUEST_FIRMWARE_INFO, data: []byte{}},
+		{messageType: "take_picture", expected: libipcamera.TAKE_PICTURE, data: []byte{}},
+		{messageType: "A034", expected: 0xA034, data: []byte{}},
+		{messageType: "0xA034", expected: 0xA034, data: []byte{}},
+		{messageType: "0x0001A034", expected: 0x0001A034, data: []byte{}},
+		{messageType: "FFFFFFFF", expected: 0xFFFFFFFF, data: []byte{}},
+		{messageType: "0xA03A", payload: []string{"0100", "0000"}, expected: 0xA03A, data: []byte{0x01, 0x00, 0x00, 0x00}},
+		{messageType: "0xA03A", payload: []string{"01", "00", "ff"}, expected: 0xA03A, data: []byte{0x01, 0x00, 0xFF}},
+		{messageType: "1FFFFFFFF", fails: true},
+		{messageType: "NO_SUCH_MESSAGE", fails: true},
+		{messageType: "0xA034", payload: []string{"010"}, fails: true},
+		{messageType: "0xA034", payload: []string{"zz"}, fails: true},
+	}
+	for _, c := range cases {
+		messageType, payload, err := parseShellMessage(c.messageType, c.payload)
+		if c.fails {
+			if err == nil {
+				t.Errorf("parseShellMessage(%q, %q) accepted", c.messageType, c.payload)
+			}
+			continue
+		}
+		if err != nil || messageType != c.expected || !bytes.Equal(payload, c.data) {
+			t.Errorf("parseShellMessage(%q, %q) = 0x%X, % X, %v, expected 0x%X, % X", c.messageType, c.payload, messageType, payload, err, c.expected, c.data)
+		}
+	}
+}
+
+func TestShellHistory(t *testing.T) {
+	s := newShell(nil, &bytes.Buffer{}, true)
+	s.history = []string{"send TAKE_PICTURE", "request REQUEST_FIRMWARE_INFO FIRMWARE_INFORMATION", "send 0xA03A 01000000"}
+
+	cases := []struct {
+		line     string
+		expected string
+	}{
+		{"!1", "send TAKE_PICTURE"},
+		{"!3", "send 0xA03A 01000000"},
+		{"!!", "send 0xA03A 01000000"},
+		{"!send", "send 0xA03A 01000000"},
+		{"!send TAKE", "send TAKE_PICTURE"},
+		{"!req", "request REQUEST_FIRMWARE_INFO FIRMWARE_INFORMATION"},
+		{"!0", ""},
+		{"!4", ""},
+		{"!-1", ""},
+		{"!", ""},
+		{"!wait", ""},
+	}
+	for _, c := range cases {
+		expanded, err := s.expandHistory(c.line)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("expandHistory(%q) = %q, expected an error", c.line, expanded)
+			}
+			continue
+		}
+		if err != nil || expanded != c.expected {
+			t.Errorf("expandHistory(%q) = %q, %v, expected %q", c.line, expanded, err, c.expected)
+		}
+	}
+
+	if _, err := newShell(nil, &bytes.Buffer{}, true).expandHistory("!!"); err == nil {
+		t.Error("!! accepted with an empty history")
+	}
+}
+
+// scriptedCamera records the packets sent by the shell.
+type scriptedCamera struct {
+	shellCamera
+	sent [][]byte
+}
+
+func (c *scriptedCamera) SendPacket(packet []byte) error {
+	c.sent = append(c.sent, packet)
+	return nil
+}
+
+func TestShellWaitConsumesMessages(t *testing.T) {
+	camera := &scriptedCamera{}
+	s := newShell(camera, &bytes.Buffer{}, false)
+	message := func(messageType uint32) *libipcamera.Message {
+		return &libipcamera.Message{Header: libipcamera.CreateCommandHeader(messageType), Payload: []byte{}}
+	}
+	ctx := context.Background()
+
+	s.observe(message(libipcamera.PICTURE_SAVED))
+	if err := s.execute(ctx, "send TAKE_PICTURE"); err != nil {
+		t.Fatal(err)
+	}
+	if len(camera.sent) != 1 {
+		t.Fatalf("%d packets sent", len(camera.sent))
+	}
+	if err := s.wait(ctx, libipcamera.PICTURE_SAVED, 10*time.Millisecond); err == nil {
+		t.Fatal("wait matched a message received before the send")
+	}
+
+	s.observe(message(libipcamera.PICTURE_SAVED))
+	s.observe(message(libipcamera.ALIVE_REQUEST))
+	s.observe(message(libipcamera.PICTURE_SAVED))
+	for i := 0; i < 2; i++ {
+		if err := s.execute(ctx, "wait PICTURE_SAVED 10ms"); err != nil {
+			t.Fatalf("wait %d: %s", i+1, err)
+		}
+	}
+	if err := s.execute(ctx, "wait PICTURE_SAVED 10ms"); err == nil {
+		t.Fatal("wait matched a consumed message")
+	}
+	if err := s.execute(ctx, "wait ALIVE_REQUEST 10ms"); err == nil {
+		t.Fatal("wait matched a keepalive")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.observe(message(libipcamera.PICTURE_SAVED))
+	}()
+	if err := s.execute(ctx, "wait PICTURE_SAVED 1s"); err != nil {
+		t.Fatalf("wait for a later message: %s", err)
+	}
+}
+
+func startShellCamera(t *testing.T) (*libipcamera.Camera, *emulator.Emulator) {
+	t.Helper()
+	emu := emulator.CreateEmulator(emulator.Config{
+		ControlAddress:     "127.0.0.1:0",
+		HTTPAddress:        "127.0.0.1:0",
+		DiscoveryAddresses: []string{"127.0.0.1:0"},
+		AliveInterval:      20 * time.Millisecond,
+		Firmware:           "SJ4000AIR SHELL V1.0 20200101",
+	})
+	if err := emu.Start(); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	t.Cleanup(func() { emu.Close() })
+
+	camera, err := libipcamera.CreateCamera(emu.ControlAddr().IP, emu.ControlAddr().Port, "admin", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera.SetLogger(libipcamera.NopLogger())
+	if err := camera.Connect(); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	t.Cleanup(camera.Disconnect)
+	if err := camera.Login(); err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	// Like connectAndLogin, select the SJ4000AIR profile.
+	if _, err := camera.GetFirmware(); err != nil {
+		t.Fatalf("GetFirmware: %s", err)
+	}
+	return camera, emu
+}
+
+func TestShellScript(t *testing.T) {
+	camera, _ := startShellCamera(t)
+
+	script := `# firmware twice, once raw and once as a request
+send REQUEST_FIRMWARE_INFO
+wait FIRMWARE_INFORMATION 1s
+request 0xA034 FIRMWARE_INFORMATION
+
+timeout 50ms
+send TAKE_PICTURE
+wait PICTURE_SAVED
+quit
+send TAKE_PICTURE
+`
+	output := &bytes.Buffer{}
+	if err := newShell(camera, output, false).run(context.Background(), strings.NewReader(script)); err != nil {
+		t.Fatalf("run: %s\n%s", err, output)
+	}
+	text := output.String()
+	if strings.Count(text, "<- FIRMWARE_INFORMATION") != 2 || !strings.Contains(text, "SJ4000AIR SHELL") {
+		t.Fatalf("replies not shown decoded:\n%s", text)
+	}
+	if strings.Count(text, "> send TAKE_PICTURE") != 1 {
+		t.Fatalf("script continued after quit:\n%s", text)
+	}
+	if strings.Contains(text, "ALIVE") {
+		t.Fatalf("keepalives shown:\n%s", text)
+	}
+}
+
+func TestShellScriptStopsAtFirstError(t *testing.T) {
+	camera, _ := startShellCamera(t)
+
+	script := "send TAKE_PICTURE\nwait FIRMWARE_INFORMATION 50ms\nsend TAKE_PICTURE\n"
+	output := &bytes.Buffer{}
+	err := newShell(camera, output, false).run(context.Background(), strings.NewReader(script))
+	if err == nil || !strings.HasPrefix(err.Error(), "linha 2:") {
+		t.Fatalf("run = %v, expected an error on line 2", err)
+	}
+	if strings.Count(output.String(), "> send TAKE_PICTURE") != 1 {
+		t.Fatalf("script continued after an error:\n%s", output)
+	}
+}
+
+func TestShellScriptHistory(t *testing.T) {
+	camera, _ := startShellCamera(t)
+
+	script := "send TAKE_PICTURE\n!!\n!1\n"
+	output := &bytes.Buffer{}
+	if err := newShell(camera, output, false).run(context.Background(), strings.NewReader(script)); err != nil {
+		t.Fatalf("run: %s\n%s", err, output)
+	}
+	text := output.String()
+	if strings.Count(text, "> send TAKE_PICTURE") != 3 || strings.Contains(text, "> !") || strings.Contains(text, "ERRO") {
+		t.Fatalf("expanded lines not echoed once each:\n%s", text)
+	}
+}
+
+func TestShellRawRequestSkipsModelProfile(t *testing.T) {
+	camera, emu := startShellCamera(t)
+	if profile, ok := camera.ModelProfile(); !ok || profile.Model != "SJ4000AIR" {
+		t.Fatalf("SJ4000AIR profile not selected: %+v", profile)
+	}
+	emu.Handle(0xA0F0, func(payload []byte) (uint32, []byte) { return 0xA0F1, nil })
+
+	output := &bytes.Buffer{}
+	if err := newShell(camera, output, false).run(context.Background(), strings.NewReader("request 0xA0F0 0xA0F1\n")); err != nil {
+		t.Fatalf("run: %s\n%s", err, output)
+	}
+	if emu.Received(0xA0F0) != 1 {
+		t.Fatalf("raw request not sent:\n%s", output)
+	}
+}